@@ -36,6 +36,15 @@ func (v *CacheValue[T]) Set(value T) {
 	v.set(value)
 }
 
+// Reset empties the cache: the next Get will call the origin function
+func (v *CacheValue[T]) Reset() {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.last = time.Time{}
+	v.value = *new(T)
+}
+
 func (v *CacheValue[T]) GetCached() (T, bool) {
 	v.mutex.Lock()
 	defer v.mutex.Unlock()
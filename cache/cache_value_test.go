@@ -3,6 +3,7 @@ package cache
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -110,3 +111,17 @@ func TestGetIntValueFromOriginOnlyOnce(t *testing.T) {
 	assert.Equal(t, 113, result)
 	assert.True(t, value.HasValue())
 }
+
+func TestResetIntValue(t *testing.T) {
+	value := NewCacheValue[int](time.Hour)
+	value.Set(113)
+	assert.True(t, value.HasValue())
+
+	value.Reset()
+	assert.False(t, value.HasValue())
+	result, err := value.Get(func() (int, error) {
+		return 114, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 114, result)
+}
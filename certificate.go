@@ -0,0 +1,113 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+)
+
+// newServerTLSConfig returns nil when no certificate is configured.
+// When a CA is configured, the clients must present a certificate signed by it.
+func newServerTLSConfig(config cfg.TLS) (*tls.Config, error) {
+	if config.CertFile == "" && config.KeyFile == "" {
+		if config.CAFile != "" {
+			return nil, errors.New("a server certificate is needed to verify the client certificates")
+		}
+		return nil, nil
+	}
+	reloader, err := newCertificateReloader(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load CA certificates: %w", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", config.CAFile)
+		}
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// certificateReloader loads the certificate again when the files have changed (after a renewal)
+type certificateReloader struct {
+	certFile    string
+	keyFile     string
+	mutex       sync.Mutex
+	certificate *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+}
+
+func newCertificateReloader(certFile, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	certModTime, keyModTime, err := reloader.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	err = reloader.load(certModTime, keyModTime)
+	if err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// GetCertificate is called on each TLS handshake
+func (r *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	certModTime, keyModTime, err := r.modTimes()
+	if err == nil && (!certModTime.Equal(r.certModTime) || !keyModTime.Equal(r.keyModTime)) {
+		err = r.load(certModTime, keyModTime)
+		if err == nil {
+			clog.Infof("reloaded certificate %s", r.certFile)
+		}
+	}
+	if err != nil {
+		// keep the previous certificate: the files may be in the middle of being replaced
+		clog.Warningf("cannot reload certificate: %s", err)
+	}
+	return r.certificate, nil
+}
+
+func (r *certificateReloader) load(certModTime, keyModTime time.Time) error {
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load server certificate: %w", err)
+	}
+	r.certificate = &certificate
+	r.certModTime = certModTime
+	r.keyModTime = keyModTime
+	return nil
+}
+
+func (r *certificateReloader) modTimes() (time.Time, time.Time, error) {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("cannot load server certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return time.Time{}, time.Time{}, fmt.Errorf("cannot load server key: %w", err)
+	}
+	return certInfo.ModTime(), keyInfo.ModTime(), nil
+}
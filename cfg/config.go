@@ -15,34 +15,93 @@ type Config struct {
 	Seed2           uint64                     `yaml:"simulation_seed2"`
 	DiskPowerStatus map[string]DiskPowerStatus `yaml:"disk_power_status"`
 	Sensors         map[string]Task            `yaml:"sensors"`
-	DiskPools       map[string][]string        `yaml:"disk_pools"`
+	DiskPools       map[string]DiskPool        `yaml:"disk_pools"`
 	Disks           map[string]Disk            `yaml:"disks"`
+	DiskDiscovery   DiskDiscovery              `yaml:"disk_discovery"`
+	Hotplug         bool                       `yaml:"hotplug"`
+	ZFS             ZFS                        `yaml:"zfs"`
 	Templates       map[string]Template        `yaml:"templates"`
 	Tasks           map[string]Task            `yaml:"tasks"`
 	Schedule        map[string]Schedule        `yaml:"schedule"`
 	FanControl      FanControl                 `yaml:"fan_control"`
 	Telemetry       Telemetry                  `yaml:"telemetry"`
+	StateFile       string                     `yaml:"state_file"`
+	StateSaveEvery  string                     `yaml:"state_save_every"`
+	StateMaxAge     string                     `yaml:"state_max_age"`
+	Diskstats       Diskstats                  `yaml:"diskstats"`
+	Alerts          Alerts                     `yaml:"alerts"`
+	Critical        map[string]Critical        `yaml:"critical"` // by sensor or disk name
+	Log             Log                        `yaml:"log"`
 }
 
 type DiskPowerStatus struct {
+	Type           string `yaml:"type"`
+	Protocol       string `yaml:"protocol"`
 	File           string `yaml:"file"`
 	CheckCommand   string `yaml:"check_command"`
 	Active         string `yaml:"active"`
 	Standby        string `yaml:"standby"`
 	Sleeping       string `yaml:"sleeping"`
 	StandbyCommand string `yaml:"standby_command"`
+	WakeCommand    string `yaml:"wake_command"`
 	Timeout        string `yaml:"timeout"`
 }
 
+// DiskPool configuration. It can also be loaded from a simple list of disks
+type DiskPool struct {
+	Disks          []string `yaml:"disks"`
+	StandbyAfter   string   `yaml:"standby_after"`
+	CheckEvery     string   `yaml:"check_every"`
+	WakeTogether   bool     `yaml:"wake_together"`
+	WakeCheckEvery string   `yaml:"wake_check_every"`
+	ZFS            string   `yaml:"zfs"` // name of the ZFS pool: its disks are added to the pool automatically
+	Inhibit        Inhibit  `yaml:"inhibit"`
+}
+
+// UnmarshalYAML accepts either a list of disks or the full pool configuration
+func (p *DiskPool) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.SequenceNode {
+		return value.Decode(&p.Disks)
+	}
+	type diskPool DiskPool // prevents recursion
+	return value.Decode((*diskPool)(p))
+}
+
 // Disk configuration
 type Disk struct {
-	Device             string `yaml:"device"`
-	TemperatureSensor  string `yaml:"temperature_sensor"`
-	MonitorTemperature string `yaml:"monitor_temperature"`
-	LastActive         string `yaml:"last_active"`
-	StandbyAfter       string `yaml:"standby_after"`
-	PowerStatus        string `yaml:"power_status"`
-	CheckEvery         string `yaml:"check_every"`
+	Device             string  `yaml:"device"`
+	TemperatureSensor  string  `yaml:"temperature_sensor"`
+	SmartSensor        string  `yaml:"smart_sensor"`
+	MonitorTemperature string  `yaml:"monitor_temperature"`
+	LastActive         string  `yaml:"last_active"`
+	StandbyAfter       string  `yaml:"standby_after"`
+	PowerStatus        string  `yaml:"power_status"`
+	CheckEvery         string  `yaml:"check_every"`
+	SmartEvery         string  `yaml:"smart_every"`
+	MaxTemperature     int     `yaml:"max_temperature"`
+	MaxSpinUpsPerDay   int     `yaml:"max_spinups_per_day"`
+	Inhibit            Inhibit `yaml:"inhibit"`
+}
+
+// Inhibit prevents the disks from being put in standby mode
+type Inhibit struct {
+	Windows   []string `yaml:"windows"`   // time of day like "01:00-05:30", optionally preceded by the days like "Sat,Sun 22:00-02:00"
+	Processes []string `yaml:"processes"` // name of the processes (glob patterns are accepted)
+	LockFile  string   `yaml:"lock_file"`
+}
+
+// DiskDiscovery finds the disks matching the rules and configures them with the profile
+type DiskDiscovery struct {
+	Enabled       bool     `yaml:"enabled"`
+	Source        string   `yaml:"source"` // "by-id" (default) or "sysfs"
+	Include       []string `yaml:"include"`
+	Exclude       []string `yaml:"exclude"`
+	IncludeRegexp []string `yaml:"include_regexp"`
+	ExcludeRegexp []string `yaml:"exclude_regexp"`
+	Models        []string `yaml:"models"`
+	Serials       []string `yaml:"serials"`
+	RescanEvery   string   `yaml:"rescan_every"`
+	Profile       Disk     `yaml:"profile"`
 }
 
 type Template struct {
@@ -50,8 +109,10 @@ type Template struct {
 }
 
 type Task struct {
+	Type        string   `yaml:"type"`
 	Command     string   `yaml:"command"`
 	File        string   `yaml:"file"`
+	Sensor      string   `yaml:"sensor"`
 	Files       []string `yaml:"files"`
 	Stdin       Source   `yaml:"stdin"`
 	Regexp      string   `yaml:"regexp"`
@@ -114,13 +175,161 @@ type SetFromTo struct {
 	To   int `yaml:"to"`
 }
 
+// ZFS configures the commands reading the state of the ZFS pools
+type ZFS struct {
+	Enabled       bool   `yaml:"enabled"`
+	StatusCommand string `yaml:"status_command"`
+	ListCommand   string `yaml:"list_command"`
+	CheckEvery    string `yaml:"check_every"`
+	Timeout       string `yaml:"timeout"`
+}
+
+// Diskstats configures the sampling of /proc/diskstats
+type Diskstats struct {
+	SampleEvery string `yaml:"sample_every"`
+}
+
 type Telemetry struct {
 	Prometheus Prometheus `yaml:"prometheus"`
+	OTLP       OTLP       `yaml:"otlp"`
+	MQTT       MQTT       `yaml:"mqtt"`
+	InfluxDB   InfluxDB   `yaml:"influxdb"`
+	Graphite   Graphite   `yaml:"graphite"`
 }
 
 type Prometheus struct {
-	Enabled bool   `yaml:"enabled"`
-	Listen  string `yaml:"listen_address"`
+	Enabled     bool   `yaml:"enabled"`
+	Listen      string `yaml:"listen_address"`
+	Path        string `yaml:"path"` // default "/metrics"
+	Username    string `yaml:"username"`
+	Password    string `yaml:"password"`
+	BearerToken string `yaml:"bearer_token"`
+	TLS         TLS    `yaml:"tls"` // serves HTTPS when a certificate is configured; the CA verifies the client certificates
+}
+
+// OTLP pushes the metrics to an OpenTelemetry collector
+type OTLP struct {
+	Enabled     bool              `yaml:"enabled"`
+	Protocol    string            `yaml:"protocol"` // "grpc" (default) or "http"
+	Endpoint    string            `yaml:"endpoint"` // host:port or full URL
+	URLPath     string            `yaml:"url_path"` // http only, default is /v1/metrics
+	Headers     map[string]string `yaml:"headers"`
+	Compression string            `yaml:"compression"` // "gzip" or "none"
+	Interval    string            `yaml:"interval"`
+	Timeout     string            `yaml:"timeout"`
+	Insecure    bool              `yaml:"insecure"` // no TLS
+	TLS         TLS               `yaml:"tls"`
+}
+
+// InfluxDB pushes the metrics to the InfluxDB v2 HTTP write API
+type InfluxDB struct {
+	Enabled    bool              `yaml:"enabled"`
+	URL        string            `yaml:"url"` // like http://influxdb:8086
+	Org        string            `yaml:"org"`
+	Bucket     string            `yaml:"bucket"`
+	Token      string            `yaml:"token"`
+	Tags       map[string]string `yaml:"tags"` // added to all the points
+	Interval   string            `yaml:"interval"`
+	Timeout    string            `yaml:"timeout"`
+	BatchSize  int               `yaml:"batch_size"`  // maximum number of lines per request
+	MaxRetries int               `yaml:"max_retries"` // when the server is unavailable
+	TLS        TLS               `yaml:"tls"`
+}
+
+// Graphite pushes the metrics using the plaintext protocol
+type Graphite struct {
+	Enabled  bool   `yaml:"enabled"`
+	Address  string `yaml:"address"`  // host:port
+	Protocol string `yaml:"protocol"` // "tcp" (default) or "udp"
+	Prefix   string `yaml:"prefix"`
+	Tagged   bool   `yaml:"tagged"` // use the graphite tags instead of adding the attributes to the path
+	Interval string `yaml:"interval"`
+	Timeout  string `yaml:"timeout"`
+}
+
+// MQTT publishes the sensors, disks and zones to a broker, with Home Assistant discovery
+type MQTT struct {
+	Enabled         bool   `yaml:"enabled"`
+	Broker          string `yaml:"broker"` // like tcp://localhost:1883 or ssl://broker:8883
+	ClientID        string `yaml:"client_id"`
+	Username        string `yaml:"username"`
+	Password        string `yaml:"password"`
+	TopicPrefix     string `yaml:"topic_prefix"`
+	HomeAssistant   bool   `yaml:"home_assistant"`
+	DiscoveryPrefix string `yaml:"discovery_prefix"`
+	PublishEvery    string `yaml:"publish_every"`
+	Commands        bool   `yaml:"commands"` // accept manual fan speed overrides
+	TLS             TLS    `yaml:"tls"`
+}
+
+// TLS options of a connection
+type TLS struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// Log configures the output of the logs
+type Log struct {
+	Format     string            `yaml:"format"`      // text (default) or json
+	Level      string            `yaml:"level"`       // trace, debug, info (default), warning or error. Overridden by the command line flags
+	Levels     map[string]string `yaml:"levels"`      // by zone, sensor, disk, pool, task or command name (or "key=name", like "sensor=cpu")
+	File       string            `yaml:"file"`        // console when empty
+	MaxSize    int               `yaml:"max_size"`    // in MB before rotating the file
+	MaxAge     string            `yaml:"max_age"`     // duration before rotating the file
+	MaxBackups int               `yaml:"max_backups"` // number of rotated files to keep, all of them when zero
+}
+
+// Critical is the emergency policy when a sensor or a disk stays above a critical temperature
+type Critical struct {
+	Temperature int      `yaml:"temperature"`
+	Release     int      `yaml:"release"`     // temperature to go back to before releasing the fans, default 5°C below the critical temperature
+	For         string   `yaml:"for"`         // how long above the temperature before taking action
+	CheckEvery  string   `yaml:"check_every"` // default 10s
+	Command     string   `yaml:"command"`     // like "zpool export tank" or "poweroff"
+	Timeout     string   `yaml:"timeout"`
+	Standby     []string `yaml:"standby"`  // disks to put in standby mode
+	Cooldown    string   `yaml:"cooldown"` // minimum time before running the command and the standby again
+	DryRun      bool     `yaml:"dry_run"`  // only log the actions
+}
+
+// Alerts watches the hardware and sends notifications
+type Alerts struct {
+	CheckEvery  string               `yaml:"check_every"`
+	RepeatEvery string               `yaml:"repeat_every"` // notify again while an alert is still firing (never by default)
+	RateLimit   int                  `yaml:"rate_limit"`   // maximum number of notifications per hour and per notifier
+	Rules       map[string]AlertRule `yaml:"rules"`
+	Notifiers   map[string]Notifier  `yaml:"notifiers"`
+}
+
+type AlertRule struct {
+	Type     string   `yaml:"type"`     // "temperature", "fan_stalled", "smart_degraded", "sensor_unreadable" or "command_failing"
+	Sensors  []string `yaml:"sensors"`  // sensors or disks to watch (all of them when empty)
+	Disks    []string `yaml:"disks"`    // disks to watch for smart_degraded (all of them when empty)
+	Commands []string `yaml:"commands"` // commands to watch for command_failing (all of them when empty)
+	Above    int      `yaml:"above"`    // temperature threshold
+	Below    int      `yaml:"below"`    // fan speed threshold (RPM)
+	For      string   `yaml:"for"`      // how long the condition should last before firing
+	Count    int      `yaml:"count"`    // consecutive failures of a command
+	Severity string   `yaml:"severity"`
+	Notify   []string `yaml:"notify"` // names of the notifiers (all of them when empty)
+}
+
+type Notifier struct {
+	Type        string            `yaml:"type"` // "webhook" or "smtp"
+	URL         string            `yaml:"url"`
+	Headers     map[string]string `yaml:"headers"`
+	Template    string            `yaml:"template"` // name of the template building the webhook body or the email text
+	Timeout     string            `yaml:"timeout"`
+	Address     string            `yaml:"address"`      // SMTP server host:port
+	ImplicitTLS bool              `yaml:"implicit_tls"` // TLS from the start of the SMTP connection instead of STARTTLS (always on port 465)
+	Username    string            `yaml:"username"`
+	Password    string            `yaml:"password"`
+	From        string            `yaml:"from"`
+	To          []string          `yaml:"to"`
+	TLS         TLS               `yaml:"tls"`
 }
 
 // LoadFileConfig loads the configuration from the file
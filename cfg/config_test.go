@@ -30,3 +30,24 @@ disks:
 	assert.NoError(t, err)
 	assert.Len(t, config.Disks, 2)
 }
+
+func TestDiskPoolConfiguration(t *testing.T) {
+	content := `---
+disk_pools:
+  rpool:
+    - rpool1
+    - rpool2
+  datapool:
+    disks:
+      - datapool1
+      - datapool2
+    standby_after: 1h
+    wake_together: true
+`
+	config, err := loadConfig(bytes.NewReader([]byte(content)))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]DiskPool{
+		"rpool":    {Disks: []string{"rpool1", "rpool2"}},
+		"datapool": {Disks: []string{"datapool1", "datapool2"}, StandbyAfter: "1h", WakeTogether: true},
+	}, config.DiskPools)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"strings"
+
+	"github.com/creativeprojects/hardware-events/lib"
+)
+
+var invalidKeyCharacters = regexp.MustCompile(`[^a-z0-9]+`)
+
+// discoverSensors prints a sensors section ready to paste into the configuration file
+func discoverSensors(output io.Writer, fileSystem fs.FS) error {
+	sensors, err := lib.DiscoverSensors(fileSystem)
+	if err != nil {
+		return err
+	}
+	if len(sensors) == 0 {
+		return fmt.Errorf("no temperature sensor found in sysfs")
+	}
+	_, _ = fmt.Fprintln(output, "sensors:")
+	for _, sensor := range sensors {
+		_, _ = fmt.Fprintf(output, "  %s:\n", sensorKey(sensor.Name))
+		_, _ = fmt.Fprintf(output, "    sensor: %q # currently %.1f°C\n", sensor.Name, float64(sensor.Value)/1000)
+		_, _ = fmt.Fprintln(output, "    divider: 1000")
+	}
+	return nil
+}
+
+// sensorKey converts a stable sensor name into a simple configuration key
+func sensorKey(name string) string {
+	return strings.Trim(invalidKeyCharacters.ReplaceAllString(strings.ToLower(name), "_"), "_")
+}
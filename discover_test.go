@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscoverSensors(t *testing.T) {
+	fileSystem := fstest.MapFS{
+		"sys/class/hwmon/hwmon0/name":          {Data: []byte("coretemp\n")},
+		"sys/class/hwmon/hwmon0/temp1_input":   {Data: []byte("45000\n")},
+		"sys/class/hwmon/hwmon0/temp1_label":   {Data: []byte("Package id 0\n")},
+		"sys/class/thermal/thermal_zone0/type": {Data: []byte("acpitz\n")},
+		"sys/class/thermal/thermal_zone0/temp": {Data: []byte("27800\n")},
+	}
+	buffer := &bytes.Buffer{}
+	err := discoverSensors(buffer, fileSystem)
+	require.NoError(t, err)
+
+	expected := `sensors:
+  coretemp_package_id_0:
+    sensor: "coretemp/Package id 0" # currently 45.0°C
+    divider: 1000
+  thermal_zone_acpitz:
+    sensor: "thermal_zone/acpitz" # currently 27.8°C
+    divider: 1000
+`
+	assert.Equal(t, expected, buffer.String())
+}
+
+func TestDiscoverNoSensor(t *testing.T) {
+	err := discoverSensors(&bytes.Buffer{}, fstest.MapFS{})
+	assert.Error(t, err)
+}
@@ -0,0 +1,540 @@
+package lib
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+)
+
+const (
+	AlertTemperature      = "temperature"
+	AlertFanStalled       = "fan_stalled"
+	AlertSmartDegraded    = "smart_degraded"
+	AlertSensorUnreadable = "sensor_unreadable"
+	AlertCommandFailing   = "command_failing"
+
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+
+	defaultAlertCheckEvery    = 30 * time.Second
+	defaultAlertCommandFailed = 3
+	// alertQueueSize is the number of notifications waiting for a slow notifier before they're dropped
+	alertQueueSize = 100
+)
+
+// Alert is the notification sent when a rule starts or stops matching a source (sensor, disk or command)
+type Alert struct {
+	Rule     string     `json:"rule"`
+	Type     string     `json:"type"`
+	Severity string     `json:"severity,omitempty"`
+	Source   string     `json:"source"`
+	Status   string     `json:"status"`
+	Message  string     `json:"message"`
+	Value    int        `json:"value"`
+	Host     string     `json:"host"`
+	StartsAt time.Time  `json:"starts_at"`
+	EndsAt   *time.Time `json:"ends_at,omitempty"`
+}
+
+// AlertNotifier delivers the alerts
+type AlertNotifier interface {
+	Notify(ctx context.Context, alert Alert) error
+}
+
+// Alerting checks the rules at regular intervals and notifies the alerts once when they start and when they're resolved
+type Alerting struct {
+	global         *Global
+	rules          []*alertRule
+	notifiers      map[string]AlertNotifier
+	timeouts       map[string]time.Duration // of each notifier
+	queues         map[string]chan Alert    // notifications waiting to be sent, by notifier
+	pending        sync.WaitGroup           // notifications queued and not sent yet
+	checkEvery     time.Duration
+	repeatEvery    time.Duration
+	rateLimit      int
+	host           string
+	mutex          sync.Mutex
+	states         map[string]*alertState // indexed by rule and source
+	sent           map[string][]time.Time // notifications sent during the last hour, by notifier
+	failures       map[string]int         // consecutive failures of each command
+	fanSensors     []string               // sensors of the fan_stalled rules, returning a speed instead of a temperature
+	removeObserver func()
+	done           chan struct{}
+}
+
+type alertRule struct {
+	name     string
+	config   cfg.AlertRule
+	duration time.Duration
+	notify   []string
+}
+
+type alertState struct {
+	since        time.Time // when the condition started
+	alert        Alert
+	firing       bool
+	lastNotified time.Time
+}
+
+// alertCondition is the result of a rule for one source
+type alertCondition struct {
+	source  string
+	active  bool
+	value   int
+	message string
+}
+
+// NewAlerting creates the rules and the notifiers from the configuration
+func NewAlerting(global *Global, config cfg.Alerts) (*Alerting, error) {
+	var err error
+	alerting := &Alerting{
+		global:    global,
+		rules:     make([]*alertRule, 0, len(config.Rules)),
+		notifiers: make(map[string]AlertNotifier, len(config.Notifiers)),
+		timeouts:  make(map[string]time.Duration, len(config.Notifiers)),
+		rateLimit: config.RateLimit,
+		states:    make(map[string]*alertState),
+		sent:      make(map[string][]time.Time),
+		failures:  make(map[string]int),
+	}
+	alerting.host, _ = os.Hostname()
+	alerting.checkEvery, err = parseDurationOrDefault(config.CheckEvery, defaultAlertCheckEvery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alerts check_every: %w", err)
+	}
+	alerting.repeatEvery, err = parseDurationOrDefault(config.RepeatEvery, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid alerts repeat_every: %w", err)
+	}
+	for name, notifierConfig := range config.Notifiers {
+		notifier, err := newAlertNotifier(global, name, notifierConfig)
+		if err != nil {
+			return nil, err
+		}
+		alerting.notifiers[name] = notifier
+		// already validated by the notifier
+		alerting.timeouts[name], _ = parseDurationOrDefault(notifierConfig.Timeout, defaultPushTimeout)
+	}
+	// keep the same order between checks
+	for _, name := range slices.Sorted(maps.Keys(config.Rules)) {
+		rule, err := alerting.newRule(name, config.Rules[name])
+		if err != nil {
+			return nil, fmt.Errorf("alert rule %s: %w", name, err)
+		}
+		alerting.rules = append(alerting.rules, rule)
+		if rule.config.Type == AlertFanStalled {
+			alerting.fanSensors = append(alerting.fanSensors, rule.config.Sensors...)
+		}
+	}
+	return alerting, nil
+}
+
+func (a *Alerting) newRule(name string, config cfg.AlertRule) (*alertRule, error) {
+	var err error
+	rule := &alertRule{
+		name:   name,
+		config: config,
+		notify: config.Notify,
+	}
+	rule.duration, err = parseDurationOrDefault(config.For, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration: %w", err)
+	}
+	switch config.Type {
+	case AlertTemperature:
+		if config.Above <= 0 {
+			return nil, errors.New("missing temperature threshold (above)")
+		}
+	case AlertFanStalled:
+		if config.Below <= 0 {
+			return nil, errors.New("missing fan speed threshold (below)")
+		}
+		if len(config.Sensors) == 0 {
+			return nil, errors.New("missing fan sensors")
+		}
+	case AlertSmartDegraded, AlertSensorUnreadable:
+	case AlertCommandFailing:
+		if rule.config.Count <= 0 {
+			rule.config.Count = defaultAlertCommandFailed
+		}
+	default:
+		return nil, fmt.Errorf("unknown alert type %q", config.Type)
+	}
+	if len(rule.notify) == 0 {
+		rule.notify = slices.Sorted(maps.Keys(a.notifiers))
+	}
+	for _, notifier := range rule.notify {
+		if _, ok := a.notifiers[notifier]; !ok {
+			return nil, fmt.Errorf("notifier %q not found", notifier)
+		}
+	}
+	return rule, nil
+}
+
+// Start watches the commands and checks the rules in the background
+func (a *Alerting) Start() {
+	a.startNotifiers()
+	a.removeObserver = AddCommandObserver(a.observeCommand)
+	done := make(chan struct{})
+	a.done = done
+	heartbeat := a.global.Health.Register("alerts", false)
+	go func() {
+		ticker := time.NewTicker(a.checkEvery)
+		defer ticker.Stop()
+		for {
+			heartbeat.Beat(2*a.checkEvery + time.Minute)
+			select {
+			case <-done:
+				heartbeat.Done()
+				return
+			case <-ticker.C:
+				a.check(time.Now())
+			}
+		}
+	}()
+}
+
+// Stop the background checks, and the notifiers once they've sent the notifications in progress
+func (a *Alerting) Stop() {
+	a.stopNotifiers()
+	if a.done == nil {
+		return
+	}
+	a.removeObserver()
+	close(a.done)
+	a.done = nil
+}
+
+// startNotifiers starts a goroutine sending the notifications of each notifier in order,
+// so a slow notifier never blocks the checks nor the other notifiers
+func (a *Alerting) startNotifiers() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.queues != nil {
+		return
+	}
+	a.queues = make(map[string]chan Alert, len(a.notifiers))
+	for name := range a.notifiers {
+		queue := make(chan Alert, alertQueueSize)
+		a.queues[name] = queue
+		go a.deliver(name, queue)
+	}
+}
+
+func (a *Alerting) stopNotifiers() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	for _, queue := range a.queues {
+		close(queue)
+	}
+	a.queues = nil
+}
+
+// deliver sends the notifications of the queue until it's closed
+func (a *Alerting) deliver(name string, queue <-chan Alert) {
+	timeout := cmp.Or(a.timeouts[name], defaultPushTimeout)
+	for alert := range queue {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := a.notifiers[name].Notify(ctx, alert)
+		cancel()
+		if err != nil {
+			clog.Errorf("notifier %s: %s", name, err)
+		}
+		a.pending.Done()
+	}
+}
+
+// enqueue passes the alert to the goroutine of the notifier, unless its queue is full
+func (a *Alerting) enqueue(name string, alert Alert) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	queue, ok := a.queues[name]
+	if !ok {
+		clog.Warningf("notifier %s: not started, alert %s on %s not sent", name, alert.Rule, alert.Source)
+		return
+	}
+	a.pending.Add(1)
+	select {
+	case queue <- alert:
+	default:
+		a.pending.Done()
+		clog.Warningf("notifier %s: too many notifications waiting, alert %s on %s not sent", name, alert.Rule, alert.Source)
+	}
+}
+
+// Firing returns the alerts currently firing
+func (a *Alerting) Firing() []Alert {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	alerts := make([]Alert, 0)
+	for _, key := range slices.Sorted(maps.Keys(a.states)) {
+		if state := a.states[key]; state.firing {
+			alerts = append(alerts, state.alert)
+		}
+	}
+	return alerts
+}
+
+func (a *Alerting) observeCommand(name string, exitStatus int, _ time.Duration) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if exitStatus == 0 {
+		delete(a.failures, name)
+		return
+	}
+	a.failures[name]++
+}
+
+// check evaluates all the rules and sends the notifications
+func (a *Alerting) check(now time.Time) {
+	readings := make(map[string]sensorReading)
+	for _, rule := range a.rules {
+		for _, condition := range a.evaluate(rule, readings) {
+			alert, notify := a.update(rule, condition, now)
+			if notify {
+				a.notify(rule, alert, now)
+			}
+		}
+	}
+}
+
+// update returns the alert to send, if any
+func (a *Alerting) update(rule *alertRule, condition alertCondition, now time.Time) (Alert, bool) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	key := rule.name + "/" + condition.source
+	state, found := a.states[key]
+	if !condition.active {
+		if !found {
+			return Alert{}, false
+		}
+		delete(a.states, key)
+		if !state.firing {
+			return Alert{}, false
+		}
+		alert := state.alert
+		alert.Status = AlertResolved
+		alert.Message = condition.message
+		alert.Value = condition.value
+		alert.EndsAt = &now
+		return alert, true
+	}
+	if !found {
+		state = &alertState{since: now}
+		a.states[key] = state
+	}
+	state.alert = Alert{
+		Rule:     rule.name,
+		Type:     rule.config.Type,
+		Severity: rule.config.Severity,
+		Source:   condition.source,
+		Status:   AlertFiring,
+		Message:  condition.message,
+		Value:    condition.value,
+		Host:     a.host,
+		StartsAt: state.since,
+	}
+	if now.Sub(state.since) < rule.duration {
+		// pending
+		return Alert{}, false
+	}
+	if state.firing && (a.repeatEvery == 0 || now.Sub(state.lastNotified) < a.repeatEvery) {
+		// already notified
+		return Alert{}, false
+	}
+	state.firing = true
+	state.lastNotified = now
+	return state.alert, true
+}
+
+func (a *Alerting) notify(rule *alertRule, alert Alert, now time.Time) {
+	clog.Warningf("alert %s %s: %s: %s", alert.Rule, alert.Status, alert.Source, alert.Message)
+	for _, name := range rule.notify {
+		if !a.allowNotification(name, now) {
+			clog.Warningf("notifier %s: rate limit reached, alert %s on %s not sent", name, alert.Rule, alert.Source)
+			continue
+		}
+		a.enqueue(name, alert)
+	}
+}
+
+// allowNotification records the notification unless the notifier has reached its rate limit
+func (a *Alerting) allowNotification(notifier string, now time.Time) bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.rateLimit <= 0 {
+		return true
+	}
+	sent := slices.DeleteFunc(a.sent[notifier], func(at time.Time) bool {
+		return now.Sub(at) >= time.Hour
+	})
+	if len(sent) >= a.rateLimit {
+		a.sent[notifier] = sent
+		return false
+	}
+	a.sent[notifier] = append(sent, now)
+	return true
+}
+
+type sensorReading struct {
+	value int
+	err   error
+	known bool // false when the temperature of the disk is not monitored right now
+}
+
+// evaluate returns the conditions of the rule for all the sources it's watching
+func (a *Alerting) evaluate(rule *alertRule, readings map[string]sensorReading) []alertCondition {
+	switch rule.config.Type {
+	case AlertTemperature, AlertFanStalled:
+		return a.evaluateThreshold(rule, readings)
+	case AlertSensorUnreadable:
+		return a.evaluateUnreadable(rule, readings)
+	case AlertSmartDegraded:
+		return a.evaluateSmart(rule)
+	case AlertCommandFailing:
+		return a.evaluateCommands(rule)
+	}
+	return nil
+}
+
+func (a *Alerting) evaluateThreshold(rule *alertRule, readings map[string]sensorReading) []alertCondition {
+	sources := rule.config.Sensors
+	if len(sources) == 0 {
+		sources = slices.DeleteFunc(a.allSensors(true), func(name string) bool {
+			return slices.Contains(a.fanSensors, name)
+		})
+	}
+	conditions := make([]alertCondition, 0, len(sources))
+	for _, source := range sources {
+		reading := a.read(source, readings)
+		if !reading.known || reading.err != nil {
+			// keep the current state
+			continue
+		}
+		condition := alertCondition{source: source, value: reading.value}
+		if rule.config.Type == AlertTemperature {
+			condition.active = reading.value > rule.config.Above
+			condition.message = fmt.Sprintf("temperature is %d°C (threshold %d°C)", reading.value, rule.config.Above)
+		} else {
+			condition.active = reading.value < rule.config.Below
+			condition.message = fmt.Sprintf("fan speed is %d RPM (threshold %d RPM)", reading.value, rule.config.Below)
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions
+}
+
+func (a *Alerting) evaluateUnreadable(rule *alertRule, readings map[string]sensorReading) []alertCondition {
+	sources := rule.config.Sensors
+	if len(sources) == 0 {
+		sources = a.allSensors(false)
+	}
+	conditions := make([]alertCondition, 0, len(sources))
+	for _, source := range sources {
+		reading := a.read(source, readings)
+		if !reading.known {
+			continue
+		}
+		condition := alertCondition{source: source, value: reading.value, message: "sensor is readable again"}
+		if reading.err != nil {
+			condition.active = true
+			condition.message = reading.err.Error()
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions
+}
+
+func (a *Alerting) evaluateSmart(rule *alertRule) []alertCondition {
+	disks := a.global.Disks()
+	names := rule.config.Disks
+	if len(names) == 0 {
+		names = slices.Sorted(maps.Keys(disks))
+	}
+	conditions := make([]alertCondition, 0, len(names))
+	for _, name := range names {
+		disk, ok := disks[name]
+		if !ok {
+			continue
+		}
+		alerts := disk.Health().Alerts
+		condition := alertCondition{source: name, value: len(alerts), message: "SMART data is healthy"}
+		if len(alerts) > 0 {
+			condition.active = true
+			condition.message = strings.Join(alerts, ", ")
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions
+}
+
+func (a *Alerting) evaluateCommands(rule *alertRule) []alertCondition {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	names := rule.config.Commands
+	if len(names) == 0 {
+		// the commands which failed, and the ones with an alert to resolve
+		names = slices.Collect(maps.Keys(a.failures))
+		for _, state := range a.states {
+			if state.alert.Rule == rule.name && !slices.Contains(names, state.alert.Source) {
+				names = append(names, state.alert.Source)
+			}
+		}
+		slices.Sort(names)
+	}
+	conditions := make([]alertCondition, 0, len(names))
+	for _, name := range names {
+		failures := a.failures[name]
+		condition := alertCondition{source: name, value: failures, message: "command succeeded"}
+		if failures >= rule.config.Count {
+			condition.active = true
+			condition.message = fmt.Sprintf("command failed %d times in a row", failures)
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions
+}
+
+// allSensors returns the names of the configured sensors, and the disks with a temperature sensor
+func (a *Alerting) allSensors(withDisks bool) []string {
+	names := slices.Collect(maps.Keys(a.global.TemperatureSensors))
+	if withDisks {
+		for name, disk := range a.global.Disks() {
+			if disk.config.TemperatureSensor != "" {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// read returns the value of a sensor or the temperature of a disk, reading each one only once per check.
+// The values recently read by the fan control are used instead of reading the sensor again
+func (a *Alerting) read(name string, readings map[string]sensorReading) sensorReading {
+	if reading, ok := readings[name]; ok {
+		return reading
+	}
+	reading := sensorReading{}
+	reading.value, reading.known, reading.err = a.global.readTemperature(name, a.checkEvery)
+	readings[name] = reading
+	return reading
+}
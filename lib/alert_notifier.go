@@ -0,0 +1,250 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+)
+
+const (
+	NotifierWebhook = "webhook"
+	NotifierSMTP    = "smtp"
+
+	// smtpsPort is the port of the SMTP servers only accepting TLS connections
+	smtpsPort = "465"
+)
+
+func newAlertNotifier(global *Global, name string, config cfg.Notifier) (AlertNotifier, error) {
+	timeout, err := parseDurationOrDefault(config.Timeout, defaultPushTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("notifier %s: invalid timeout: %w", name, err)
+	}
+	if config.Template != "" {
+		if _, ok := global.Templates[config.Template]; !ok || global.templ == nil {
+			return nil, fmt.Errorf("notifier %s: template %q not found", name, config.Template)
+		}
+	}
+	tlsConfig, err := newTLSClientConfig(config.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("notifier %s: %w", name, err)
+	}
+	switch config.Type {
+	case NotifierWebhook:
+		if config.URL == "" {
+			return nil, fmt.Errorf("notifier %s: missing url", name)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		return &webhookNotifier{
+			global:   global,
+			client:   &http.Client{Transport: transport, Timeout: timeout},
+			url:      config.URL,
+			headers:  config.Headers,
+			template: config.Template,
+		}, nil
+	case NotifierSMTP:
+		host, port, err := net.SplitHostPort(config.Address)
+		if err != nil {
+			return nil, fmt.Errorf("notifier %s: invalid address: %w", name, err)
+		}
+		if config.From == "" || len(config.To) == 0 {
+			return nil, fmt.Errorf("notifier %s: missing from or to address", name)
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = host
+		}
+		return &smtpNotifier{
+			global:      global,
+			address:     config.Address,
+			host:        host,
+			username:    config.Username,
+			password:    config.Password,
+			from:        config.From,
+			to:          config.To,
+			template:    config.Template,
+			timeout:     timeout,
+			tlsConfig:   tlsConfig,
+			implicitTLS: config.ImplicitTLS || port == smtpsPort,
+		}, nil
+	default:
+		return nil, fmt.Errorf("notifier %s: unknown type %q", name, config.Type)
+	}
+}
+
+// executeAlertTemplate runs the template configured for the notifier with the alert as data
+func executeAlertTemplate(global *Global, name string, alert Alert) ([]byte, error) {
+	buffer := &bytes.Buffer{}
+	err := global.templ.ExecuteTemplate(buffer, global.Templates[name].ID, alert)
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// webhookNotifier posts the alert in JSON, or using the template
+type webhookNotifier struct {
+	global   *Global
+	client   *http.Client
+	url      string
+	headers  map[string]string
+	template string
+}
+
+func (n *webhookNotifier) Notify(ctx context.Context, alert Alert) error {
+	var body []byte
+	var err error
+	if n.template != "" {
+		body, err = executeAlertTemplate(n.global, n.template, alert)
+	} else {
+		body, err = json.Marshal(alert)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot build webhook body: %w", err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range n.headers {
+		request.Header.Set(key, value)
+	}
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return fmt.Errorf("webhook returned %s: %s", response.Status, strings.TrimSpace(string(message)))
+	}
+	_, _ = io.Copy(io.Discard, response.Body)
+	return nil
+}
+
+// smtpNotifier sends the alert by email, using STARTTLS when the server supports it, or over a TLS connection
+type smtpNotifier struct {
+	global      *Global
+	address     string
+	host        string
+	username    string
+	password    string
+	from        string
+	to          []string
+	template    string
+	timeout     time.Duration
+	tlsConfig   *tls.Config
+	implicitTLS bool // TLS from the start of the connection instead of STARTTLS
+}
+
+func (n *smtpNotifier) Notify(ctx context.Context, alert Alert) error {
+	message, err := n.message(alert)
+	if err != nil {
+		return fmt.Errorf("cannot build email: %w", err)
+	}
+	dialer := &net.Dialer{Timeout: n.timeout}
+	var conn net.Conn
+	if n.implicitTLS {
+		tlsDialer := &tls.Dialer{NetDialer: dialer, Config: n.tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", n.address)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", n.address)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(n.timeout))
+	client, err := smtp.NewClient(conn, n.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && !n.implicitTLS {
+		err = client.StartTLS(n.tlsConfig)
+		if err != nil {
+			return err
+		}
+	}
+	if n.username != "" {
+		err = client.Auth(smtp.PlainAuth("", n.username, n.password, n.host))
+		if err != nil {
+			return err
+		}
+	}
+	err = client.Mail(n.from)
+	if err != nil {
+		return err
+	}
+	for _, to := range n.to {
+		err = client.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(message)
+	if err != nil {
+		return err
+	}
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+func (n *smtpNotifier) message(alert Alert) ([]byte, error) {
+	var body []byte
+	var err error
+	if n.template != "" {
+		body, err = executeAlertTemplate(n.global, n.template, alert)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		body = []byte(alertText(alert))
+	}
+	subject := fmt.Sprintf("[%s] %s on %s", strings.ToUpper(alert.Status), alert.Rule, alert.Source)
+	if alert.Host != "" {
+		subject += " (" + alert.Host + ")"
+	}
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "From: %s\r\n", n.from)
+	fmt.Fprintf(buffer, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(buffer, "Subject: %s\r\n", subject)
+	fmt.Fprintf(buffer, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	buffer.Write(bytes.ReplaceAll(bytes.ReplaceAll(body, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n")))
+	return buffer.Bytes(), nil
+}
+
+func alertText(alert Alert) string {
+	builder := &strings.Builder{}
+	fmt.Fprintf(builder, "Alert %s is %s on %s: %s\n\n", alert.Rule, alert.Status, alert.Source, alert.Message)
+	fmt.Fprintf(builder, "Type: %s\n", alert.Type)
+	if alert.Severity != "" {
+		fmt.Fprintf(builder, "Severity: %s\n", alert.Severity)
+	}
+	fmt.Fprintf(builder, "Started: %s\n", alert.StartsAt.Format(time.RFC1123))
+	if alert.EndsAt != nil {
+		fmt.Fprintf(builder, "Resolved: %s\n", alert.EndsAt.Format(time.RFC1123))
+	}
+	return builder.String()
+}
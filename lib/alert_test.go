@@ -0,0 +1,488 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// valueSensor returns a value which can be changed during the test
+type valueSensor struct {
+	mutex sync.Mutex
+	value int
+	err   error
+}
+
+func (s *valueSensor) Get(func(string) string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.value, s.err
+}
+
+func (s *valueSensor) set(value int, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.value, s.err = value, err
+}
+
+type recordingNotifier struct {
+	mutex  sync.Mutex
+	alerts []Alert
+	wait   func() // waits for the notifications in progress
+}
+
+func (n *recordingNotifier) Notify(_ context.Context, alert Alert) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.alerts = append(n.alerts, alert)
+	return nil
+}
+
+// statuses returns the status of the alerts received and forgets them
+func (n *recordingNotifier) statuses() []string {
+	if n.wait != nil {
+		n.wait()
+	}
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	statuses := make([]string, len(n.alerts))
+	for i, alert := range n.alerts {
+		statuses[i] = alert.Source + " " + alert.Status
+	}
+	n.alerts = nil
+	return statuses
+}
+
+// newTestAlerting sends all the alerts to the recording notifier
+func newTestAlerting(t *testing.T, global *Global, config cfg.Alerts) (*Alerting, *recordingNotifier) {
+	t.Helper()
+	alerting, err := NewAlerting(global, config)
+	require.NoError(t, err)
+	notifier := &recordingNotifier{wait: alerting.pending.Wait}
+	alerting.notifiers["test"] = notifier
+	for _, rule := range alerting.rules {
+		rule.notify = []string{"test"}
+	}
+	alerting.startNotifiers()
+	t.Cleanup(alerting.Stop)
+	return alerting, notifier
+}
+
+func TestTemperatureAlert(t *testing.T) {
+	cpu := &valueSensor{value: 50}
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	global.TemperatureSensors = map[string]SensorGetter{"cpu": cpu}
+	alerting, notifier := newTestAlerting(t, global, cfg.Alerts{
+		Rules: map[string]cfg.AlertRule{
+			"hot": {Type: AlertTemperature, Above: 60, For: "1m"},
+		},
+	})
+
+	start := time.Now()
+	alerting.check(start)
+	cpu.set(65, nil)
+	alerting.check(start.Add(time.Minute))
+	assert.Empty(t, notifier.statuses(), "pending")
+	alerting.check(start.Add(2 * time.Minute))
+	assert.Equal(t, []string{"cpu firing"}, notifier.statuses())
+	// deduplicated
+	alerting.check(start.Add(3 * time.Minute))
+	assert.Empty(t, notifier.statuses())
+	assert.Len(t, alerting.Firing(), 1)
+
+	// an unreadable sensor doesn't resolve the alert
+	cpu.set(0, errors.New("unreadable"))
+	alerting.check(start.Add(4 * time.Minute))
+	assert.Empty(t, notifier.statuses())
+
+	cpu.set(55, nil)
+	alerting.check(start.Add(5 * time.Minute))
+	assert.Equal(t, []string{"cpu resolved"}, notifier.statuses())
+	assert.Empty(t, alerting.Firing())
+
+	// back above the threshold but not for long enough
+	cpu.set(70, nil)
+	alerting.check(start.Add(6 * time.Minute))
+	cpu.set(50, nil)
+	alerting.check(start.Add(7 * time.Minute))
+	assert.Empty(t, notifier.statuses())
+}
+
+func TestTemperatureAlertOnAllSensors(t *testing.T) {
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	global.TemperatureSensors = map[string]SensorGetter{
+		"cpu":  &valueSensor{value: 50},
+		"fan1": &valueSensor{value: 1200},
+	}
+	// the last value read by the fan control is used instead of the sensor
+	global.sensorReadings = map[string]sensorValue{"cpu": {value: 75, readAt: time.Now()}}
+	alerting, notifier := newTestAlerting(t, global, cfg.Alerts{
+		Rules: map[string]cfg.AlertRule{
+			"hot":     {Type: AlertTemperature, Above: 70},
+			"stalled": {Type: AlertFanStalled, Sensors: []string{"fan1"}, Below: 300},
+		},
+	})
+
+	alerting.check(time.Now())
+	assert.Equal(t, []string{"cpu firing"}, notifier.statuses())
+}
+
+func TestAlertRepeatAndRateLimit(t *testing.T) {
+	fan := &valueSensor{value: 0}
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	global.TemperatureSensors = map[string]SensorGetter{"fan1": fan}
+	alerting, notifier := newTestAlerting(t, global, cfg.Alerts{
+		RepeatEvery: "10m",
+		RateLimit:   2,
+		Rules: map[string]cfg.AlertRule{
+			"stalled": {Type: AlertFanStalled, Sensors: []string{"fan1"}, Below: 300},
+		},
+	})
+
+	start := time.Now()
+	alerting.check(start)
+	alerting.check(start.Add(5 * time.Minute))
+	alerting.check(start.Add(10 * time.Minute))
+	assert.Equal(t, []string{"fan1 firing", "fan1 firing"}, notifier.statuses())
+	// rate limited
+	alerting.check(start.Add(20 * time.Minute))
+	assert.Empty(t, notifier.statuses())
+	// one hour after the first notification
+	alerting.check(start.Add(60 * time.Minute))
+	assert.Equal(t, []string{"fan1 firing"}, notifier.statuses())
+}
+
+func TestUnreadableSensorAndSmartAlerts(t *testing.T) {
+	sensor := &valueSensor{err: errors.New("no such file")}
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	global.TemperatureSensors = map[string]SensorGetter{"pch": sensor}
+	global.Disks()["first"].health.Alerts = []string{"reallocated sectors increased by 8"}
+	alerting, notifier := newTestAlerting(t, global, cfg.Alerts{
+		Rules: map[string]cfg.AlertRule{
+			"smart":      {Type: AlertSmartDegraded},
+			"unreadable": {Type: AlertSensorUnreadable},
+		},
+	})
+
+	alerting.check(time.Now())
+	assert.Equal(t, []string{"first firing", "pch firing"}, notifier.statuses())
+	firing := alerting.Firing()
+	require.Len(t, firing, 2)
+	assert.Equal(t, "reallocated sectors increased by 8", firing[0].Message)
+
+	sensor.set(42, nil)
+	global.Disks()["first"].health.Alerts = nil
+	alerting.check(time.Now())
+	assert.Equal(t, []string{"first resolved", "pch resolved"}, notifier.statuses())
+}
+
+func TestCommandFailingAlert(t *testing.T) {
+	alerting, notifier := newTestAlerting(t, newTestGlobal(t, map[string]string{"first": "/dev/sda"}), cfg.Alerts{
+		CheckEvery: "1h",
+		Rules: map[string]cfg.AlertRule{
+			"commands": {Type: AlertCommandFailing, Count: 2},
+		},
+	})
+	alerting.Start()
+	defer alerting.Stop()
+
+	command, err := newNamedCommand("failing_task", "exit 1", "", 0)
+	require.NoError(t, err)
+	_, _ = command.Run(nil, nil)
+	alerting.check(time.Now())
+	assert.Empty(t, notifier.statuses())
+	_, _ = command.Run(nil, nil)
+	alerting.check(time.Now())
+	assert.Equal(t, []string{"failing_task firing"}, notifier.statuses())
+
+	command, err = newNamedCommand("failing_task", "true", "", 0)
+	require.NoError(t, err)
+	_, err = command.Run(nil, nil)
+	require.NoError(t, err)
+	alerting.check(time.Now())
+	assert.Equal(t, []string{"failing_task resolved"}, notifier.statuses())
+}
+
+// blockingNotifier waits until the notification times out
+type blockingNotifier struct{}
+
+func (n blockingNotifier) Notify(ctx context.Context, _ Alert) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestSlowNotifierDoesNotBlockChecks(t *testing.T) {
+	cpu := &valueSensor{value: 65}
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	global.TemperatureSensors = map[string]SensorGetter{"cpu": cpu}
+	alerting, err := NewAlerting(global, cfg.Alerts{
+		Rules: map[string]cfg.AlertRule{
+			"hot": {Type: AlertTemperature, Above: 60},
+		},
+	})
+	require.NoError(t, err)
+	notifier := &recordingNotifier{}
+	alerting.notifiers = map[string]AlertNotifier{"slow": blockingNotifier{}, "test": notifier}
+	alerting.timeouts["slow"] = time.Hour
+	alerting.rules[0].notify = []string{"slow", "test"}
+	alerting.startNotifiers()
+	defer alerting.Stop()
+
+	start := time.Now()
+	alerting.check(start)
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Eventually(t, func() bool {
+		notifier.mutex.Lock()
+		defer notifier.mutex.Unlock()
+		return len(notifier.alerts) == 1
+	}, time.Second, time.Millisecond)
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	bodies := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies <- r.Header.Get("X-Token") + " " + string(body)
+	}))
+	defer server.Close()
+
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	global.Templates = map[string]*Template{"chat": {Name: "chat", ID: "chat.json"}}
+	global.templ = template.Must(template.New("").Funcs(templateFunctions).New("chat.json").
+		Parse(`{"text": {{ json (printf "%s: %s" .Source .Message) }}, "status": "{{ .Status }}"}`))
+
+	alert := Alert{Rule: "hot", Type: AlertTemperature, Source: "disk \"1\"", Status: AlertFiring, Message: "too hot", Value: 60}
+	notifier, err := newAlertNotifier(global, "chat", cfg.Notifier{
+		Type:     NotifierWebhook,
+		URL:      server.URL,
+		Headers:  map[string]string{"X-Token": "secret"},
+		Template: "chat",
+	})
+	require.NoError(t, err)
+	require.NoError(t, notifier.Notify(context.Background(), alert))
+	assert.Equal(t, `secret {"text": "disk \"1\": too hot", "status": "firing"}`, <-bodies)
+
+	// JSON encoding of the alert without a template
+	notifier, err = newAlertNotifier(global, "raw", cfg.Notifier{Type: NotifierWebhook, URL: server.URL})
+	require.NoError(t, err)
+	require.NoError(t, notifier.Notify(context.Background(), alert))
+	decoded := Alert{}
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(<-bodies, " ")), &decoded))
+	assert.Equal(t, alert, decoded)
+}
+
+// smtpReceiver is a minimal SMTP server keeping the messages received
+type smtpReceiver struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	auth     []string
+	rcpt     []string
+	messages []string
+}
+
+// newSMTPReceiver listens in clear text, or over TLS when tlsConfig is not nil
+func newSMTPReceiver(t *testing.T, tlsConfig *tls.Config) *smtpReceiver {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	if tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
+	}
+	receiver := &smtpReceiver{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go receiver.serve(conn)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	return receiver
+}
+
+func (r *smtpReceiver) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		_, _ = conn.Write([]byte(line + "\r\n"))
+	}
+	reply("220 localhost ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		r.mutex.Lock()
+		switch command {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			r.auth = append(r.auth, line)
+			reply("235 authenticated")
+		case "RCPT":
+			r.rcpt = append(r.rcpt, line)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			message := &strings.Builder{}
+			for {
+				dataLine, err := reader.ReadString('\n')
+				if err != nil || dataLine == ".\r\n" {
+					break
+				}
+				message.WriteString(dataLine)
+			}
+			r.messages = append(r.messages, message.String())
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			r.mutex.Unlock()
+			return
+		default:
+			reply("250 ok")
+		}
+		r.mutex.Unlock()
+	}
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	receiver := newSMTPReceiver(t, nil)
+	notifier, err := newAlertNotifier(newTestGlobal(t, map[string]string{"first": "/dev/sda"}), "mail", cfg.Notifier{
+		Type:     NotifierSMTP,
+		Address:  receiver.listener.Addr().String(),
+		Username: "alerts",
+		Password: "secret",
+		From:     "hardware-events@server.lan",
+		To:       []string{"admin@server.lan", "oncall@server.lan"},
+	})
+	require.NoError(t, err)
+	now := time.Now()
+	err = notifier.Notify(context.Background(), Alert{
+		Rule:     "smart",
+		Type:     AlertSmartDegraded,
+		Source:   "datapool1",
+		Status:   AlertResolved,
+		Message:  "SMART data is healthy",
+		Host:     "server",
+		StartsAt: now.Add(-time.Hour),
+		EndsAt:   &now,
+	})
+	require.NoError(t, err)
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	require.Len(t, receiver.messages, 1)
+	assert.Len(t, receiver.auth, 1)
+	assert.Equal(t, []string{"RCPT TO:<admin@server.lan>", "RCPT TO:<oncall@server.lan>"}, receiver.rcpt)
+	assert.Contains(t, receiver.messages[0], "Subject: [RESOLVED] smart on datapool1 (server)\r\n")
+	assert.Contains(t, receiver.messages[0], "Alert smart is resolved on datapool1: SMART data is healthy\r\n")
+}
+
+// selfSignedTLSConfig returns a server configuration with a certificate generated for localhost
+func selfSignedTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{certificate}, PrivateKey: key}},
+	}
+}
+
+func TestSMTPNotifierWithImplicitTLS(t *testing.T) {
+	receiver := newSMTPReceiver(t, selfSignedTLSConfig(t))
+	notifier, err := newAlertNotifier(newTestGlobal(t, map[string]string{"first": "/dev/sda"}), "mail", cfg.Notifier{
+		Type:        NotifierSMTP,
+		Address:     receiver.listener.Addr().String(),
+		ImplicitTLS: true,
+		Username:    "alerts",
+		Password:    "secret",
+		From:        "hardware-events@server.lan",
+		To:          []string{"admin@server.lan"},
+		TLS:         cfg.TLS{InsecureSkipVerify: true},
+	})
+	require.NoError(t, err)
+	err = notifier.Notify(context.Background(), Alert{
+		Rule:     "hot",
+		Type:     AlertTemperature,
+		Source:   "cpu",
+		Status:   AlertFiring,
+		Message:  "80°C",
+		StartsAt: time.Now(),
+	})
+	require.NoError(t, err)
+
+	receiver.mutex.Lock()
+	defer receiver.mutex.Unlock()
+	require.Len(t, receiver.messages, 1)
+	// the credentials are only sent over TLS
+	assert.Len(t, receiver.auth, 1)
+	assert.Contains(t, receiver.messages[0], "Subject: [FIRING] hot on cpu\r\n")
+}
+
+func TestSMTPSPortUsesImplicitTLS(t *testing.T) {
+	notifier, err := newAlertNotifier(newTestGlobal(t, map[string]string{"first": "/dev/sda"}), "mail", cfg.Notifier{
+		Type:    NotifierSMTP,
+		Address: "smtp.lan:465",
+		From:    "hardware-events@server.lan",
+		To:      []string{"admin@server.lan"},
+	})
+	require.NoError(t, err)
+	assert.True(t, notifier.(*smtpNotifier).implicitTLS)
+}
+
+func TestInvalidAlertsConfiguration(t *testing.T) {
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	for _, config := range []cfg.Alerts{
+		{CheckEvery: "often"},
+		{Rules: map[string]cfg.AlertRule{"hot": {Type: "hot"}}},
+		{Rules: map[string]cfg.AlertRule{"hot": {Type: AlertTemperature}}},
+		{Rules: map[string]cfg.AlertRule{"hot": {Type: AlertTemperature, Above: 50, For: "long"}}},
+		{Rules: map[string]cfg.AlertRule{"fan": {Type: AlertFanStalled, Below: 100}}},
+		{Rules: map[string]cfg.AlertRule{"hot": {Type: AlertTemperature, Above: 50, Notify: []string{"nobody"}}}},
+		{Notifiers: map[string]cfg.Notifier{"hook": {Type: NotifierWebhook}}},
+		{Notifiers: map[string]cfg.Notifier{"hook": {Type: NotifierWebhook, URL: "http://localhost", Template: "missing"}}},
+		{Notifiers: map[string]cfg.Notifier{"mail": {Type: NotifierSMTP, Address: "localhost"}}},
+		{Notifiers: map[string]cfg.Notifier{"mail": {Type: NotifierSMTP, Address: "localhost:25"}}},
+		{Notifiers: map[string]cfg.Notifier{"pager": {Type: "pager"}}},
+	} {
+		_, err := NewAlerting(global, config)
+		assert.Error(t, err, config)
+	}
+}
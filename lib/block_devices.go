@@ -0,0 +1,72 @@
+package lib
+
+import (
+	"io/fs"
+	"path"
+	"slices"
+)
+
+const sysBlockPath = "sys/block"
+
+// diskPartitions returns the kernel names of the partitions of the disk.
+// It returns fs.ErrNotExist when the disk cannot be found in sysfs.
+func diskPartitions(fileSystem fs.FS, diskname string) ([]string, error) {
+	diskPath := path.Join(sysBlockPath, diskname)
+	entries, err := fs.ReadDir(fileSystem, diskPath)
+	if err != nil {
+		return nil, err
+	}
+	partitions := make([]string, 0)
+	for _, entry := range entries {
+		// a partition is a sub-directory with a "partition" file in it
+		_, err := fs.Stat(fileSystem, path.Join(diskPath, entry.Name(), "partition"))
+		if err == nil {
+			partitions = append(partitions, entry.Name())
+		}
+	}
+	return partitions, nil
+}
+
+// blockDevices returns the kernel names of the disk, its partitions and all the devices stacked on top of them
+// (device-mapper, mdraid, etc.), to find the physical disks behind a stacked device.
+// It returns fs.ErrNotExist when the disk cannot be found in sysfs.
+func blockDevices(fileSystem fs.FS, diskname string) ([]string, error) {
+	partitions, err := diskPartitions(fileSystem, diskname)
+	if err != nil {
+		return nil, err
+	}
+	diskPath := path.Join(sysBlockPath, diskname)
+	devices := append([]string{diskname}, partitions...)
+	// devices built on top of the disk or its partitions
+	holders := make([]string, 0)
+	for i, device := range devices {
+		devicePath := diskPath
+		if i > 0 {
+			devicePath = path.Join(diskPath, device)
+		}
+		holders = appendHolders(fileSystem, holders, devicePath)
+	}
+	for _, holder := range holders {
+		if !slices.Contains(devices, holder) {
+			devices = append(devices, holder)
+		}
+	}
+	return devices, nil
+}
+
+// appendHolders adds the holders of the device, and the holders of the holders
+func appendHolders(fileSystem fs.FS, holders []string, devicePath string) []string {
+	entries, err := fs.ReadDir(fileSystem, path.Join(devicePath, "holders"))
+	if err != nil {
+		return holders
+	}
+	for _, entry := range entries {
+		if slices.Contains(holders, entry.Name()) {
+			// already seen (and prevents any loop)
+			continue
+		}
+		holders = append(holders, entry.Name())
+		holders = appendHolders(fileSystem, holders, path.Join(sysBlockPath, entry.Name()))
+	}
+	return holders
+}
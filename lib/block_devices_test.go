@@ -0,0 +1,51 @@
+package lib
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockDevices(t *testing.T) {
+	fixtures := []struct {
+		diskname string
+		devices  []string
+	}{
+		{"sda", []string{"sda", "sda1", "sda9"}},
+		{"sdb", []string{"sdb", "dm-1"}},
+		{"nvme0n1", []string{"nvme0n1", "nvme0n1p1", "nvme0n1p2", "dm-0", "md0"}},
+	}
+	for _, fixture := range fixtures {
+		t.Run(fixture.diskname, func(t *testing.T) {
+			devices, err := blockDevices(os.DirFS("fs_test_files"), fixture.diskname)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, fixture.devices, devices)
+		})
+	}
+}
+
+func TestDiskPartitions(t *testing.T) {
+	fixtures := []struct {
+		diskname   string
+		partitions []string
+	}{
+		{"sda", []string{"sda1", "sda9"}},
+		{"sdb", []string{}},
+		{"nvme0n1", []string{"nvme0n1p1", "nvme0n1p2"}},
+	}
+	for _, fixture := range fixtures {
+		t.Run(fixture.diskname, func(t *testing.T) {
+			partitions, err := diskPartitions(os.DirFS("fs_test_files"), fixture.diskname)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, fixture.partitions, partitions)
+		})
+	}
+}
+
+func TestBlockDevicesNotInSysfs(t *testing.T) {
+	_, err := blockDevices(os.DirFS("fs_test_files"), "sdz")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -3,20 +3,50 @@ package lib
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
+	"maps"
 	"os"
 	"os/exec"
+	"path"
 	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"time"
-
-	"github.com/creativeprojects/clog"
 )
 
 type CommandRunner interface {
 	Run(stdin io.Reader, expand func(string) string) (string, error)
 }
 
+// CommandObserver is notified after each command run, with the exit status (-1 when the command couldn't start)
+type CommandObserver func(name string, exitStatus int, duration time.Duration)
+
+var (
+	commandObservers     = make(map[int]CommandObserver)
+	nextCommandObserver  int
+	commandObserverMutex sync.RWMutex
+)
+
+// AddCommandObserver registers a function notified after each command run, until the returned function is called
+func AddCommandObserver(observer CommandObserver) func() {
+	commandObserverMutex.Lock()
+	defer commandObserverMutex.Unlock()
+
+	id := nextCommandObserver
+	nextCommandObserver++
+	commandObservers[id] = observer
+	return func() {
+		commandObserverMutex.Lock()
+		defer commandObserverMutex.Unlock()
+
+		delete(commandObservers, id)
+	}
+}
+
 type Command struct {
+	Name         string // name of the task or the sensor running the command
 	CommandLine  string
 	OutputRegexp *regexp.Regexp
 	timeout      time.Duration
@@ -38,12 +68,25 @@ func NewCommand(commandLine, outputRegexp string, timeout time.Duration) (*Comma
 	}, nil
 }
 
+// newNamedCommand creates a command identified by the name of its task or sensor in the metrics
+func newNamedCommand(name, commandLine, outputRegexp string, timeout time.Duration) (*Command, error) {
+	command, err := NewCommand(commandLine, outputRegexp, timeout)
+	if err != nil {
+		return nil, err
+	}
+	command.Name = name
+	return command, nil
+}
+
 func (c *Command) Run(stdin io.Reader, expand func(string) string) (string, error) {
 	command := os.Expand(c.CommandLine, expand)
-	clog.Debugf("command: %s", command)
+	log := c.logger()
+	log.Debugf("command: %s", command)
+	start := time.Now()
 	output, err := c.runCommand(command, stdin)
+	c.observe(err, time.Since(start))
 	if err != nil {
-		clog.Errorf("error running command `%s`: %v", command, err)
+		log.Errorf("error running command `%s`: %v", command, err)
 	}
 	if c.OutputRegexp != nil {
 		found := c.OutputRegexp.FindStringSubmatch(output)
@@ -51,10 +94,18 @@ func (c *Command) Run(stdin io.Reader, expand func(string) string) (string, erro
 			output = found[1]
 		}
 	}
-	clog.Trace(output)
+	log.Tracef("%s", output)
 	return output, err
 }
 
+// logger returns a logger with the name of the command, so its output can be filtered separately
+func (c *Command) logger() Logger {
+	if c.Name == "" {
+		return Logger{}
+	}
+	return NewLogger("command", c.Name)
+}
+
 // runCommand cancels the context as quick as possible
 func (c *Command) runCommand(command string, stdin io.Reader) (string, error) {
 	buffer := &bytes.Buffer{}
@@ -71,3 +122,29 @@ func (c *Command) runCommand(command string, stdin io.Reader) (string, error) {
 	err := cmd.Run()
 	return buffer.String(), err
 }
+
+// observe sends the result of the command to the observers, if any
+func (c *Command) observe(err error, duration time.Duration) {
+	commandObserverMutex.RLock()
+	observers := slices.Collect(maps.Values(commandObservers))
+	commandObserverMutex.RUnlock()
+	if len(observers) == 0 {
+		return
+	}
+	name := c.Name
+	if fields := strings.Fields(c.CommandLine); name == "" && len(fields) > 0 {
+		// use the name of the program
+		name = path.Base(fields[0])
+	}
+	exitStatus := 0
+	if err != nil {
+		exitStatus = -1
+		exitErr := &exec.ExitError{}
+		if errors.As(err, &exitErr) {
+			exitStatus = exitErr.ExitCode()
+		}
+	}
+	for _, observer := range observers {
+		observer(name, exitStatus, duration)
+	}
+}
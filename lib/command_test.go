@@ -2,6 +2,7 @@ package lib
 
 import (
 	"bytes"
+	"sync"
 	"testing"
 	"time"
 
@@ -87,3 +88,35 @@ Under/Over Temperature Limit Count:   0/0
 	require.NoError(t, err)
 	assert.Equal(t, "27", output)
 }
+
+func TestCommandObserver(t *testing.T) {
+	type run struct {
+		name       string
+		exitStatus int
+	}
+	runs := make([]run, 0, 2)
+	mutex := sync.Mutex{}
+	remove := AddCommandObserver(func(name string, exitStatus int, duration time.Duration) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		// other tests might have commands running in the background
+		if name == "task" || name == "echo" {
+			runs = append(runs, run{name, exitStatus})
+		}
+	})
+	defer remove()
+
+	command, err := newNamedCommand("task", "exit 3", "", 0)
+	require.NoError(t, err)
+	_, err = command.Run(nil, nil)
+	require.Error(t, err)
+
+	command, err = NewCommand("/bin/echo hello", "", 0)
+	require.NoError(t, err)
+	_, err = command.Run(nil, nil)
+	require.NoError(t, err)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, []run{{"task", 3}, {"echo", 0}}, runs)
+}
@@ -1,8 +1,10 @@
 package lib
 
 import (
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/creativeprojects/clog"
@@ -18,6 +20,9 @@ type Control struct {
 	SetCommand  CommandRunner
 	ExitCommand CommandRunner
 	Zones       map[string]*Zone
+	initialized atomic.Bool
+	started     atomic.Bool
+	health      *Health
 }
 
 // NewControl creates a new fan controller from configuration
@@ -37,7 +42,7 @@ func NewControl(sensorReader SensorReader, config cfg.FanControl, simulate bool)
 		if simulate {
 			initCmd, err = simulation.NewCommand(config.InitCommand, "")
 		} else {
-			initCmd, err = NewCommand(config.InitCommand, "", timeout)
+			initCmd, err = newNamedCommand("fan_control_init", config.InitCommand, "", timeout)
 		}
 		if err != nil {
 			return nil, err
@@ -48,7 +53,7 @@ func NewControl(sensorReader SensorReader, config cfg.FanControl, simulate bool)
 	if simulate {
 		setCmd, err = simulation.NewCommand(config.SetCommand, "")
 	} else {
-		setCmd, err = NewCommand(config.SetCommand, "", timeout)
+		setCmd, err = newNamedCommand("fan_control_set", config.SetCommand, "", timeout)
 	}
 	if err != nil {
 		return nil, err
@@ -59,7 +64,7 @@ func NewControl(sensorReader SensorReader, config cfg.FanControl, simulate bool)
 		if simulate {
 			exitCmd, err = simulation.NewCommand(config.ExitCommand, "")
 		} else {
-			exitCmd, err = NewCommand(config.ExitCommand, "", timeout)
+			exitCmd, err = newNamedCommand("fan_control_exit", config.ExitCommand, "", timeout)
 		}
 		if err != nil {
 			return nil, err
@@ -88,12 +93,14 @@ func NewControl(sensorReader SensorReader, config cfg.FanControl, simulate bool)
 // Init runs the fan initialization
 func (c *Control) Init() error {
 	if c.InitCommand == nil {
+		c.initialized.Store(true)
 		return nil
 	}
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
 	_, err := c.InitCommand.Run(nil, nil)
+	c.initialized.Store(err == nil)
 	return err
 }
 
@@ -113,8 +120,23 @@ func (c *Control) Exit() error {
 func (c *Control) Start() {
 	for name, zone := range c.Zones {
 		clog.Debugf("starting zone: %s", name)
+		for sensorName, sensor := range zone.Sensors {
+			sensor.heartbeat = c.health.Register("sensor "+name+"/"+sensorName, true)
+		}
 		zone.Start()
 	}
+	c.started.Store(true)
+}
+
+// Ready returns an error when the fan control wasn't initialised and started, or when a critical loop is not alive
+func (c *Control) Ready(now time.Time) error {
+	if !c.initialized.Load() {
+		return errors.New("fan control not initialised")
+	}
+	if !c.started.Load() {
+		return errors.New("fan control not started")
+	}
+	return c.health.Check(now)
 }
 
 func (c *Control) setSpeedCommand(zoneID, speed int) error {
@@ -0,0 +1,243 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/creativeprojects/hardware-events/lib/simulation"
+)
+
+const (
+	defaultCriticalCheckEvery = 10 * time.Second
+	defaultCriticalCooldown   = 1 * time.Hour
+	defaultCriticalRelease    = 5 // °C below the critical temperature
+)
+
+// CriticalPolicy takes emergency actions when a sensor or a disk stays above its critical temperature:
+// all the fan zones are set to their maximum speed, then the command runs and the disks are put in standby mode
+type CriticalPolicy struct {
+	global      *Global
+	Source      string // sensor or disk
+	temperature int
+	release     int // the fans are released at or below this temperature
+	duration    time.Duration
+	checkEvery  time.Duration
+	cooldown    time.Duration
+	command     CommandRunner
+	commandLine string
+	standby     []string
+	dryRun      bool
+	mutex       sync.Mutex
+	since       time.Time   // when the temperature went above the critical threshold
+	triggered   atomic.Bool // the fans are forced to their maximum speed
+	lastActions time.Time   // last time the command ran and the disks were put in standby mode
+	readError   bool        // the last reading of the source failed
+}
+
+func NewCriticalPolicy(global *Global, source string, config cfg.Critical, simulate bool) (*CriticalPolicy, error) {
+	var err error
+	if config.Temperature <= 0 {
+		return nil, errors.New("missing critical temperature")
+	}
+	policy := &CriticalPolicy{
+		global:      global,
+		Source:      source,
+		temperature: config.Temperature,
+		release:     config.Release,
+		commandLine: config.Command,
+		standby:     config.Standby,
+		dryRun:      config.DryRun,
+	}
+	if policy.release == 0 {
+		policy.release = config.Temperature - defaultCriticalRelease
+	}
+	if policy.release >= policy.temperature {
+		return nil, fmt.Errorf("release temperature %d°C must be below the critical temperature", policy.release)
+	}
+	policy.duration, err = parseDurationOrDefault(config.For, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid duration: %w", err)
+	}
+	policy.checkEvery, err = parseDurationOrDefault(config.CheckEvery, defaultCriticalCheckEvery)
+	if err != nil {
+		return nil, fmt.Errorf("invalid check_every: %w", err)
+	}
+	policy.cooldown, err = parseDurationOrDefault(config.Cooldown, defaultCriticalCooldown)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cooldown: %w", err)
+	}
+	timeout, err := parseDurationOrDefault(config.Timeout, 0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout: %w", err)
+	}
+	if config.Command != "" {
+		if simulate {
+			policy.command, err = simulation.NewCommand(config.Command, "")
+		} else {
+			policy.command, err = newNamedCommand("critical_"+source, config.Command, "", timeout)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return policy, nil
+}
+
+// Start checks the temperature in the background
+func (p *CriticalPolicy) Start() {
+	if _, known := p.global.TemperatureSensors[p.Source]; !known {
+		if _, known = p.global.Disks()[p.Source]; !known {
+			clog.Warningf("critical policy: no sensor or disk named %s (yet)", p.Source)
+		}
+	}
+	heartbeat := p.global.Health.Register("critical "+p.Source, true)
+	go func() {
+		clog.Debugf("critical policy for %s: %d°C for %s", p.Source, p.temperature, p.duration)
+		for {
+			heartbeat.Beat(3*p.checkEvery + time.Minute)
+			p.check(time.Now())
+			if !p.global.sleep(p.checkEvery) {
+				heartbeat.Done()
+				return
+			}
+		}
+	}()
+}
+
+// Triggered returns true while the fans are forced to their maximum speed
+func (p *CriticalPolicy) Triggered() bool {
+	return p.triggered.Load()
+}
+
+func (p *CriticalPolicy) check(now time.Time) {
+	temperature, known, err := p.global.readTemperature(p.Source, p.checkEvery)
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if err != nil {
+		p.unreadable(err)
+		return
+	}
+	if p.readError {
+		clog.Infof("critical policy: %s can be read again", p.Source)
+		p.readError = false
+	}
+	if !known {
+		// no sensor or disk with this name, or the temperature of the disk is not monitored in its current state
+		clog.Debugf("critical policy: no temperature available for %s", p.Source)
+		return
+	}
+
+	if temperature < p.temperature {
+		if !p.since.IsZero() {
+			clog.Infof("critical policy: %s is back to %d°C", p.Source, temperature)
+		}
+		p.since = time.Time{}
+		if temperature <= p.release && p.triggered.Swap(false) {
+			p.releaseFans()
+		}
+		return
+	}
+	if p.since.IsZero() {
+		clog.Warningf("critical policy: %s is %d°C, at or above its critical temperature of %d°C", p.Source, temperature, p.temperature)
+		p.since = now
+	}
+	if p.triggered.Load() || now.Sub(p.since) < p.duration {
+		return
+	}
+	p.triggered.Store(true)
+	p.trigger(now, temperature)
+}
+
+// unreadable forces the fans to their maximum speed while the temperature of the source cannot be read.
+// The command and the standby only run on a temperature actually read above the threshold.
+func (p *CriticalPolicy) unreadable(err error) {
+	if !p.readError {
+		clog.Errorf("critical policy: cannot read the temperature of %s, the fans are kept at their maximum speed: %s", p.Source, err)
+		p.readError = true
+	}
+	if !p.triggered.Swap(true) {
+		p.forceFans()
+	}
+}
+
+// trigger runs the emergency actions, or only logs them in dry-run mode
+func (p *CriticalPolicy) trigger(now time.Time, temperature int) {
+	message := fmt.Sprintf("%d°C since %s (critical temperature is %d°C)", temperature, p.since.Format(time.TimeOnly), p.temperature)
+	event := NewEvent(EventCriticalTemperature, p.Source, message)
+	prefix := ""
+	if p.dryRun {
+		prefix = "dry run: "
+	} else {
+		p.global.RaiseEvent(event)
+	}
+	clog.Errorf("%scritical policy: %s: %s", prefix, p.Source, message)
+
+	p.forceFans()
+
+	if !p.lastActions.IsZero() && now.Sub(p.lastActions) < p.cooldown {
+		clog.Warningf("%scritical policy: %s: actions already taken at %s, waiting for the cooldown", prefix, p.Source, p.lastActions.Format(time.TimeOnly))
+		return
+	}
+	p.lastActions = now
+	if p.command != nil {
+		clog.Warningf("%scritical policy: running command %q", prefix, p.commandLine)
+		if !p.dryRun {
+			_, err := p.command.Run(nil, event.expandEnv)
+			if err != nil {
+				clog.Errorf("critical policy: %s: %s", p.Source, err)
+			}
+		}
+	}
+	disks := p.global.Disks()
+	for _, name := range p.standby {
+		disk, ok := disks[name]
+		if !ok {
+			clog.Warningf("critical policy: disk %s not found", name)
+			continue
+		}
+		if !disk.IsActive() {
+			continue
+		}
+		clog.Warningf("%scritical policy: putting disk %s in standby mode", prefix, name)
+		if !p.dryRun {
+			disk.standby()
+		}
+	}
+}
+
+// forceFans sets all the fan zones to their maximum speed, or only logs it in dry-run mode
+func (p *CriticalPolicy) forceFans() {
+	if p.global.FanControl == nil {
+		return
+	}
+	for name, zone := range p.global.FanControl.Zones {
+		if p.dryRun {
+			_, maxSpeed := zone.SpeedRange()
+			clog.Warningf("dry run: critical policy: setting fan zone %s to %d%%", name, maxSpeed)
+			continue
+		}
+		zone.SetForcedMax(true)
+	}
+}
+
+// releaseFans gives the control of the fans back to the sensors, unless another policy is still triggered
+func (p *CriticalPolicy) releaseFans() {
+	if p.dryRun || p.global.FanControl == nil {
+		return
+	}
+	for _, policy := range p.global.CriticalPolicies {
+		if policy != p && !policy.dryRun && policy.Triggered() {
+			return
+		}
+	}
+	clog.Warningf("critical policy: %s: back to automatic fan control", p.Source)
+	for _, zone := range p.global.FanControl.Zones {
+		zone.SetForcedMax(false)
+	}
+}
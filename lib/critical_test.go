@@ -0,0 +1,179 @@
+package lib
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCriticalTestGlobal(t *testing.T, cpu *valueSensor) *Global {
+	t.Helper()
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	global.TemperatureSensors = map[string]SensorGetter{"cpu": cpu}
+	var err error
+	global.FanControl, err = NewControl(global.GetSensorReader, cfg.FanControl{
+		SetCommand: "true",
+		Zones: map[string]cfg.FanZone{
+			"zone1": {ID: 1, MinSpeed: 20, MaxSpeed: 90, RunEvery: "10s"},
+			"zone2": {ID: 2, MinSpeed: 20, MaxSpeed: 100, RunEvery: "10s"},
+		},
+	}, false)
+	require.NoError(t, err)
+	return global
+}
+
+func TestCriticalPolicy(t *testing.T) {
+	cpu := &valueSensor{value: 70}
+	global := newCriticalTestGlobal(t, cpu)
+	output := filepath.Join(t.TempDir(), "critical")
+	policy, err := NewCriticalPolicy(global, "cpu", cfg.Critical{
+		Temperature: 80,
+		For:         "1m",
+		Command:     "echo ${EVENT_SOURCE} >> " + output,
+		Standby:     []string{"first"},
+		Cooldown:    "1h",
+	}, false)
+	require.NoError(t, err)
+	global.CriticalPolicies = map[string]*CriticalPolicy{"cpu": policy}
+	zones := global.FanControl.Zones
+	zones["zone1"].SetOverride(50)
+
+	now := time.Now()
+	policy.check(now)
+	assert.False(t, policy.Triggered())
+
+	cpu.set(85, nil)
+	policy.check(now)
+	policy.check(now.Add(30 * time.Second))
+	assert.False(t, policy.Triggered())
+	assert.Equal(t, 50, zones["zone1"].CurrentFanSpeed())
+
+	policy.check(now.Add(time.Minute))
+	assert.True(t, policy.Triggered())
+	assert.True(t, zones["zone1"].ForcedMax())
+	assert.Equal(t, 90, zones["zone1"].CurrentFanSpeed())
+	assert.Equal(t, 100, zones["zone2"].CurrentFanSpeed())
+	// the manual speed cannot take over during the emergency
+	zones["zone2"].SetOverride(30)
+	assert.Equal(t, 100, zones["zone2"].CurrentFanSpeed())
+	zones["zone2"].SetOverride(0)
+	assert.Equal(t, 100, zones["zone2"].CurrentFanSpeed())
+	assert.False(t, global.Disks()["first"].IsActive())
+	content, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, "cpu\n", string(content))
+
+	// below the critical temperature, but not enough to release the fans
+	cpu.set(78, nil)
+	policy.check(now.Add(90 * time.Second))
+	assert.True(t, policy.Triggered())
+	assert.True(t, zones["zone1"].ForcedMax())
+
+	// back to normal: the manual speed is still there
+	cpu.set(75, nil)
+	policy.check(now.Add(2 * time.Minute))
+	assert.False(t, policy.Triggered())
+	assert.False(t, zones["zone1"].ForcedMax())
+	assert.False(t, zones["zone2"].ForcedMax())
+	assert.Equal(t, 50, zones["zone1"].Override())
+	assert.Equal(t, 50, zones["zone1"].CurrentFanSpeed())
+
+	// triggered again during the cooldown: only the fans are forced
+	cpu.set(80, nil)
+	policy.check(now.Add(3 * time.Minute))
+	policy.check(now.Add(4 * time.Minute))
+	assert.True(t, policy.Triggered())
+	assert.Equal(t, 90, zones["zone1"].CurrentFanSpeed())
+	content, err = os.ReadFile(output)
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(content), "cpu"))
+}
+
+func TestCriticalPolicyUnreadableSource(t *testing.T) {
+	cpu := &valueSensor{value: 70}
+	global := newCriticalTestGlobal(t, cpu)
+	output := filepath.Join(t.TempDir(), "critical")
+	policy, err := NewCriticalPolicy(global, "cpu", cfg.Critical{
+		Temperature: 80,
+		Release:     60,
+		Command:     "touch " + output,
+	}, false)
+	require.NoError(t, err)
+	global.CriticalPolicies = map[string]*CriticalPolicy{"cpu": policy}
+
+	// the fans are forced to their maximum speed, but no action is taken
+	cpu.set(0, errors.New("sensor unavailable"))
+	policy.check(time.Now())
+	assert.True(t, policy.Triggered())
+	assert.True(t, global.FanControl.Zones["zone1"].ForcedMax())
+	assert.NoFileExists(t, output)
+
+	cpu.set(70, nil)
+	policy.check(time.Now())
+	assert.True(t, policy.Triggered())
+
+	cpu.set(60, nil)
+	policy.check(time.Now())
+	assert.False(t, policy.Triggered())
+	assert.False(t, global.FanControl.Zones["zone1"].ForcedMax())
+}
+
+func TestCriticalPolicyKeepsFansForOtherPolicy(t *testing.T) {
+	cpu := &valueSensor{value: 85}
+	global := newCriticalTestGlobal(t, cpu)
+	global.TemperatureSensors["pch"] = &valueSensor{value: 90}
+	global.CriticalPolicies = make(map[string]*CriticalPolicy)
+	for _, source := range []string{"cpu", "pch"} {
+		policy, err := NewCriticalPolicy(global, source, cfg.Critical{Temperature: 80}, false)
+		require.NoError(t, err)
+		global.CriticalPolicies[source] = policy
+	}
+
+	now := time.Now()
+	global.CriticalPolicies["cpu"].check(now)
+	global.CriticalPolicies["pch"].check(now)
+	cpu.set(60, nil)
+	global.CriticalPolicies["cpu"].check(now.Add(time.Minute))
+	assert.False(t, global.CriticalPolicies["cpu"].Triggered())
+	assert.True(t, global.FanControl.Zones["zone1"].ForcedMax())
+}
+
+func TestCriticalPolicyDryRun(t *testing.T) {
+	global := newCriticalTestGlobal(t, &valueSensor{value: 85})
+	output := filepath.Join(t.TempDir(), "critical")
+	policy, err := NewCriticalPolicy(global, "cpu", cfg.Critical{
+		Temperature: 80,
+		Command:     "touch " + output,
+		Standby:     []string{"first"},
+		DryRun:      true,
+	}, false)
+	require.NoError(t, err)
+
+	policy.check(time.Now())
+	assert.True(t, policy.Triggered())
+	assert.False(t, global.FanControl.Zones["zone1"].ForcedMax())
+	assert.True(t, global.Disks()["first"].IsActive())
+	assert.NoFileExists(t, output)
+}
+
+func TestInvalidCriticalPolicy(t *testing.T) {
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	for _, config := range []cfg.Critical{
+		{},
+		{Temperature: 80, Release: 80},
+		{Temperature: 80, For: "a while"},
+		{Temperature: 80, CheckEvery: "often"},
+		{Temperature: 80, Cooldown: "forever"},
+		{Temperature: 80, Timeout: "never"},
+	} {
+		_, err := NewCriticalPolicy(global, "cpu", config, false)
+		assert.Error(t, err)
+	}
+}
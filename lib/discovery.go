@@ -0,0 +1,194 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	hwmonClassPath   = "sys/class/hwmon"
+	thermalClassPath = "sys/class/thermal"
+	thermalPrefix    = "thermal_zone"
+)
+
+// DiscoveredSensor is a temperature sensor found in sysfs
+type DiscoveredSensor struct {
+	Name  string // stable name like "coretemp/Package id 0"
+	File  string // path of the input file, relative to the root of the filesystem
+	Value int    // raw value read during the discovery (usually in millidegree Celsius)
+}
+
+// DiscoverSensors enumerates all the temperature sensors from /sys/class/hwmon and /sys/class/thermal.
+// The file system is expected to be rooted at "/"
+func DiscoverSensors(fileSystem fs.FS) ([]DiscoveredSensor, error) {
+	sensors, err := discoverHwmon(fileSystem)
+	if err != nil {
+		return nil, err
+	}
+	thermal, err := discoverThermalZones(fileSystem)
+	if err != nil {
+		return nil, err
+	}
+	return append(sensors, thermal...), nil
+}
+
+// FindDiscoveredSensor returns the sensor file matching the stable name
+func FindDiscoveredSensor(fileSystem fs.FS, name string) (string, error) {
+	sensors, err := DiscoverSensors(fileSystem)
+	if err != nil {
+		return "", err
+	}
+	for _, sensor := range sensors {
+		if sensor.Name == name {
+			return sensor.File, nil
+		}
+	}
+	return "", fmt.Errorf("sensor %q not found in sysfs", name)
+}
+
+func discoverHwmon(fileSystem fs.FS) ([]DiscoveredSensor, error) {
+	devices, err := listNumbered(fileSystem, hwmonClassPath, "hwmon")
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(devices))
+	count := make(map[string]int, len(devices))
+	for i, device := range devices {
+		names[i] = readTrimmed(fileSystem, path.Join(device, "name"))
+		if names[i] == "" {
+			names[i] = path.Base(device)
+		}
+		count[names[i]]++
+	}
+
+	sensors := make([]DiscoveredSensor, 0, len(devices))
+	for i, device := range devices {
+		name := names[i]
+		if count[name] > 1 {
+			// more than one device with the same driver: try to make it unique with the device it's attached to
+			name = uniqueName(fileSystem, device, name, i)
+		}
+		inputs, err := fs.Glob(fileSystem, path.Join(device, "temp*_input"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(inputs, func(i, j int) bool {
+			return inputIndex(inputs[i]) < inputIndex(inputs[j])
+		})
+		for _, input := range inputs {
+			label := readTrimmed(fileSystem, strings.TrimSuffix(input, "_input")+"_label")
+			if label == "" {
+				label = strings.TrimSuffix(path.Base(input), "_input")
+			}
+			value, err := readInt(fileSystem, input)
+			if err != nil {
+				// sensor is not readable: ignore it
+				continue
+			}
+			sensors = append(sensors, DiscoveredSensor{
+				Name:  name + "/" + label,
+				File:  input,
+				Value: value,
+			})
+		}
+	}
+	return sensors, nil
+}
+
+func discoverThermalZones(fileSystem fs.FS) ([]DiscoveredSensor, error) {
+	zones, err := listNumbered(fileSystem, thermalClassPath, thermalPrefix)
+	if err != nil {
+		return nil, err
+	}
+	count := make(map[string]int, len(zones))
+	sensors := make([]DiscoveredSensor, 0, len(zones))
+	for _, zone := range zones {
+		zoneType := readTrimmed(fileSystem, path.Join(zone, "type"))
+		if zoneType == "" {
+			zoneType = path.Base(zone)
+		}
+		input := path.Join(zone, "temp")
+		value, err := readInt(fileSystem, input)
+		if err != nil {
+			continue
+		}
+		name := thermalPrefix + "/" + zoneType
+		if count[zoneType] > 0 {
+			name += "#" + strconv.Itoa(count[zoneType])
+		}
+		count[zoneType]++
+		sensors = append(sensors, DiscoveredSensor{
+			Name:  name,
+			File:  input,
+			Value: value,
+		})
+	}
+	return sensors, nil
+}
+
+// listNumbered returns the directories starting with prefix sorted by their number (hwmon2 before hwmon10).
+// It returns no error if the parent directory doesn't exist.
+func listNumbered(fileSystem fs.FS, dir, prefix string) ([]string, error) {
+	entries, err := fs.ReadDir(fileSystem, dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return numberAfter(names[i], prefix) < numberAfter(names[j], prefix)
+	})
+	for i, name := range names {
+		names[i] = path.Join(dir, name)
+	}
+	return names, nil
+}
+
+// uniqueName adds the name of the device the hwmon is attached to, or the index when not available
+func uniqueName(fileSystem fs.FS, device, name string, index int) string {
+	link, err := fs.ReadLink(fileSystem, path.Join(device, "device"))
+	if err == nil && link != "" {
+		return name + "@" + path.Base(link)
+	}
+	return name + "#" + strconv.Itoa(index)
+}
+
+func inputIndex(filename string) int {
+	return numberAfter(strings.TrimSuffix(path.Base(filename), "_input"), "temp")
+}
+
+func numberAfter(name, prefix string) int {
+	value, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+	if err != nil {
+		return -1
+	}
+	return value
+}
+
+func readTrimmed(fileSystem fs.FS, filename string) string {
+	content, err := fs.ReadFile(fileSystem, filename)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+func readInt(fileSystem fs.FS, filename string) (int, error) {
+	content, err := fs.ReadFile(fileSystem, filename)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(content)))
+}
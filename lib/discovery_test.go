@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiscoverSensors(t *testing.T) {
+	sensors, err := DiscoverSensors(os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+
+	expected := []DiscoveredSensor{
+		{Name: "coretemp/Package id 0", File: "sys/class/hwmon/hwmon0/temp1_input", Value: 45000},
+		{Name: "coretemp/Core 0", File: "sys/class/hwmon/hwmon0/temp2_input", Value: 43000},
+		{Name: "coretemp/Core 1", File: "sys/class/hwmon/hwmon0/temp3_input", Value: 44000},
+		{Name: "nvme/Composite", File: "sys/class/hwmon/hwmon1/temp1_input", Value: 38850},
+		{Name: "drivetemp@0:0:0:0/temp1", File: "sys/class/hwmon/hwmon2/temp1_input", Value: 31000},
+		{Name: "drivetemp@1:0:0:0/temp1", File: "sys/class/hwmon/hwmon3/temp1_input", Value: 33000},
+		{Name: "thermal_zone/acpitz", File: "sys/class/thermal/thermal_zone0/temp", Value: 27800},
+		{Name: "thermal_zone/x86_pkg_temp", File: "sys/class/thermal/thermal_zone1/temp", Value: 46000},
+	}
+	assert.Equal(t, expected, sensors)
+}
+
+func TestDiscoverNoSysfs(t *testing.T) {
+	sensors, err := DiscoverSensors(os.DirFS("test_files"))
+	require.NoError(t, err)
+	assert.Empty(t, sensors)
+}
+
+func TestDiscoveredSensor(t *testing.T) {
+	sensor, err := NewSensor("name", cfg.Task{Sensor: "coretemp/Package id 0", Divider: 1000}, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	temperature, err := sensor.Get(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 45, temperature)
+}
+
+func TestDiscoveredSensorNotFound(t *testing.T) {
+	sensor, err := NewSensor("name", cfg.Task{Sensor: "coretemp/Package id 1"}, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	_, err = sensor.Get(nil)
+	assert.ErrorContains(t, err, "not found")
+}
@@ -1,13 +1,15 @@
 package lib
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"math/rand/v2"
-	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/creativeprojects/clog"
 	"github.com/creativeprojects/hardware-events/cache"
 	"github.com/creativeprojects/hardware-events/cfg"
 	"github.com/creativeprojects/hardware-events/lib/enum"
@@ -15,28 +17,54 @@ import (
 
 // Disk activity and status
 type Disk struct {
-	global        *Global
-	config        cfg.Disk
-	Name          string
-	Device        string
-	Pool          string
-	active        *cache.CacheValue[int]
-	temperature   *cache.CacheValue[int]
-	lastActivity  time.Time
-	activityMutex sync.Mutex
-	stats         *Diskstats
-	idleAfter     time.Duration
-	standbyAfter  time.Duration
-	checkEvery    time.Duration
-	diskStatus    DiskStatuser
+	global           *Global
+	config           cfg.Disk
+	Name             string
+	device           string
+	present          bool
+	deviceMutex      sync.RWMutex
+	pool             string // name of the disk pool, if any
+	poolStandby      bool   // standby is managed by the pool
+	poolMutex        sync.RWMutex
+	active           *cache.CacheValue[int]
+	temperature      *cache.CacheValue[int]
+	smart            *cache.CacheValue[SmartData]
+	previousSmart    *SmartData
+	healthMutex      sync.Mutex
+	smartHistory     []SmartSample
+	health           DiskHealth
+	smartEvery       time.Duration
+	maxTemperature   int
+	spinMutex        sync.Mutex
+	spinCounters     SpinCounters
+	standbyActions   map[string]int64
+	standbyBlocked   string // StandbyInhibited or StandbyBudgetSpent, counted once
+	lastStatus       enum.DiskStatus
+	maxSpinUpsPerDay int
+	lastActivity     time.Time
+	idle             bool
+	activityMutex    sync.Mutex
+	stats            *Diskstats
+	idleAfter        time.Duration
+	standbyAfter     time.Duration
+	checkEvery       time.Duration
+	diskStatus       DiskStatuser
+	inhibitor        *StandbyInhibitor
+	log              Logger
 }
 
 // NewDisk creates a new disk activity and status monitor
 func NewDisk(global *Global, name string, config cfg.Disk, diskStatuses map[string]DiskStatuser) (*Disk, error) {
-	device := config.Device
-	fi, err := os.Lstat(device)
+	log := NewLogger("disk", name)
+	// resolve symlink into kernel device name
+	device, err := resolveSymlink(global.fs, config.Device)
 	if err != nil {
-		return nil, err
+		if !global.config.Hotplug || !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		// the disk might be plugged in later
+		log.Warningf("not present: %s", err)
+		device = ""
 	}
 
 	var diskStatus DiskStatuser
@@ -51,20 +79,7 @@ func NewDisk(global *Global, name string, config cfg.Disk, diskStatuses map[stri
 		}
 	}
 	if diskStatus == nil {
-		clog.Warningf("disk %q has no power status available", name)
-	}
-
-	if fi.Mode()&os.ModeSymlink != 0 {
-		// resolve symlink into kernel device name
-		dest, err := os.Readlink(device)
-		if err != nil {
-			return nil, err
-		}
-		if filepath.IsAbs(dest) {
-			device = dest
-		} else {
-			device = filepath.Join(filepath.Dir(device), dest)
-		}
+		log.Warningf("no power status available")
 	}
 
 	idleAfter := 1 * time.Minute
@@ -91,30 +106,105 @@ func NewDisk(global *Global, name string, config cfg.Disk, diskStatuses map[stri
 		}
 	}
 
-	clog.Debugf("device %s: %s", name, device)
-	return &Disk{
-		global:        global,
-		config:        config,
-		Name:          name,
-		Device:        device,
-		active:        cache.NewCacheValue[int](1 * time.Minute),
-		temperature:   cache.NewCacheValue[int](1 * time.Minute),
-		idleAfter:     idleAfter,
-		standbyAfter:  standbyAfter,
-		diskStatus:    diskStatus,
-		checkEvery:    checkEvery,
-		activityMutex: sync.Mutex{},
-	}, nil
+	smartEvery := 1 * time.Hour
+	if config.SmartEvery != "" {
+		smartEvery, err = time.ParseDuration(config.SmartEvery)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	inhibitor, err := NewStandbyInhibitor(global.fs, config.Inhibit)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("device %s", device)
+	disk := &Disk{
+		global:           global,
+		config:           config,
+		Name:             name,
+		device:           device,
+		present:          device != "",
+		active:           cache.NewCacheValue[int](1 * time.Minute),
+		temperature:      cache.NewCacheValue[int](1 * time.Minute),
+		smart:            cache.NewCacheValue[SmartData](1 * time.Minute),
+		idleAfter:        idleAfter,
+		standbyAfter:     standbyAfter,
+		diskStatus:       diskStatus,
+		checkEvery:       checkEvery,
+		lastActivity:     time.Now(),
+		activityMutex:    sync.Mutex{},
+		smartEvery:       smartEvery,
+		maxTemperature:   config.MaxTemperature,
+		maxSpinUpsPerDay: config.MaxSpinUpsPerDay,
+		inhibitor:        inhibitor,
+		log:              log,
+	}
+	if global.state != nil {
+		disk.restoreSmartHistory(global.state.SmartHistory[name])
+		disk.restoreSpinCounters(global.state.SpinCounters[name])
+		disk.inhibitor.restore(global.state.Inhibits[inhibitKey(InhibitTargetDisk, name)])
+		if global.state.recent(global.stateMaxAge) {
+			disk.restoreLastActivity(global.state.LastActivity[name])
+		}
+	}
+	return disk, nil
+}
+
+// Device returns the kernel device of the disk, like /dev/sda. It's empty when the disk is not present.
+func (d *Disk) Device() string {
+	d.deviceMutex.RLock()
+	defer d.deviceMutex.RUnlock()
+
+	return d.device
+}
+
+// Present returns false when the device of the disk has disappeared
+func (d *Disk) Present() bool {
+	d.deviceMutex.RLock()
+	defer d.deviceMutex.RUnlock()
+
+	return d.present
+}
+
+// Pool returns the name of the disk pool the disk belongs to, if any
+func (d *Disk) Pool() string {
+	d.poolMutex.RLock()
+	defer d.poolMutex.RUnlock()
+
+	return d.pool
+}
+
+// managedByPool returns true when the standby of the disk is managed by its pool
+func (d *Disk) managedByPool() bool {
+	d.poolMutex.RLock()
+	defer d.poolMutex.RUnlock()
+
+	return d.poolStandby
+}
+
+func (d *Disk) setPool(name string, standby bool) {
+	d.poolMutex.Lock()
+	defer d.poolMutex.Unlock()
+
+	d.pool = name
+	d.poolStandby = standby
 }
 
 // IsActive returns true when the disk is not in standby or sleep mode
 func (d *Disk) IsActive() bool {
+	if !d.Present() {
+		return false
+	}
 	if d.diskStatus == nil {
 		// shouldn't happen?
 		return false
 	}
 	output, err := d.active.Get(func() (int, error) {
-		return int(d.diskStatus.Get(d.expandEnv)), nil
+		status := d.diskStatus.Get(d.expandEnv)
+		d.observeStatus(status, false)
+		return int(status), nil
 	})
 	if err != nil {
 		return false
@@ -130,6 +220,9 @@ func (d *Disk) HasTemperature() bool {
 // TemperatureAvailable indicates if we can read the disk temperature now.
 // In general it means the disk is not idle
 func (d *Disk) TemperatureAvailable() bool {
+	if !d.Present() {
+		return false
+	}
 	switch d.config.MonitorTemperature {
 	case "never", "":
 		return false
@@ -149,7 +242,15 @@ func (d *Disk) Temperature() int {
 	if !d.TemperatureAvailable() {
 		return 0
 	}
-	if sensor, ok := d.global.TemperatureSensors[d.config.TemperatureSensor]; ok {
+	sensor, ok := d.global.TemperatureSensors[d.config.TemperatureSensor]
+	if _, isSmart := sensor.(SmartGetter); isSmart {
+		// share the same SMART reading
+		if data := d.Smart(); data != nil {
+			return data.Temperature
+		}
+		return 0
+	}
+	if ok {
 		temperature, err := d.temperature.Get(func() (int, error) {
 			output, err := sensor.Get(d.expandEnv)
 			if err != nil {
@@ -165,35 +266,170 @@ func (d *Disk) Temperature() int {
 	return 0
 }
 
+// HasSmart indicates if the disk has a sensor returning SMART data
+func (d *Disk) HasSmart() bool {
+	return d.smartSensor() != nil
+}
+
+// Smart returns the disk health attributes (kept in cache for about 1 minute), or nil when they're not available.
+// Like the temperature, SMART data is only read when allowed by monitor_temperature so we never wake up a sleeping disk.
+func (d *Disk) Smart() *SmartData {
+	sensor := d.smartSensor()
+	if sensor == nil {
+		return nil
+	}
+	if !d.TemperatureAvailable() {
+		return nil
+	}
+	data, err := d.smart.Get(func() (SmartData, error) {
+		data, err := sensor.GetSmart(d.expandEnv)
+		if err != nil {
+			return data, err
+		}
+		d.checkHealth(data)
+		return data, nil
+	})
+	if err != nil {
+		d.log.Debugf("cannot read SMART data: %s", err)
+		return nil
+	}
+	return &data
+}
+
+// smartSensor returns the sensor configured with smart_sensor, or the temperature sensor if it's returning SMART data
+func (d *Disk) smartSensor() SmartGetter {
+	name := d.config.SmartSensor
+	if name == "" {
+		name = d.config.TemperatureSensor
+	}
+	if d.global == nil || name == "" {
+		return nil
+	}
+	if sensor, ok := d.global.TemperatureSensors[name].(SmartGetter); ok {
+		return sensor
+	}
+	return nil
+}
+
+// checkHealth raises an event when the health attributes degrade from the previous reading
+func (d *Disk) checkHealth(data SmartData) {
+	if d.previousSmart != nil {
+		changes := data.Degraded(*d.previousSmart)
+		if len(changes) > 0 {
+			d.global.RaiseEvent(NewEvent(EventDiskHealthDegraded, d.Name, strings.Join(changes, ", ")))
+		}
+	}
+	if d.maxTemperature > 0 && data.Temperature > d.maxTemperature &&
+		(d.previousSmart == nil || d.previousSmart.Temperature <= d.maxTemperature) {
+		d.global.RaiseEvent(NewEvent(EventDiskTemperatureHigh, d.Name,
+			fmt.Sprintf("temperature %d°C above the limit of %d°C", data.Temperature, d.maxTemperature)))
+	}
+	d.previousSmart = &data
+}
+
 // LastActivity returns the last time the disk has been reading or writing
 func (d *Disk) LastActivity() time.Time {
-	var err error
+	d.activityMutex.Lock()
+	defer d.activityMutex.Unlock()
+
+	return d.lastActivity
+}
 
+// restoreLastActivity loads the last activity saved in the state file, so a restart doesn't postpone the standby
+func (d *Disk) restoreLastActivity(lastActivity time.Time) {
+	if lastActivity.IsZero() || lastActivity.After(time.Now()) {
+		return
+	}
 	d.activityMutex.Lock()
 	defer d.activityMutex.Unlock()
 
-	if d.stats == nil || d.lastActivity.IsZero() {
-		d.stats, _ = d.global.GetDiskstats()
-		d.lastActivity = time.Now()
-		return d.lastActivity
+	d.lastActivity = lastActivity
+}
+
+// updateActivity compares the new snapshot of /proc/diskstats with the previous one,
+// and raises an event when the disk becomes idle or busy
+func (d *Disk) updateActivity(stats *Diskstats) {
+	var event *Event
+
+	if !d.Present() {
+		return
+	}
+	d.activityMutex.Lock()
+	previous := d.stats
+	d.stats = stats
+	if previous != nil {
+		read, write := stats.DevicesIOActivityFrom(previous, d.activityDevices())
+		if read > 0 || write > 0 {
+			if d.idle {
+				d.idle = false
+				message := fmt.Sprintf("activity after being idle for %s", stats.timestamp.Sub(d.lastActivity).Round(time.Second))
+				event = &Event{Name: EventDiskBusy, Source: d.Name, Message: message, Time: stats.timestamp}
+			}
+			d.lastActivity = stats.timestamp
+		} else if !d.idle && d.lastActivity.Add(d.idleAfter).Before(stats.timestamp) {
+			d.idle = true
+			message := fmt.Sprintf("no activity since %s", d.lastActivity.Format(time.DateTime))
+			event = &Event{Name: EventDiskIdle, Source: d.Name, Message: message, Time: stats.timestamp}
+		}
 	}
-	if d.lastActivity.Add(1 * time.Minute).After(time.Now()) {
-		// less than a minute ago, return the value in cache
-		return d.lastActivity
+	d.activityMutex.Unlock()
+
+	if event != nil {
+		d.global.RaiseEvent(*event)
 	}
+}
 
-	previous := d.stats
-	d.stats, err = d.global.GetDiskstats()
+// activityDevices returns the partitions of the disk, or the whole disk when it has no partition.
+// The devices stacked on top of them (like LUKS or mdraid) are not needed: their I/O shows up in the partitions,
+// and a device spanning several disks would otherwise mark all of them busy.
+func (d *Disk) activityDevices() []string {
+	diskname := filepath.Base(d.Device())
+	partitions, err := diskPartitions(d.global.fs, diskname)
 	if err != nil {
-		d.lastActivity = time.Now()
-		return d.lastActivity
+		d.log.Tracef("cannot find %s in sysfs: %s", diskname, err)
+		if d.stats != nil {
+			// guess from the names in /proc/diskstats instead
+			partitions = d.stats.findPartitions(diskname)
+		}
+	}
+	if len(partitions) == 0 {
+		return []string{diskname}
 	}
+	return partitions
+}
 
-	read, write := d.stats.PartitionsIOActivityFrom(previous, filepath.Base(d.Device))
-	if read > 0 || write > 0 {
-		d.lastActivity = d.stats.timestamp
+// blockDevices returns the disk, its partitions and the devices on top of them (like LUKS or mdraid)
+func (d *Disk) blockDevices() []string {
+	diskname := filepath.Base(d.Device())
+	devices, err := blockDevices(d.global.fs, diskname)
+	if err == nil {
+		return devices
 	}
-	return d.lastActivity
+	d.log.Tracef("cannot find %s in sysfs: %s", diskname, err)
+	if d.stats == nil {
+		return []string{diskname}
+	}
+	// guess from the names in /proc/diskstats instead
+	return append([]string{diskname}, d.stats.findPartitions(diskname)...)
+}
+
+// IORates returns the throughput, IOPS, await and utilisation of the whole disk
+func (d *Disk) IORates() DiskIORates {
+	rates, err := d.global.GetDiskIORates(filepath.Base(d.Device()))
+	if err != nil {
+		d.log.Debugf("cannot read I/O rates: %s", err)
+	}
+	return rates
+}
+
+// IOCounters returns the cumulative I/O counters of the whole disk
+func (d *Disk) IOCounters() DiskIOCounters {
+	stats, err := d.global.GetDiskstats()
+	if err != nil {
+		d.log.Debugf("cannot read I/O counters: %s", err)
+		return DiskIOCounters{}
+	}
+	return stats.Counters(filepath.Base(d.Device()))
 }
 
 // IsIdle returns true when the disk hasn't been reading or writing for a set amount of time
@@ -211,31 +447,120 @@ func (d *Disk) StartStandbyWatch() {
 	if !d.HasForceStandby() {
 		return
 	}
+	if d.managedByPool() {
+		d.log.Debugf("standby of %s is managed by pool %s", d.Device(), d.Pool())
+		return
+	}
 
+	heartbeat := d.global.Health.Register("standby "+d.Name, false)
+	// the checks are randomised by one minute
+	timeout := 2*d.checkEvery + 2*time.Minute
 	go func() {
-		clog.Debugf("will set %s in standby mode after %s of inactivity", d.Device, d.standbyAfter)
+		d.log.Debugf("will set %s in standby mode after %s of inactivity", d.Device(), d.standbyAfter)
 		for {
-			if d.IsActive() {
-				if d.LastActivity().Add(d.standbyAfter).Before(time.Now()) {
-					// time to put the disk to sleep
-					d.diskStatus.Standby(d.expandEnv)
-					d.active.Set(int(enum.DiskStatusActive))
-				}
+			heartbeat.Beat(timeout)
+			if !d.IsActive() || d.LastActivity().Add(d.standbyAfter).After(time.Now()) {
+				d.unblockStandby()
+			} else if reason := d.standbyInhibitor(); reason != "" {
+				d.log.Debugf("not forcing standby: %s", reason)
+				d.blockStandby(StandbyInhibited)
+			} else if d.SpinUpBudgetSpent() {
+				d.log.Debugf("spun up %d times during the last 24h: not forcing standby", d.SpinUpsLastDay())
+				d.blockStandby(StandbyBudgetSpent)
+			} else {
+				// time to put the disk to sleep
+				d.standby()
 			}
 			// default timer is set to duration plus or minus 1 minute
 			duration := d.checkEvery + time.Duration((rand.IntN(120)-60))*time.Second
-			clog.Debugf("disk idle check for %s in %s", d.Device, duration)
-			time.Sleep(duration)
+			d.log.Debugf("disk idle check for %s in %s", d.Device(), duration)
+			if !d.global.sleep(duration) {
+				heartbeat.Done()
+				return
+			}
 		}
 	}()
 }
 
+// standbyInhibitor returns the reason why the disk shouldn't be put in standby mode right now, or an empty string
+func (d *Disk) standbyInhibitor() string {
+	if reason := d.inhibitor.Reason(time.Now()); reason != "" {
+		return reason
+	}
+	if pool, ok := d.global.DiskPools[d.Pool()]; ok {
+		return pool.standbyInhibitor()
+	}
+	return ""
+}
+
+// standby puts the disk in standby mode
+func (d *Disk) standby() bool {
+	if d.diskStatus == nil {
+		return false
+	}
+	err := d.diskStatus.Standby(d.expandEnv)
+	if err != nil {
+		d.log.Errorf("cannot set standby mode: %s", err)
+		d.countStandbyAction(StandbyFailed)
+		return false
+	}
+	d.countStandbyAction(StandbySucceeded)
+	d.active.Set(int(enum.DiskStatusStandby))
+	d.observeStatus(enum.DiskStatusStandby, true)
+	return true
+}
+
+// wakeUp spins up the disk, if the power status supports it
+func (d *Disk) wakeUp() bool {
+	waker, ok := d.diskStatus.(DiskWaker)
+	if !ok {
+		d.log.Warningf("power status doesn't support waking up the disk")
+		return false
+	}
+	err := waker.Wake(d.expandEnv)
+	if err != nil {
+		d.log.Errorf("cannot wake up: %s", err)
+		return false
+	}
+	d.active.Set(int(enum.DiskStatusActive))
+	d.observeStatus(enum.DiskStatusActive, true)
+	return true
+}
+
+// refreshStatus reads the power status from the disk, bypassing the cache. It returns the previous and the current status.
+func (d *Disk) refreshStatus() (enum.DiskStatus, enum.DiskStatus) {
+	if d.diskStatus == nil {
+		return enum.DiskStatusUnknown, enum.DiskStatusUnknown
+	}
+	d.spinMutex.Lock()
+	previous := d.lastStatus
+	d.spinMutex.Unlock()
+
+	status := d.diskStatus.Get(d.expandEnv)
+	d.observeStatus(status, false)
+	d.active.Set(int(status))
+	return previous, status
+}
+
+// refreshStatusOnActivity returns the last known status of the disk. The power status is only read from the disk
+// when it's not known as active, and some activity was seen since the time: a sleeping disk is left alone.
+func (d *Disk) refreshStatusOnActivity(since time.Time) (enum.DiskStatus, enum.DiskStatus) {
+	d.spinMutex.Lock()
+	last := d.lastStatus
+	d.spinMutex.Unlock()
+
+	if last == enum.DiskStatusActive || !d.LastActivity().After(since) {
+		return last, last
+	}
+	return d.refreshStatus()
+}
+
 func (d *Disk) expandEnv(input string) string {
 	switch input {
 	case "DEVICE":
-		return d.Device
+		return d.Device()
 	case "DEVICE_NAME":
-		return filepath.Base(d.Device)
+		return filepath.Base(d.Device())
 	}
 	return "$" + input
 }
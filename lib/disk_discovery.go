@@ -0,0 +1,294 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+)
+
+const (
+	DiskDiscoverySourceByID  = "by-id"
+	DiskDiscoverySourceSysfs = "sysfs"
+
+	diskByIDPath   = "/dev/disk/by-id"
+	partitionInfix = "-part"
+)
+
+// DiscoveredDisk is a disk found by the disk discovery
+type DiscoveredDisk struct {
+	Name   string // name of the disk in the configuration: the by-id name or the kernel name
+	Device string // path of the device used in the configuration
+	Kernel string // kernel name like "sda"
+	Model  string
+	Serial string
+}
+
+// DiskDiscovery finds the disks matching the include and exclude rules
+type DiskDiscovery struct {
+	global        *Global
+	config        cfg.DiskDiscovery
+	includeRegexp []*regexp.Regexp
+	excludeRegexp []*regexp.Regexp
+	rescanEvery   time.Duration
+}
+
+// NewDiskDiscovery creates a disk discovery from the configuration
+func NewDiskDiscovery(global *Global, config cfg.DiskDiscovery) (*DiskDiscovery, error) {
+	var err error
+
+	if config.Source == "" {
+		config.Source = DiskDiscoverySourceByID
+	}
+	if config.Source != DiskDiscoverySourceByID && config.Source != DiskDiscoverySourceSysfs {
+		return nil, fmt.Errorf("unknown disk discovery source %q: expected %q or %q", config.Source, DiskDiscoverySourceByID, DiskDiscoverySourceSysfs)
+	}
+	// validate the glob patterns now
+	for _, pattern := range slices.Concat(config.Include, config.Exclude, config.Models, config.Serials) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	includeRegexp, err := compileRegexps(config.IncludeRegexp)
+	if err != nil {
+		return nil, err
+	}
+	excludeRegexp, err := compileRegexps(config.ExcludeRegexp)
+	if err != nil {
+		return nil, err
+	}
+
+	var rescanEvery time.Duration
+	if config.RescanEvery != "" {
+		rescanEvery, err = time.ParseDuration(config.RescanEvery)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &DiskDiscovery{
+		global:        global,
+		config:        config,
+		includeRegexp: includeRegexp,
+		excludeRegexp: excludeRegexp,
+		rescanEvery:   rescanEvery,
+	}, nil
+}
+
+// Scan returns the disks matching the rules, sorted by name. A disk with more than one name in /dev/disk/by-id is only returned once.
+func (d *DiskDiscovery) Scan() ([]DiscoveredDisk, error) {
+	var disks []DiscoveredDisk
+	var err error
+
+	if d.config.Source == DiskDiscoverySourceSysfs {
+		disks, err = d.scanSysfs()
+	} else {
+		disks, err = d.scanByID()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]bool, len(disks))
+	matching := make([]DiscoveredDisk, 0, len(disks))
+	for _, disk := range disks {
+		if found[disk.Kernel] || !d.match(disk) {
+			continue
+		}
+		found[disk.Kernel] = true
+		matching = append(matching, disk)
+	}
+	return matching, nil
+}
+
+// Start scans the disks at regular intervals, if configured
+func (d *DiskDiscovery) Start() {
+	if d.rescanEvery <= 0 {
+		return
+	}
+	go func() {
+		for d.global.sleep(d.rescanEvery) {
+			d.global.addDiscoveredDisks()
+		}
+	}()
+}
+
+func (d *DiskDiscovery) scanByID() ([]DiscoveredDisk, error) {
+	entries, err := fs.ReadDir(d.global.fs, fsPath(diskByIDPath))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	disks := make([]DiscoveredDisk, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.Contains(name, partitionInfix) {
+			continue
+		}
+		device := path.Join(diskByIDPath, name)
+		kernelDevice, err := resolveSymlink(d.global.fs, device)
+		if err != nil {
+			clog.Debugf("cannot resolve %s: %s", device, err)
+			continue
+		}
+		disks = append(disks, d.describe(name, device, path.Base(kernelDevice)))
+	}
+	sort.Slice(disks, func(i, j int) bool {
+		return disks[i].Name < disks[j].Name
+	})
+	return disks, nil
+}
+
+func (d *DiskDiscovery) scanSysfs() ([]DiscoveredDisk, error) {
+	entries, err := fs.ReadDir(d.global.fs, sysBlockPath)
+	if err != nil {
+		return nil, err
+	}
+	disks := make([]DiscoveredDisk, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		// only the physical devices have a "device" entry (not loop, ram, dm, md, etc.)
+		if _, err := fs.Stat(d.global.fs, path.Join(sysBlockPath, name, "device")); err != nil {
+			continue
+		}
+		disks = append(disks, d.describe(name, path.Join("/dev", name), name))
+	}
+	// ReadDir is already sorted by name
+	return disks, nil
+}
+
+func (d *DiskDiscovery) describe(name, device, kernel string) DiscoveredDisk {
+	deviceDir := path.Join(sysBlockPath, kernel, "device")
+	return DiscoveredDisk{
+		Name:   name,
+		Device: device,
+		Kernel: kernel,
+		Model:  readTrimmed(d.global.fs, path.Join(deviceDir, "model")),
+		Serial: readSerial(d.global.fs, deviceDir),
+	}
+}
+
+// match returns true when the disk is included and not excluded by the rules
+func (d *DiskDiscovery) match(disk DiscoveredDisk) bool {
+	if len(d.config.Include) > 0 || len(d.includeRegexp) > 0 {
+		if !matchGlobs(d.config.Include, disk.Name) && !matchRegexps(d.includeRegexp, disk.Name) {
+			return false
+		}
+	}
+	if matchGlobs(d.config.Exclude, disk.Name) || matchRegexps(d.excludeRegexp, disk.Name) {
+		return false
+	}
+	if len(d.config.Models) > 0 && !matchGlobs(d.config.Models, disk.Model) {
+		return false
+	}
+	if len(d.config.Serials) > 0 && !matchGlobs(d.config.Serials, disk.Serial) {
+		return false
+	}
+	return true
+}
+
+// addDiscoveredDisks creates and starts the disks found by the discovery that are not configured yet
+func (g *Global) addDiscoveredDisks() {
+	added := g.discoverDisks()
+	if len(added) == 0 {
+		return
+	}
+	g.addDisks(added)
+	for _, disk := range added {
+		disk.StartStandbyWatch()
+		disk.StartHealthWatch()
+	}
+}
+
+// discoverDisks returns the new disks found by the discovery
+func (g *Global) discoverDisks() map[string]*Disk {
+	if g.discovery == nil {
+		return nil
+	}
+	discovered, err := g.discovery.Scan()
+	if err != nil {
+		clog.Errorf("disk discovery: %s", err)
+		return nil
+	}
+
+	existing := g.Disks()
+	known := make(map[string]bool, len(existing))
+	for name, disk := range existing {
+		known[name] = true
+		known[path.Base(disk.Device())] = true
+	}
+
+	added := make(map[string]*Disk)
+	for _, found := range discovered {
+		if known[found.Name] || known[found.Kernel] {
+			continue
+		}
+		config := g.discovery.config.Profile
+		config.Device = found.Device
+		disk, err := NewDisk(g, found.Name, config, g.DiskStatuses)
+		if err != nil {
+			clog.Errorf("disk discovery: ignoring disk %q: %s", found.Name, err)
+			continue
+		}
+		for _, pool := range g.DiskPools {
+			pool.adopt(disk)
+		}
+		clog.Infof("disk discovery: found disk %s (%s) model %q serial %q", found.Name, disk.Device(), found.Model, found.Serial)
+		added[found.Name] = disk
+	}
+	return added
+}
+
+// readSerial returns the serial number from sysfs: NVMe disks have a serial file,
+// SCSI and SATA disks have the unit serial number VPD page
+func readSerial(fileSystem fs.FS, deviceDir string) string {
+	if serial := readTrimmed(fileSystem, path.Join(deviceDir, "serial")); serial != "" {
+		return serial
+	}
+	page, err := fs.ReadFile(fileSystem, path.Join(deviceDir, "vpd_pg80"))
+	if err != nil || len(page) < 4 {
+		return ""
+	}
+	// 4 bytes header, then the serial number
+	return strings.TrimSpace(strings.Trim(string(page[4:]), "\x00"))
+}
+
+func matchGlobs(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func matchRegexps(patterns []*regexp.Regexp, name string) bool {
+	for _, pattern := range patterns {
+		if pattern.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func compileRegexps(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, len(patterns))
+	for i, pattern := range patterns {
+		var err error
+		compiled[i], err = regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+		}
+	}
+	return compiled, nil
+}
@@ -0,0 +1,123 @@
+package lib
+
+import (
+	"os"
+	"testing"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func discoveredNames(t *testing.T, config cfg.DiskDiscovery) []string {
+	t.Helper()
+	discovery, err := NewDiskDiscovery(&Global{fs: os.DirFS("test_files/discovery")}, config)
+	require.NoError(t, err)
+	disks, err := discovery.Scan()
+	require.NoError(t, err)
+	names := make([]string, len(disks))
+	for i, disk := range disks {
+		names[i] = disk.Name
+	}
+	return names
+}
+
+func TestInvalidDiskDiscovery(t *testing.T) {
+	testData := []cfg.DiskDiscovery{
+		{Source: "udev"},
+		{Include: []string{"ata-["}},
+		{ExcludeRegexp: []string{"ata-("}},
+		{RescanEvery: "often"},
+	}
+	for _, config := range testData {
+		_, err := NewDiskDiscovery(&Global{}, config)
+		assert.Error(t, err)
+	}
+}
+
+func TestDiscoverDisksByID(t *testing.T) {
+	discovery, err := NewDiskDiscovery(&Global{fs: os.DirFS("test_files/discovery")}, cfg.DiskDiscovery{})
+	require.NoError(t, err)
+	disks, err := discovery.Scan()
+	require.NoError(t, err)
+	assert.Equal(t, []DiscoveredDisk{
+		{Name: "ata-ST2000DM001-1CH164_Z1E1AAAA", Device: "/dev/disk/by-id/ata-ST2000DM001-1CH164_Z1E1AAAA", Kernel: "sda", Model: "ST2000DM001-1CH1", Serial: "Z1E1AAAA"},
+		{Name: "ata-ST2000DM001-1CH164_Z1E1BBBB", Device: "/dev/disk/by-id/ata-ST2000DM001-1CH164_Z1E1BBBB", Kernel: "sdb", Model: "ST2000DM001-1CH1", Serial: "Z1E1BBBB"},
+		{Name: "ata-WDC_WD40EFRX-68N32N0_WD-WCC7KCCCC", Device: "/dev/disk/by-id/ata-WDC_WD40EFRX-68N32N0_WD-WCC7KCCCC", Kernel: "sdc", Model: "WDC WD40EFRX-68N", Serial: "WD-WCC7KCCCC"},
+		{Name: "nvme-Samsung_SSD_970_EVO_Plus_1TB_S4EWNX0N123456", Device: "/dev/disk/by-id/nvme-Samsung_SSD_970_EVO_Plus_1TB_S4EWNX0N123456", Kernel: "nvme0n1", Model: "Samsung SSD 970 EVO Plus 1TB", Serial: "S4EWNX0N123456"},
+		{Name: "usb-Generic_Flash_Disk_1234-0-0", Device: "/dev/disk/by-id/usb-Generic_Flash_Disk_1234-0-0", Kernel: "sdd", Model: "Flash Disk"},
+	}, disks)
+}
+
+func TestDiscoverDisksFromSysfs(t *testing.T) {
+	assert.Equal(t,
+		[]string{"nvme0n1", "sda", "sdb", "sdc", "sdd"},
+		discoveredNames(t, cfg.DiskDiscovery{Source: DiskDiscoverySourceSysfs}))
+}
+
+func TestDiskDiscoveryRules(t *testing.T) {
+	testData := []struct {
+		config   cfg.DiskDiscovery
+		expected []string
+	}{
+		{
+			cfg.DiskDiscovery{Include: []string{"ata-*"}, Exclude: []string{"*BBBB"}},
+			[]string{"ata-ST2000DM001-1CH164_Z1E1AAAA", "ata-WDC_WD40EFRX-68N32N0_WD-WCC7KCCCC"},
+		},
+		{
+			cfg.DiskDiscovery{IncludeRegexp: []string{"^(ata|nvme)-"}, ExcludeRegexp: []string{"WDC"}},
+			[]string{"ata-ST2000DM001-1CH164_Z1E1AAAA", "ata-ST2000DM001-1CH164_Z1E1BBBB", "nvme-Samsung_SSD_970_EVO_Plus_1TB_S4EWNX0N123456"},
+		},
+		{
+			// the wwn name is picked up when the ata name is excluded
+			cfg.DiskDiscovery{Include: []string{"wwn-*", "usb-*"}},
+			[]string{"usb-Generic_Flash_Disk_1234-0-0", "wwn-0x5000c500aaaaaaaa"},
+		},
+		{
+			cfg.DiskDiscovery{Models: []string{"ST2000DM001*", "WDC *"}, Serials: []string{"*AAAA", "*CCCC"}},
+			[]string{"ata-ST2000DM001-1CH164_Z1E1AAAA", "ata-WDC_WD40EFRX-68N32N0_WD-WCC7KCCCC"},
+		},
+		{
+			cfg.DiskDiscovery{Source: DiskDiscoverySourceSysfs, Exclude: []string{"sd[cd]"}},
+			[]string{"nvme0n1", "sda", "sdb"},
+		},
+	}
+	for _, testItem := range testData {
+		assert.Equal(t, testItem.expected, discoveredNames(t, testItem.config))
+	}
+}
+
+func TestDiscoveredDisksAreAdded(t *testing.T) {
+	config := cfg.Config{
+		Disks: map[string]cfg.Disk{
+			// already configured under another name
+			"first": {Device: "/dev/disk/by-id/wwn-0x5000c500aaaaaaaa"},
+		},
+		DiskPools: map[string]cfg.DiskPool{
+			"pool": {Disks: []string{"first", "ata-ST2000DM001-1CH164_Z1E1BBBB"}},
+		},
+		DiskDiscovery: cfg.DiskDiscovery{
+			Enabled: true,
+			Include: []string{"ata-*"},
+			Profile: cfg.Disk{StandbyAfter: "1h", MaxTemperature: 50},
+		},
+	}
+	global, err := NewGlobalFS(config, os.DirFS("test_files/discovery"))
+	require.NoError(t, err)
+
+	disks := global.Disks()
+	require.Len(t, disks, 3)
+	assert.Contains(t, disks, "first")
+	assert.NotContains(t, disks, "ata-ST2000DM001-1CH164_Z1E1AAAA")
+
+	second := disks["ata-ST2000DM001-1CH164_Z1E1BBBB"]
+	require.NotNil(t, second)
+	assert.Equal(t, "/dev/sdb", second.Device())
+	assert.Equal(t, "pool", second.Pool())
+	assert.Equal(t, 50, second.maxTemperature)
+	assert.True(t, second.HasForceStandby())
+
+	// nothing new on a rescan
+	global.addDiscoveredDisks()
+	assert.Len(t, global.Disks(), 3)
+}
@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/creativeprojects/clog"
+)
+
+// keep a month of SMART readings to calculate trends
+const smartHistoryRetention = 30 * 24 * time.Hour
+
+// SmartSample is a SMART reading kept in the disk history
+type SmartSample struct {
+	Time time.Time `json:"time"`
+	SmartData
+}
+
+// DiskHealth is the result of the last health check
+type DiskHealth struct {
+	LastCheck time.Time  `json:"last_check"`
+	Smart     *SmartData `json:"smart,omitempty"`
+	Alerts    []string   `json:"alerts"`
+}
+
+// StartHealthWatch collects SMART data periodically (only when the disk is available, see monitor_temperature)
+func (d *Disk) StartHealthWatch() {
+	if !d.HasSmart() || d.smartEvery == 0 {
+		return
+	}
+
+	go func() {
+		d.log.Debugf("will collect SMART data from %s every %s", d.Device(), d.smartEvery)
+		// spread the readings a little bit so all the disks are not queried at the same time
+		for d.global.sleep(d.smartEvery + time.Duration(rand.IntN(30))*time.Second) {
+			if d.collectHealth() {
+				err := d.global.SaveState()
+				if err != nil {
+					clog.Errorf("cannot save state: %s", err)
+				}
+			}
+		}
+	}()
+}
+
+// collectHealth reads SMART data and adds it to the history. It returns false when the data was not available.
+func (d *Disk) collectHealth() bool {
+	if !d.TemperatureAvailable() {
+		d.log.Debugf("SMART data not available right now")
+		return false
+	}
+	data := d.Smart()
+	if data == nil {
+		return false
+	}
+	d.recordSmart(SmartSample{Time: time.Now(), SmartData: *data})
+	return true
+}
+
+func (d *Disk) recordSmart(sample SmartSample) {
+	d.healthMutex.Lock()
+	defer d.healthMutex.Unlock()
+
+	history := append(d.smartHistory, sample)
+	// remove old readings
+	start := 0
+	for start < len(history) && history[start].Time.Add(smartHistoryRetention).Before(sample.Time) {
+		start++
+	}
+	d.smartHistory = history[start:]
+	d.health = DiskHealth{
+		LastCheck: sample.Time,
+		Smart:     &sample.SmartData,
+		Alerts:    d.healthAlerts(sample),
+	}
+	for _, alert := range d.health.Alerts {
+		d.log.Warningf("%s", alert)
+	}
+}
+
+// healthAlerts compares the sample with the oldest one in the history
+func (d *Disk) healthAlerts(sample SmartSample) []string {
+	alerts := make([]string, 0)
+	if !sample.Healthy {
+		alerts = append(alerts, "SMART overall health check failed")
+	}
+	if d.maxTemperature > 0 && sample.Temperature > d.maxTemperature {
+		alerts = append(alerts, fmt.Sprintf("temperature %d°C above the limit of %d°C", sample.Temperature, d.maxTemperature))
+	}
+	if len(d.smartHistory) == 0 {
+		return alerts
+	}
+	oldest := d.smartHistory[0]
+	since := oldest.Time.Format(time.DateTime)
+	if sample.ReallocatedSectors > oldest.ReallocatedSectors {
+		alerts = append(alerts, fmt.Sprintf("reallocated sectors raised by %d since %s", sample.ReallocatedSectors-oldest.ReallocatedSectors, since))
+	}
+	if sample.PendingSectors > oldest.PendingSectors {
+		alerts = append(alerts, fmt.Sprintf("pending sectors raised by %d since %s", sample.PendingSectors-oldest.PendingSectors, since))
+	}
+	if sample.CRCErrors > oldest.CRCErrors {
+		alerts = append(alerts, fmt.Sprintf("CRC errors raised by %d since %s", sample.CRCErrors-oldest.CRCErrors, since))
+	}
+	return alerts
+}
+
+// Health returns the result of the last health check
+func (d *Disk) Health() DiskHealth {
+	d.healthMutex.Lock()
+	defer d.healthMutex.Unlock()
+
+	return d.health
+}
+
+// SmartHistory returns a copy of the SMART readings
+func (d *Disk) SmartHistory() []SmartSample {
+	d.healthMutex.Lock()
+	defer d.healthMutex.Unlock()
+
+	history := make([]SmartSample, len(d.smartHistory))
+	copy(history, d.smartHistory)
+	return history
+}
+
+// restoreSmartHistory loads the history saved in the state file
+func (d *Disk) restoreSmartHistory(history []SmartSample) {
+	if len(history) == 0 {
+		return
+	}
+	d.healthMutex.Lock()
+	defer d.healthMutex.Unlock()
+
+	d.smartHistory = history
+	last := history[len(history)-1]
+	// so we can detect a degradation which happened while we were not running
+	d.previousSmart = &last.SmartData
+	d.health = DiskHealth{
+		LastCheck: last.Time,
+		Smart:     &last.SmartData,
+		Alerts:    d.healthAlerts(last),
+	}
+}
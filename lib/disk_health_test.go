@@ -0,0 +1,81 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthAlerts(t *testing.T) {
+	disk := &Disk{Name: "disk", maxTemperature: 50}
+	start := time.Now().Add(-smartHistoryRetention - 4*time.Hour)
+
+	// this one will be removed from the history
+	disk.recordSmart(SmartSample{Time: start, SmartData: SmartData{Healthy: true}})
+	disk.recordSmart(SmartSample{Time: start.Add(smartHistoryRetention + 2*time.Hour), SmartData: SmartData{Healthy: true, Temperature: 40, ReallocatedSectors: 2}})
+	assert.Len(t, disk.SmartHistory(), 1)
+	assert.Empty(t, disk.Health().Alerts)
+
+	disk.recordSmart(SmartSample{Time: start.Add(smartHistoryRetention + 3*time.Hour), SmartData: SmartData{Healthy: true, Temperature: 52, ReallocatedSectors: 4, CRCErrors: 1}})
+	assert.Len(t, disk.SmartHistory(), 2)
+	health := disk.Health()
+	require.NotNil(t, health.Smart)
+	assert.Equal(t, 52, health.Smart.Temperature)
+	assert.Len(t, health.Alerts, 3)
+	assert.Contains(t, health.Alerts[0], "temperature 52°C above the limit of 50°C")
+	assert.Contains(t, health.Alerts[1], "reallocated sectors raised by 2")
+	assert.Contains(t, health.Alerts[2], "CRC errors raised by 1")
+}
+
+func TestSaveAndRestoreSmartHistory(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state", "state.json")
+	config := cfg.Config{
+		StateFile: stateFile,
+		Disks: map[string]cfg.Disk{
+			"first": {Device: "/dev/disk/by-id/ata-ST2000DM001-first"},
+		},
+	}
+	global, err := NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+
+	sample := SmartSample{Time: time.Now().Add(-time.Hour).Round(0).UTC(), SmartData: SmartData{Healthy: true, Temperature: 35, ReallocatedSectors: 1}}
+	global.Disks()["first"].recordSmart(sample)
+	require.NoError(t, global.SaveState())
+
+	// new instance
+	global, err = NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	disk := global.Disks()["first"]
+	assert.Equal(t, []SmartSample{sample}, disk.SmartHistory())
+	require.NotNil(t, disk.previousSmart)
+	assert.Equal(t, sample.SmartData, *disk.previousSmart)
+	assert.Equal(t, sample.Time, disk.Health().LastCheck)
+}
+
+func TestHealthIsNotCollectedWhenDiskIsSleeping(t *testing.T) {
+	global := &Global{
+		fs: os.DirFS("."),
+		TemperatureSensors: map[string]SensorGetter{
+			"smart": &SmartSensor{Name: "smart", fs: testingFS, File: "test_files/smartctl/sda.json"},
+		},
+	}
+	disk, err := NewDisk(global, "disk", cfg.Disk{
+		Device:             "fs_test_files/dev/sda",
+		TemperatureSensor:  "smart",
+		MonitorTemperature: "when_active",
+	}, nil)
+	require.NoError(t, err)
+
+	// no power status available: the disk is considered inactive
+	assert.False(t, disk.collectHealth())
+	assert.Empty(t, disk.SmartHistory())
+
+	disk.config.MonitorTemperature = "always"
+	assert.True(t, disk.collectHealth())
+	assert.Len(t, disk.SmartHistory(), 1)
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/lib/enum"
+)
+
+// udev creates the symlinks in /dev/disk/by-id after the kernel uevent is sent:
+// leave it some time before resolving the devices again
+const hotplugSettleDelay = 3 * time.Second
+
+// StartHotplug listens to the kernel uevents and refreshes the devices of the disks when a disk is added, removed or changed
+func (g *Global) StartHotplug() {
+	if !g.config.Hotplug {
+		return
+	}
+	go func() {
+		clog.Debug("listening to kernel uevents")
+		err := listenUevents(g.ctx, g.handleUevent)
+		if err != nil {
+			clog.Errorf("hotplug disabled: %s", err)
+		}
+	}()
+}
+
+func (g *Global) handleUevent(event Uevent) {
+	if !event.IsBlockDisk() {
+		return
+	}
+	switch event.Action {
+	case UeventActionAdd, UeventActionRemove, UeventActionChange:
+		clog.Debugf("uevent: %s %s", event.Action, event.DevName)
+		g.scheduleDeviceRefresh()
+	}
+}
+
+// scheduleDeviceRefresh refreshes the devices after the settle delay. Events arriving in the meantime are merged together.
+func (g *Global) scheduleDeviceRefresh() {
+	g.hotplugMutex.Lock()
+	defer g.hotplugMutex.Unlock()
+
+	if g.hotplugTimer != nil {
+		g.hotplugTimer.Reset(hotplugSettleDelay)
+		return
+	}
+	g.hotplugTimer = time.AfterFunc(hotplugSettleDelay, func() {
+		g.hotplugMutex.Lock()
+		g.hotplugTimer = nil
+		g.hotplugMutex.Unlock()
+
+		g.refreshDevices()
+	})
+}
+
+// refreshDevices resolves the devices of all the disks again, and looks for new disks
+func (g *Global) refreshDevices() {
+	for _, disk := range g.Disks() {
+		if event := disk.refreshDevice(); event != nil {
+			g.RaiseEvent(*event)
+		}
+	}
+	g.addDiscoveredDisks()
+}
+
+// refreshDevice resolves the configured device again. It returns an event when the disk appeared, disappeared or changed device.
+func (d *Disk) refreshDevice() *Event {
+	device, err := resolveSymlink(d.global.fs, d.config.Device)
+	if err != nil {
+		device = ""
+	}
+
+	d.deviceMutex.Lock()
+	previous := d.device
+	wasPresent := d.present
+	d.device = device
+	d.present = device != ""
+	d.deviceMutex.Unlock()
+
+	if device == previous {
+		return nil
+	}
+	d.resetDevice()
+	if device == "" {
+		d.log.Warningf("device %s has been removed", previous)
+		event := NewEvent(EventDiskRemoved, d.Name, "device "+previous+" removed")
+		return &event
+	}
+	message := "device " + device + " added"
+	if wasPresent {
+		message = "device changed from " + previous + " to " + device
+	}
+	d.log.Infof("%s", message)
+	event := NewEvent(EventDiskAdded, d.Name, message)
+	return &event
+}
+
+// resetDevice forgets everything that was read from the previous device
+func (d *Disk) resetDevice() {
+	d.active.Reset()
+	d.temperature.Reset()
+	d.smart.Reset()
+
+	d.activityMutex.Lock()
+	d.stats = nil
+	d.idle = false
+	d.lastActivity = time.Now()
+	d.activityMutex.Unlock()
+
+	d.spinMutex.Lock()
+	d.lastStatus = enum.DiskStatusUnknown
+	d.spinMutex.Unlock()
+}
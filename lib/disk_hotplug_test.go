@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMissingDiskWithoutHotplug(t *testing.T) {
+	_, err := NewDisk(&Global{fs: os.DirFS(t.TempDir())}, "disk", cfg.Disk{Device: "/dev/disk/by-id/ata-missing"}, nil)
+	assert.Error(t, err)
+}
+
+func TestDiskHotplug(t *testing.T) {
+	root := t.TempDir()
+	byID := filepath.Join(root, "dev/disk/by-id")
+	require.NoError(t, os.MkdirAll(byID, 0o755))
+	link := filepath.Join(byID, "ata-ST2000DM001-first")
+
+	events := make(eventCommand, 10)
+	global := &Global{
+		config: cfg.Config{Hotplug: true},
+		fs:     os.DirFS(root),
+		Schedules: map[string]*Schedule{
+			"events": {Task: &Task{Name: "events", Command: events}, When: []string{"on " + EventDiskAdded, "on " + EventDiskRemoved}},
+		},
+	}
+	disk, err := NewDisk(global, "first", cfg.Disk{Device: "/dev/disk/by-id/ata-ST2000DM001-first", MonitorTemperature: "always"}, nil)
+	require.NoError(t, err)
+	global.disks = map[string]*Disk{"first": disk}
+
+	assert.False(t, disk.Present())
+	assert.Empty(t, disk.Device())
+	assert.False(t, disk.IsActive())
+	assert.False(t, disk.TemperatureAvailable())
+
+	expectEvent := func(expected string) {
+		t.Helper()
+		select {
+		case event := <-events:
+			assert.Equal(t, expected, event)
+		case <-time.After(time.Second):
+			t.Fatalf("no event received: expected %q", expected)
+		}
+	}
+
+	require.NoError(t, os.Symlink("../../sdb", link))
+	global.refreshDevices()
+	assert.True(t, disk.Present())
+	assert.Equal(t, "/dev/sdb", disk.Device())
+	assert.True(t, disk.TemperatureAvailable())
+	expectEvent("disk_added first: device /dev/sdb added")
+
+	// nothing changed
+	global.refreshDevices()
+
+	require.NoError(t, os.Remove(link))
+	require.NoError(t, os.Symlink("../../sdc", link))
+	global.refreshDevices()
+	assert.Equal(t, "/dev/sdc", disk.Device())
+	expectEvent("disk_added first: device changed from /dev/sdb to /dev/sdc")
+
+	require.NoError(t, os.Remove(link))
+	global.refreshDevices()
+	assert.False(t, disk.Present())
+	expectEvent("disk_removed first: device /dev/sdc removed")
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, events)
+}
+
+func TestUeventsScheduleRefresh(t *testing.T) {
+	global := &Global{}
+	global.handleUevent(Uevent{Action: UeventActionAdd, Subsystem: "block", DevType: "partition"})
+	global.handleUevent(Uevent{Action: "bind", Subsystem: "block", DevType: "disk"})
+	assert.Nil(t, global.hotplugTimer)
+
+	global.handleUevent(Uevent{Action: UeventActionAdd, Subsystem: "block", DevType: "disk"})
+	global.hotplugMutex.Lock()
+	defer global.hotplugMutex.Unlock()
+	require.NotNil(t, global.hotplugTimer)
+	global.hotplugTimer.Stop()
+}
@@ -1,34 +1,300 @@
 package lib
 
-import "slices"
+import (
+	"fmt"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/creativeprojects/hardware-events/lib/enum"
+)
 
 type DiskPool struct {
-	global *Global
-	Name   string
-	Disks  []string
+	global         *Global
+	Name           string
+	Disks          []string
+	standbyAfter   time.Duration
+	checkEvery     time.Duration
+	wakeTogether   bool
+	wakeCheckEvery time.Duration
+	lastWakeCheck  time.Time // only used by the wake up loop
+	zpool          string
+	membersMutex   sync.Mutex
+	inhibitor      *StandbyInhibitor
+	log            Logger
 }
 
-func NewDiskPool(global *Global, name string, disks []string) *DiskPool {
-	for _, disk := range global.Disks {
-		if slices.Contains(disks, disk.Name) {
-			disk.Pool = name
+func NewDiskPool(global *Global, name string, config cfg.DiskPool) (*DiskPool, error) {
+	var standbyAfter time.Duration
+	var err error
+
+	if config.StandbyAfter != "" {
+		standbyAfter, err = time.ParseDuration(config.StandbyAfter)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	checkEvery := 5 * time.Minute
+	if config.CheckEvery != "" {
+		checkEvery, err = time.ParseDuration(config.CheckEvery)
+		if err != nil {
+			return nil, err
 		}
 	}
-	return &DiskPool{
-		global: global,
-		Name:   name,
-		Disks:  disks,
+
+	wakeCheckEvery := 10 * time.Second
+	if config.WakeCheckEvery != "" {
+		wakeCheckEvery, err = time.ParseDuration(config.WakeCheckEvery)
+		if err != nil {
+			return nil, err
+		}
 	}
+
+	inhibitor, err := NewStandbyInhibitor(global.fs, config.Inhibit)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := &DiskPool{
+		global:         global,
+		Name:           name,
+		Disks:          config.Disks,
+		standbyAfter:   standbyAfter,
+		checkEvery:     checkEvery,
+		wakeTogether:   config.WakeTogether,
+		wakeCheckEvery: wakeCheckEvery,
+		zpool:          config.ZFS,
+		inhibitor:      inhibitor,
+		log:            NewLogger("pool", name),
+	}
+	if global.state != nil {
+		pool.inhibitor.restore(global.state.Inhibits[inhibitKey(InhibitTargetPool, name)])
+	}
+	if pool.zpool != "" && global.ZFS == nil {
+		if !global.config.Simulation {
+			return nil, fmt.Errorf("pool %s: zfs is not enabled", name)
+		}
+		// the zpool commands don't run in simulation mode
+		pool.log.Debugf("ignoring ZFS pool %s in simulation mode", pool.zpool)
+	} else if pool.zpool != "" {
+		if zpool, ok := global.ZFS.Pool(pool.zpool); ok {
+			members := zpoolMembers(zpool, global.Disks())
+			slices.Sort(members)
+			pool.log.Debugf("disks found in ZFS pool %s: %s", pool.zpool, strings.Join(members, ", "))
+			pool.Disks = slices.Clone(config.Disks)
+			for _, member := range members {
+				// the disk might be listed in the configuration too
+				if !slices.Contains(pool.Disks, member) {
+					pool.Disks = append(pool.Disks, member)
+				}
+			}
+		} else {
+			pool.log.Warningf("ZFS pool %s not found", pool.zpool)
+		}
+	}
+	for _, disk := range global.Disks() {
+		pool.adopt(disk)
+	}
+	return pool, nil
+}
+
+// adopt sets the disk as a member of the pool, if it's listed in the configuration
+func (p *DiskPool) adopt(disk *Disk) {
+	p.membersMutex.Lock()
+	defer p.membersMutex.Unlock()
+
+	if !slices.Contains(p.Disks, disk.Name) {
+		if !p.isZpoolMember(disk) {
+			return
+		}
+		p.Disks = append(slices.Clone(p.Disks), disk.Name)
+	}
+	// the pool is taking care of the standby of its members
+	disk.setPool(p.Name, p.standbyAfter > 0)
+}
+
+// isZpoolMember returns true when the disk is a member of the ZFS pool mirrored by this pool
+func (p *DiskPool) isZpoolMember(disk *Disk) bool {
+	zpool, ok := p.Zpool()
+	if !ok {
+		return false
+	}
+	return len(zpoolMembers(zpool, map[string]*Disk{disk.Name: disk})) > 0
+}
+
+// Zpool returns the state of the ZFS pool mirrored by this pool
+func (p *DiskPool) Zpool() (Zpool, bool) {
+	if p.zpool == "" || p.global.ZFS == nil {
+		return Zpool{}, false
+	}
+	return p.global.ZFS.Pool(p.zpool)
+}
+
+// standbyInhibitor returns the reason why the disks of the pool shouldn't be put in standby mode right now, or an empty string
+func (p *DiskPool) standbyInhibitor() string {
+	if reason := p.inhibitor.Reason(time.Now()); reason != "" {
+		return reason
+	}
+	if zpool, ok := p.Zpool(); ok && zpool.ScanInProgress() {
+		return "scrub or resilver in progress on ZFS pool " + zpool.Name
+	}
+	return ""
 }
 
 func (p *DiskPool) CountActive() int {
 	count := 0
-	for _, diskName := range p.Disks {
-		if disk, ok := p.global.Disks[diskName]; ok {
+	for _, disk := range p.members() {
+		if disk.IsActive() {
+			count++
+		}
+	}
+	return count
+}
+
+// HasForceStandby returns true when all the disks of the pool should be set to standby mode together
+func (p *DiskPool) HasForceStandby() bool {
+	return p.standbyAfter != 0
+}
+
+// LastActivity returns the most recent activity of all the disks in the pool
+func (p *DiskPool) LastActivity() time.Time {
+	var last time.Time
+	for _, disk := range p.members() {
+		activity := disk.LastActivity()
+		if activity.After(last) {
+			last = activity
+		}
+	}
+	return last
+}
+
+// StartStandbyWatch starts the goroutines putting the whole pool in standby mode and waking it up
+func (p *DiskPool) StartStandbyWatch() {
+	if p.HasForceStandby() {
+		heartbeat := p.global.Health.Register("standby pool "+p.Name, false)
+		// the checks are randomised by one minute
+		timeout := 2*p.checkEvery + 2*time.Minute
+		go func() {
+			p.log.Debugf("will set the pool in standby mode after %s of inactivity", p.standbyAfter)
+			for {
+				heartbeat.Beat(timeout)
+				p.standbyWhenIdle()
+				// default timer is set to duration plus or minus 1 minute
+				duration := p.checkEvery + time.Duration((rand.IntN(120)-60))*time.Second
+				p.log.Debugf("pool idle check in %s", duration)
+				if !p.global.sleep(duration) {
+					heartbeat.Done()
+					return
+				}
+			}
+		}()
+	}
+	if p.wakeTogether {
+		heartbeat := p.global.Health.Register("wake pool "+p.Name, false)
+		timeout := 2*p.wakeCheckEvery + time.Minute
+		go func() {
+			p.log.Debugf("will wake up all the disks of the pool together")
+			for {
+				heartbeat.Beat(timeout)
+				if !p.global.sleep(p.wakeCheckEvery) {
+					heartbeat.Done()
+					return
+				}
+				p.wakeUpWhenNeeded()
+			}
+		}()
+	}
+}
+
+// standbyWhenIdle puts all the active disks in standby mode when the whole pool has been idle for long enough
+func (p *DiskPool) standbyWhenIdle() {
+	if p.LastActivity().Add(p.standbyAfter).After(time.Now()) {
+		for _, disk := range p.members() {
+			disk.unblockStandby()
+		}
+		return
+	}
+	if reason := p.standbyInhibitor(); reason != "" {
+		p.log.Debugf("not forcing standby: %s", reason)
+		for _, disk := range p.members() {
 			if disk.IsActive() {
-				count++
+				disk.blockStandby(StandbyInhibited)
 			}
 		}
+		return
 	}
-	return count
+	count := 0
+	for _, disk := range p.members() {
+		if !disk.IsActive() {
+			disk.unblockStandby()
+			continue
+		}
+		if reason := disk.inhibitor.Reason(time.Now()); reason != "" {
+			disk.log.Debugf("not forcing standby: %s", reason)
+			disk.blockStandby(StandbyInhibited)
+			continue
+		}
+		if disk.SpinUpBudgetSpent() {
+			disk.log.Debugf("spun up %d times during the last 24h: not forcing standby", disk.SpinUpsLastDay())
+			disk.blockStandby(StandbyBudgetSpent)
+			continue
+		}
+		if disk.standby() {
+			count++
+		}
+	}
+	if count > 0 {
+		p.global.RaiseEvent(NewEvent(EventPoolStandby, p.Name, strconv.Itoa(count)+" disk(s) set in standby mode"))
+	}
+}
+
+// wakeUpWhenNeeded wakes up the sleeping members of the pool as soon as one disk is active again
+func (p *DiskPool) wakeUpWhenNeeded() {
+	since := p.lastWakeCheck
+	p.lastWakeCheck = time.Now()
+	members := p.members()
+	sleeping := make([]*Disk, 0, len(members))
+	wokenUp := ""
+	for _, disk := range members {
+		previous, current := disk.refreshStatusOnActivity(since)
+		if current == enum.DiskStatusActive {
+			if previous != enum.DiskStatusActive && previous != enum.DiskStatusUnknown {
+				wokenUp = disk.Name
+			}
+			continue
+		}
+		if current != enum.DiskStatusUnknown {
+			sleeping = append(sleeping, disk)
+		}
+	}
+	if wokenUp == "" || len(sleeping) == 0 {
+		return
+	}
+	count := 0
+	for _, disk := range sleeping {
+		if disk.wakeUp() {
+			count++
+		}
+	}
+	p.global.RaiseEvent(NewEvent(EventPoolWakeUp, p.Name, "disk "+wokenUp+" woke up: "+strconv.Itoa(count)+" other disk(s) spun up"))
+}
+
+func (p *DiskPool) members() []*Disk {
+	p.membersMutex.Lock()
+	names := p.Disks
+	p.membersMutex.Unlock()
+
+	disks := make([]*Disk, 0, len(names))
+	all := p.global.Disks()
+	for _, diskName := range names {
+		if disk, ok := all[diskName]; ok {
+			disks = append(disks, disk)
+		}
+	}
+	return disks
 }
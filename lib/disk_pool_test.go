@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/creativeprojects/hardware-events/lib/enum"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPool(t *testing.T, config cfg.DiskPool) (*DiskPool, map[string]*mockDiskStatus, eventCommand) {
+	t.Helper()
+	events := make(eventCommand, 10)
+	global := &Global{
+		fs:    os.DirFS("fs_test_files"),
+		disks: make(map[string]*Disk),
+		Schedules: map[string]*Schedule{
+			"events": {Task: &Task{Name: "events", Command: events}, When: []string{"on " + EventPoolStandby, "on " + EventPoolWakeUp}},
+		},
+	}
+	statuses := map[string]*mockDiskStatus{
+		"first":  {status: enum.DiskStatusActive},
+		"second": {status: enum.DiskStatusActive},
+	}
+	devices := map[string]string{
+		"first":  "/dev/disk/by-id/ata-ST2000DM001-first",
+		"second": "/dev/disk/by-id/ata-ST2000DM001-second",
+	}
+	for name, device := range devices {
+		disk, err := NewDisk(global, name, cfg.Disk{Device: device}, map[string]DiskStatuser{name: statuses[name]})
+		require.NoError(t, err)
+		global.disks[name] = disk
+	}
+	pool, err := NewDiskPool(global, "pool", config)
+	require.NoError(t, err)
+	return pool, statuses, events
+}
+
+func TestPoolManagesStandby(t *testing.T) {
+	pool, _, _ := newTestPool(t, cfg.DiskPool{Disks: []string{"first", "second"}, StandbyAfter: "1h"})
+	assert.True(t, pool.HasForceStandby())
+	for _, disk := range pool.members() {
+		assert.Equal(t, "pool", disk.Pool())
+		assert.True(t, disk.managedByPool())
+	}
+}
+
+func TestPoolStandbyWhenIdle(t *testing.T) {
+	pool, statuses, events := newTestPool(t, cfg.DiskPool{Disks: []string{"first", "second"}, StandbyAfter: "1h"})
+	stats, err := pool.global.GetDiskstats()
+	require.NoError(t, err)
+
+	first := pool.global.Disks()["first"]
+	second := pool.global.Disks()["second"]
+	first.stats, second.stats = stats, stats
+	first.lastActivity = time.Now().Add(-2 * time.Hour)
+	second.lastActivity = time.Now().Add(-10 * time.Minute)
+
+	// one disk has been active recently
+	pool.standbyWhenIdle()
+	assert.Equal(t, 2, pool.CountActive())
+
+	second.lastActivity = time.Now().Add(-90 * time.Minute)
+	pool.standbyWhenIdle()
+	assert.Equal(t, 0, pool.CountActive())
+	assert.Equal(t, enum.DiskStatusStandby, statuses["first"].status)
+	assert.Equal(t, enum.DiskStatusStandby, statuses["second"].status)
+
+	select {
+	case event := <-events:
+		assert.Equal(t, "pool_standby pool: 2 disk(s) set in standby mode", event)
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+}
+
+func TestPoolWakeTogether(t *testing.T) {
+	pool, statuses, events := newTestPool(t, cfg.DiskPool{Disks: []string{"first", "second"}, WakeTogether: true})
+	statuses["first"].status = enum.DiskStatusStandby
+	statuses["second"].status = enum.DiskStatusStandby
+
+	pool.wakeUpWhenNeeded()
+	assert.Equal(t, enum.DiskStatusStandby, statuses["second"].status)
+
+	// the power status is not read again without any activity
+	statuses["first"].status = enum.DiskStatusActive
+	pool.wakeUpWhenNeeded()
+	assert.Equal(t, enum.DiskStatusStandby, statuses["second"].status)
+
+	pool.global.Disks()["first"].lastActivity = time.Now()
+	pool.wakeUpWhenNeeded()
+	assert.Equal(t, enum.DiskStatusActive, statuses["second"].status)
+	assert.Equal(t, int64(1), pool.global.Disks()["second"].SpinCounters().SpinUpsByDaemon)
+
+	select {
+	case event := <-events:
+		assert.Equal(t, "pool_wake_up pool: disk first woke up: 1 other disk(s) spun up", event)
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+}
@@ -0,0 +1,153 @@
+package lib
+
+import (
+	"maps"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/lib/enum"
+)
+
+// Result of the standby actions, when a disk was due for standby
+const (
+	StandbySucceeded   = "succeeded"
+	StandbyFailed      = "failed"
+	StandbyInhibited   = "inhibited"
+	StandbyBudgetSpent = "budget_spent"
+)
+
+// SpinCounters counts the transitions between active and standby (or sleeping)
+type SpinCounters struct {
+	SpinUps           int64       `json:"spin_ups"`
+	SpinUpsByDaemon   int64       `json:"spin_ups_by_daemon"`
+	SpinDowns         int64       `json:"spin_downs"`
+	SpinDownsByDaemon int64       `json:"spin_downs_by_daemon"`
+	RecentSpinUps     []time.Time `json:"recent_spin_ups,omitempty"` // spin ups during the last 24 hours
+}
+
+// SpinCounters returns a copy of the spin up and down counters
+func (d *Disk) SpinCounters() SpinCounters {
+	d.spinMutex.Lock()
+	defer d.spinMutex.Unlock()
+
+	d.pruneSpinUps(time.Now())
+	counters := d.spinCounters
+	counters.RecentSpinUps = make([]time.Time, len(d.spinCounters.RecentSpinUps))
+	copy(counters.RecentSpinUps, d.spinCounters.RecentSpinUps)
+	return counters
+}
+
+// StandbyActions returns the number of standby actions by result
+func (d *Disk) StandbyActions() map[string]int64 {
+	d.spinMutex.Lock()
+	defer d.spinMutex.Unlock()
+
+	return maps.Clone(d.standbyActions)
+}
+
+func (d *Disk) countStandbyAction(result string) {
+	d.spinMutex.Lock()
+	defer d.spinMutex.Unlock()
+
+	// the next block will be counted again
+	d.standbyBlocked = ""
+	d.addStandbyAction(result)
+}
+
+// blockStandby counts an inhibited or budget spent standby once, until the disk leaves that state
+func (d *Disk) blockStandby(result string) {
+	d.spinMutex.Lock()
+	defer d.spinMutex.Unlock()
+
+	if d.standbyBlocked == result {
+		return
+	}
+	d.standbyBlocked = result
+	d.addStandbyAction(result)
+}
+
+// unblockStandby is called when the disk is no longer candidate for a standby
+func (d *Disk) unblockStandby() {
+	d.spinMutex.Lock()
+	defer d.spinMutex.Unlock()
+
+	d.standbyBlocked = ""
+}
+
+func (d *Disk) addStandbyAction(result string) {
+	if d.standbyActions == nil {
+		d.standbyActions = make(map[string]int64, 4)
+	}
+	d.standbyActions[result]++
+}
+
+// SpinUpsLastDay returns the number of spin ups during the last 24 hours
+func (d *Disk) SpinUpsLastDay() int {
+	d.spinMutex.Lock()
+	defer d.spinMutex.Unlock()
+
+	d.pruneSpinUps(time.Now())
+	return len(d.spinCounters.RecentSpinUps)
+}
+
+// SpinUpBudgetSpent returns true when the disk has been spun up max_spinups_per_day times during the last 24 hours
+func (d *Disk) SpinUpBudgetSpent() bool {
+	return d.maxSpinUpsPerDay > 0 && d.SpinUpsLastDay() >= d.maxSpinUpsPerDay
+}
+
+// observeStatus records a transition between active and standby.
+// The status is the one read from the disk, byDaemon indicates the change was requested by us.
+func (d *Disk) observeStatus(status enum.DiskStatus, byDaemon bool) {
+	if status == enum.DiskStatusUnknown {
+		return
+	}
+	d.spinMutex.Lock()
+	previous := d.lastStatus
+	d.lastStatus = status
+	changed := false
+	if previous != enum.DiskStatusUnknown {
+		now := time.Now()
+		wasActive := previous == enum.DiskStatusActive
+		isActive := status == enum.DiskStatusActive
+		if !wasActive && isActive {
+			changed = true
+			d.spinCounters.SpinUps++
+			if byDaemon {
+				d.spinCounters.SpinUpsByDaemon++
+			}
+			d.pruneSpinUps(now)
+			d.spinCounters.RecentSpinUps = append(d.spinCounters.RecentSpinUps, now)
+			d.log.Debugf("spinning up (%d during the last 24h)", len(d.spinCounters.RecentSpinUps))
+		} else if wasActive && !isActive {
+			changed = true
+			d.spinCounters.SpinDowns++
+			if byDaemon {
+				d.spinCounters.SpinDownsByDaemon++
+			}
+			d.log.Debugf("spinning down")
+		}
+	}
+	d.spinMutex.Unlock()
+
+	if changed && d.global != nil {
+		// called while the status cache is locked: the state is saved in the background
+		d.global.stateChangedNotify()
+	}
+}
+
+// pruneSpinUps removes the spin ups older than 24 hours. The mutex must be held by the caller.
+func (d *Disk) pruneSpinUps(now time.Time) {
+	start := 0
+	for start < len(d.spinCounters.RecentSpinUps) && d.spinCounters.RecentSpinUps[start].Add(24*time.Hour).Before(now) {
+		start++
+	}
+	d.spinCounters.RecentSpinUps = d.spinCounters.RecentSpinUps[start:]
+}
+
+// restoreSpinCounters loads the counters saved in the state file
+func (d *Disk) restoreSpinCounters(counters SpinCounters) {
+	d.spinMutex.Lock()
+	defer d.spinMutex.Unlock()
+
+	d.spinCounters = counters
+	d.pruneSpinUps(time.Now())
+}
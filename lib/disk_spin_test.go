@@ -0,0 +1,125 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/creativeprojects/hardware-events/lib/enum"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockDiskStatus struct {
+	status enum.DiskStatus
+}
+
+func (s *mockDiskStatus) Get(expandEnv func(string) string) enum.DiskStatus {
+	return s.status
+}
+
+func (s *mockDiskStatus) Standby(expandEnv func(string) string) error {
+	s.status = enum.DiskStatusStandby
+	return nil
+}
+
+func (s *mockDiskStatus) Wake(expandEnv func(string) string) error {
+	s.status = enum.DiskStatusActive
+	return nil
+}
+
+// newTestGlobal returns a global reading fs_test_files, with an active disk for each name and device
+func newTestGlobal(t *testing.T, devices map[string]string) *Global {
+	t.Helper()
+	global := &Global{
+		fs:        os.DirFS("fs_test_files"),
+		disks:     make(map[string]*Disk, len(devices)),
+		DiskPools: make(map[string]*DiskPool),
+	}
+	for name, device := range devices {
+		disk, err := NewDisk(global, name, cfg.Disk{Device: device}, map[string]DiskStatuser{name: &mockDiskStatus{status: enum.DiskStatusActive}})
+		require.NoError(t, err)
+		global.disks[name] = disk
+	}
+	return global
+}
+
+func TestSpinCounters(t *testing.T) {
+	disk := &Disk{Name: "disk"}
+
+	disk.observeStatus(enum.DiskStatusActive, false)
+	disk.observeStatus(enum.DiskStatusUnknown, false)
+	disk.observeStatus(enum.DiskStatusActive, false)
+	assert.Equal(t, SpinCounters{RecentSpinUps: []time.Time{}}, disk.SpinCounters())
+
+	disk.observeStatus(enum.DiskStatusStandby, true)
+	disk.observeStatus(enum.DiskStatusSleeping, false)
+	disk.observeStatus(enum.DiskStatusActive, false)
+	disk.observeStatus(enum.DiskStatusStandby, false)
+	disk.observeStatus(enum.DiskStatusActive, true)
+
+	counters := disk.SpinCounters()
+	assert.Equal(t, int64(2), counters.SpinUps)
+	assert.Equal(t, int64(1), counters.SpinUpsByDaemon)
+	assert.Equal(t, int64(2), counters.SpinDowns)
+	assert.Equal(t, int64(1), counters.SpinDownsByDaemon)
+	assert.Equal(t, 2, disk.SpinUpsLastDay())
+}
+
+func TestSpinUpBudget(t *testing.T) {
+	disk := &Disk{Name: "disk"}
+	assert.False(t, disk.SpinUpBudgetSpent())
+
+	disk.maxSpinUpsPerDay = 2
+	disk.restoreSpinCounters(SpinCounters{
+		SpinUps:       3,
+		RecentSpinUps: []time.Time{time.Now().Add(-25 * time.Hour), time.Now().Add(-time.Hour)},
+	})
+	assert.Equal(t, 1, disk.SpinUpsLastDay())
+	assert.False(t, disk.SpinUpBudgetSpent())
+
+	disk.observeStatus(enum.DiskStatusStandby, true)
+	disk.observeStatus(enum.DiskStatusActive, false)
+	assert.True(t, disk.SpinUpBudgetSpent())
+}
+
+func TestStandbyIsCounted(t *testing.T) {
+	diskStatus := &mockDiskStatus{status: enum.DiskStatusActive}
+	disk, err := NewDisk(&Global{fs: os.DirFS(".")}, "disk", cfg.Disk{Device: "fs_test_files/dev/sda"}, map[string]DiskStatuser{"mock": diskStatus})
+	require.NoError(t, err)
+
+	assert.True(t, disk.IsActive())
+	disk.standby()
+	assert.False(t, disk.IsActive())
+	assert.Equal(t, int64(1), disk.SpinCounters().SpinDownsByDaemon)
+}
+
+func TestSaveAndRestoreSpinCounters(t *testing.T) {
+	config := cfg.Config{
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+		Disks: map[string]cfg.Disk{
+			"first": {Device: "/dev/disk/by-id/ata-ST2000DM001-first"},
+		},
+	}
+	global, err := NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	global.Start()
+	defer global.Close()
+
+	disk := global.Disks()["first"]
+	disk.observeStatus(enum.DiskStatusStandby, false)
+	// saves the state in the background
+	disk.observeStatus(enum.DiskStatusActive, false)
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(config.StateFile)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	global, err = NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	counters := global.Disks()["first"].SpinCounters()
+	assert.Equal(t, int64(1), counters.SpinUps)
+	assert.Len(t, counters.RecentSpinUps, 1)
+}
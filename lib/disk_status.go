@@ -2,13 +2,11 @@ package lib
 
 import (
 	"errors"
-	"os"
-	"path/filepath"
+	"io/fs"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/creativeprojects/clog"
 	"github.com/creativeprojects/hardware-events/cfg"
 	"github.com/creativeprojects/hardware-events/lib/enum"
 )
@@ -18,6 +16,11 @@ type DiskStatuser interface {
 	Standby(expandEnv func(string) string) error
 }
 
+// DiskWaker is implemented by the disk statuses able to spin up a disk
+type DiskWaker interface {
+	Wake(expandEnv func(string) string) error
+}
+
 type DiskStatus struct {
 	checkCommand   CommandRunner
 	Name           string
@@ -25,13 +28,15 @@ type DiskStatus struct {
 	DiskStandby    string
 	DiskSleeping   string
 	File           string
+	fs             fs.FS
 	standbyCommand CommandRunner
+	wakeCommand    CommandRunner
 	mutex          sync.Mutex
 }
 
-func NewDiskStatus(name string, config cfg.DiskPowerStatus) (*DiskStatus, error) {
+func NewDiskStatus(name string, config cfg.DiskPowerStatus, fileSystem fs.FS) (*DiskStatus, error) {
 	var timeout time.Duration
-	var checkCommand, standbyCommand CommandRunner
+	var checkCommand, standbyCommand, wakeCommand CommandRunner
 	var err error
 
 	if config.Timeout != "" {
@@ -42,14 +47,21 @@ func NewDiskStatus(name string, config cfg.DiskPowerStatus) (*DiskStatus, error)
 	}
 
 	if config.CheckCommand != "" {
-		checkCommand, err = NewCommand(config.CheckCommand, "", timeout)
+		checkCommand, err = newNamedCommand(name+"_check", config.CheckCommand, "", timeout)
 		if err != nil {
 			return nil, err
 		}
 	}
 
 	if config.StandbyCommand != "" {
-		standbyCommand, err = NewCommand(config.StandbyCommand, "", timeout)
+		standbyCommand, err = newNamedCommand(name+"_standby", config.StandbyCommand, "", timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.WakeCommand != "" {
+		wakeCommand, err = newNamedCommand(name+"_wake", config.WakeCommand, "", timeout)
 		if err != nil {
 			return nil, err
 		}
@@ -62,7 +74,9 @@ func NewDiskStatus(name string, config cfg.DiskPowerStatus) (*DiskStatus, error)
 		DiskStandby:    config.Standby,
 		DiskSleeping:   config.Sleeping,
 		File:           config.File,
+		fs:             fileSystem,
 		standbyCommand: standbyCommand,
+		wakeCommand:    wakeCommand,
 		mutex:          sync.Mutex{},
 	}, nil
 }
@@ -84,24 +98,10 @@ func (s *DiskStatus) Get(expandEnv func(string) string) enum.DiskStatus {
 			return enum.DiskStatusUnknown
 		}
 	} else if len(s.File) > 0 {
-		// we want to reference and use a Sensor instead of copying this code (from Sensor)
-		// resolve file name
-		filename := os.Expand(s.File, expandEnv)
-		matches, err := filepath.Glob(filename)
+		output, err = readSingleFile(s.fs, s.File, expandEnv)
 		if err != nil {
 			return enum.DiskStatusUnknown
 		}
-		if len(matches) != 1 {
-			return enum.DiskStatusUnknown
-		}
-		filename = matches[0]
-		// that's a bit much :)
-		clog.Tracef("reading file %q", filename)
-		bytesOutput, err := os.ReadFile(filename)
-		if err != nil {
-			return enum.DiskStatusUnknown
-		}
-		output = strings.TrimSpace(string(bytesOutput))
 	}
 	if strings.Contains(output, s.DiskActive) {
 		return enum.DiskStatusActive
@@ -125,3 +125,14 @@ func (s *DiskStatus) Standby(expandEnv func(string) string) error {
 	_, err := s.standbyCommand.Run(nil, expandEnv)
 	return err
 }
+
+func (s *DiskStatus) Wake(expandEnv func(string) string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.wakeCommand == nil {
+		return errors.New("no command defined to wake up the disk")
+	}
+	_, err := s.wakeCommand.Run(nil, expandEnv)
+	return err
+}
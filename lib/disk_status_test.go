@@ -1,6 +1,7 @@
 package lib
 
 import (
+	"os"
 	"testing"
 
 	"github.com/creativeprojects/hardware-events/cfg"
@@ -12,7 +13,7 @@ import (
 func TestCanReadStatusFromFile(t *testing.T) {
 	diskStatus, err := NewDiskStatus("test", cfg.DiskPowerStatus{
 		File: "fs_test_files/sys/block/nvme0n1/device/state",
-	})
+	}, os.DirFS("."))
 	require.NoError(t, err)
 
 	assert.NotNil(t, diskStatus)
@@ -22,7 +23,7 @@ func TestCanReadStatusFromFile(t *testing.T) {
 }
 
 func TestNoStandbyIsReturningAnError(t *testing.T) {
-	diskStatus, err := NewDiskStatus("test", cfg.DiskPowerStatus{})
+	diskStatus, err := NewDiskStatus("test", cfg.DiskPowerStatus{}, nil)
 	require.NoError(t, err)
 
 	assert.NotNil(t, diskStatus)
@@ -1,10 +1,12 @@
 package lib
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"strconv"
 	"testing"
+	"time"
 
 	"github.com/creativeprojects/hardware-events/cfg"
 	"github.com/stretchr/testify/assert"
@@ -22,9 +24,57 @@ func TestDiskDevice(t *testing.T) {
 		config := cfg.Disk{
 			Device: devicePath,
 		}
-		disk, err := NewDisk(&Global{}, strconv.Itoa(id), config, nil)
+		disk, err := NewDisk(&Global{fs: os.DirFS(".")}, strconv.Itoa(id), config, nil)
 		require.NoError(t, err)
 
-		assert.Len(t, filepath.Base(disk.Device), 3)
+		assert.Len(t, filepath.Base(disk.Device()), 3)
 	}
 }
+
+func TestDiskBecomesIdleAndBusy(t *testing.T) {
+	events := make(eventCommand, 10)
+	global := &Global{
+		fs: os.DirFS("fs_test_files"),
+		Schedules: map[string]*Schedule{
+			"events": {Task: &Task{Name: "events", Command: events}, When: []string{"on " + EventDiskIdle, "on " + EventDiskBusy}},
+		},
+	}
+	disk, err := NewDisk(global, "first", cfg.Disk{Device: "/dev/disk/by-id/ata-ST2000DM001-first", LastActive: "5m"}, nil)
+	require.NoError(t, err)
+
+	snapshot := func(sectors string, at time.Time) *Diskstats {
+		// the activity is read from the partitions
+		stats, err := NewDiskstats(bytes.NewBufferString("8 0 sda 1905 0 " + sectors + " 67992 16887 50 3038272 97345 0 20056 40188 0 0 0 0\n" +
+			"8 1 sda1 1905 0 " + sectors + " 67992 16887 50 3038272 97345 0 20056 40188 0 0 0 0"))
+		require.NoError(t, err)
+		stats.timestamp = at
+		return stats
+	}
+	start := time.Now()
+	disk.lastActivity = start
+	disk.updateActivity(snapshot("1000", start))
+	disk.updateActivity(snapshot("1000", start.Add(time.Minute)))
+	assert.Equal(t, start, disk.LastActivity())
+	assert.Empty(t, events)
+
+	disk.updateActivity(snapshot("1000", start.Add(6*time.Minute)))
+	select {
+	case event := <-events:
+		assert.Contains(t, event, "disk_idle first: no activity since")
+	case <-time.After(time.Second):
+		t.Fatal("no idle event received")
+	}
+	// no new event while still idle
+	disk.updateActivity(snapshot("1000", start.Add(7*time.Minute)))
+
+	disk.updateActivity(snapshot("1200", start.Add(8*time.Minute)))
+	assert.Equal(t, start.Add(8*time.Minute), disk.LastActivity())
+	select {
+	case event := <-events:
+		assert.Equal(t, "disk_busy first: activity after being idle for 8m0s", event)
+	case <-time.After(time.Second):
+		t.Fatal("no busy event received")
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Empty(t, events)
+}
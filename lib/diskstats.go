@@ -43,14 +43,52 @@ import (
 // 20  time spent flushing
 // ==  =====================================
 
+// Kernels before 2.6.25 only have 4 fields for partitions:
+
+// ==  ===================================
+//  4  reads issued
+//  5  sectors read
+//  6  writes issued
+//  7  sectors written
+// ==  ===================================
+
 const (
 	readSuccess           = 3
 	readSectors           = 5
+	readTime              = 6
 	writeSuccess          = 7
 	writeSectors          = 9
+	writeTime             = 10
 	inputOutputInProgress = 11
+	inputOutputTime       = 12
+	discardSuccess        = 14
+	discardSectors        = 16
+	flushSuccess          = 18
+
+	sectorSize = 512 // diskstats always counts 512 bytes sectors
 )
 
+// DiskIOCounters are the cumulative counters of a block device
+type DiskIOCounters struct {
+	Reads          int64 `json:"reads"`
+	ReadBytes      int64 `json:"read_bytes"`
+	Writes         int64 `json:"writes"`
+	WrittenBytes   int64 `json:"written_bytes"`
+	Discards       int64 `json:"discards"`
+	DiscardedBytes int64 `json:"discarded_bytes"`
+	Flushes        int64 `json:"flushes"`
+}
+
+// DiskIORates are calculated between two snapshots of /proc/diskstats
+type DiskIORates struct {
+	ReadBytesPerSecond  float64 `json:"read_bytes_per_second"`
+	WriteBytesPerSecond float64 `json:"write_bytes_per_second"`
+	ReadIOPS            float64 `json:"read_iops"`
+	WriteIOPS           float64 `json:"write_iops"`
+	AverageAwait        float64 `json:"average_await"` // average time in milliseconds for read and write requests to be served
+	Utilisation         float64 `json:"utilisation"`   // percentage of time the device was busy
+}
+
 type Diskstats struct {
 	timestamp time.Time
 	data      map[string][]string
@@ -74,6 +112,15 @@ func NewDiskstats(r io.Reader) (*Diskstats, error) {
 			line = strings.ReplaceAll(line, "  ", " ")
 		}
 		fields := strings.Split(line, " ")
+		if len(fields) == 7 {
+			// partition on kernel before 2.6.25: convert into the standard layout
+			fields = []string{
+				fields[0], fields[1], fields[2],
+				fields[3], "0", fields[4], "0",
+				fields[5], "0", fields[6], "0",
+				"0", "0", "0",
+			}
+		}
 		// minimum number of fields should be 14 (kernel <4.18)
 		if len(fields) < 14 {
 			return nil, fmt.Errorf("incorrect format: expected 14 or more fields but found %d: %q", len(fields), line)
@@ -114,10 +161,14 @@ func (s *Diskstats) IOActivityFrom(previous *Diskstats, diskname string) (int64,
 }
 
 func (s *Diskstats) PartitionsIOActivityFrom(previous *Diskstats, diskname string) (int64, int64) {
+	return s.DevicesIOActivityFrom(previous, s.findPartitions(diskname))
+}
+
+// DevicesIOActivityFrom returns the number of sectors read and written on all the devices since the previous snapshot
+func (s *Diskstats) DevicesIOActivityFrom(previous *Diskstats, devices []string) (int64, int64) {
 	var read, write int64
-	partitions := s.findPartitions(diskname)
-	currentRead, currentWrite := s.getReadWriteCounters(partitions)
-	previousRead, previousWrite := previous.getReadWriteCounters(partitions)
+	currentRead, currentWrite := s.getReadWriteCounters(devices)
+	previousRead, previousWrite := previous.getReadWriteCounters(devices)
 
 	if currentRead > 0 {
 		read = currentRead - previousRead
@@ -157,21 +208,96 @@ func (s *Diskstats) getSingleReadWriteCounters(diskname string) (int64, int64) {
 	return read, write
 }
 
+// findPartitions guesses the partitions from their names only. Use blockDevices when sysfs is available.
 func (s *Diskstats) findPartitions(diskname string) []string {
+	// when the disk name ends with a digit (nvme0n1, mmcblk0, md0) the partitions are named like nvme0n1p1
+	prefix := diskname
+	if diskname != "" && diskname[len(diskname)-1] >= '0' && diskname[len(diskname)-1] <= '9' {
+		prefix += "p"
+	}
 	partitions := []string{}
 	for key := range s.data {
-		if key == diskname {
+		if key == diskname || !strings.HasPrefix(key, prefix) {
 			continue
 		}
-		if strings.HasPrefix(key, diskname) {
-			partitionID := strings.TrimPrefix(key, diskname)
-			_, err := strconv.Atoi(partitionID)
-			if err != nil {
-				continue
-			}
-			// that's a number behind the diskname => found a partition
-			partitions = append(partitions, key)
+		partitionID := strings.TrimPrefix(key, prefix)
+		if !isDigits(partitionID) {
+			continue
 		}
+		// that's a number behind the diskname => found a partition
+		partitions = append(partitions, key)
 	}
 	return partitions
 }
+
+func isDigits(input string) bool {
+	if input == "" {
+		return false
+	}
+	for _, char := range input {
+		if char < '0' || char > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Counters returns the cumulative counters of the device. Discards and flushes are only available on recent kernels.
+func (s *Diskstats) Counters(diskname string) DiskIOCounters {
+	return DiskIOCounters{
+		Reads:          s.field(diskname, readSuccess),
+		ReadBytes:      s.field(diskname, readSectors) * sectorSize,
+		Writes:         s.field(diskname, writeSuccess),
+		WrittenBytes:   s.field(diskname, writeSectors) * sectorSize,
+		Discards:       s.field(diskname, discardSuccess),
+		DiscardedBytes: s.field(diskname, discardSectors) * sectorSize,
+		Flushes:        s.field(diskname, flushSuccess),
+	}
+}
+
+// RatesFrom calculates the I/O rates of the device since the previous snapshot
+func (s *Diskstats) RatesFrom(previous *Diskstats, diskname string) DiskIORates {
+	rates := DiskIORates{}
+	if previous == nil {
+		return rates
+	}
+	elapsed := s.timestamp.Sub(previous.timestamp).Seconds()
+	if elapsed <= 0 {
+		return rates
+	}
+	delta := func(index int) float64 {
+		value := s.field(diskname, index) - previous.field(diskname, index)
+		if value < 0 {
+			// counter wrapped around or device was re-created
+			return 0
+		}
+		return float64(value)
+	}
+	reads := delta(readSuccess)
+	writes := delta(writeSuccess)
+	rates.ReadBytesPerSecond = delta(readSectors) * sectorSize / elapsed
+	rates.WriteBytesPerSecond = delta(writeSectors) * sectorSize / elapsed
+	rates.ReadIOPS = reads / elapsed
+	rates.WriteIOPS = writes / elapsed
+	if reads+writes > 0 {
+		rates.AverageAwait = (delta(readTime) + delta(writeTime)) / (reads + writes)
+	}
+	rates.Utilisation = delta(inputOutputTime) / (elapsed * 1000) * 100
+	if rates.Utilisation > 100 {
+		rates.Utilisation = 100
+	}
+	return rates
+}
+
+// field returns the value at the index, or zero if not available
+func (s *Diskstats) field(diskname string, index int) int64 {
+	disk, ok := s.data[diskname]
+	if !ok || index >= len(disk) {
+		return 0
+	}
+	value, err := strconv.ParseInt(disk[index], 10, 64)
+	if err != nil {
+		return 0
+	}
+	return value
+}
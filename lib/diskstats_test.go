@@ -3,6 +3,7 @@ package lib
 import (
 	"bytes"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -275,3 +276,95 @@ func TestPartitions(t *testing.T) {
 		})
 	}
 }
+
+func TestPartitionsWithNumberedDiskNames(t *testing.T) {
+	content := `   8       0 sda 1905 0 199840 67992 16887 50 3038272 97345 0 20056 40188 0 0 0 0
+   8       1 sda1 1191 0 186992 14834 16887 50 3038272 97345 0 66284 108876 0 0 0 0
+   8      10 sda10 94 0 8512 205 0 0 0 0 0 184 224 0 0 0 0
+ 259       0 nvme0n1 37612 10 2360830 7434 88110 9413 3276146 152617 0 63340 160052 0 0 0 0
+ 259       1 nvme0n1p1 222 0 13370 34 2 0 2 0 0 48 34 0 0 0 0
+ 259       2 nvme0n1p2 37317 10 2342932 7383 88108 9413 3276144 152617 0 63296 160001 0 0 0 0
+ 259       3 nvme0n10 100 0 800 10 0 0 0 0 0 10 10 0 0 0 0
+ 259       4 nvme0n10p1 100 0 800 10 0 0 0 0 0 10 10 0 0 0 0
+ 179       0 mmcblk0 2216 1064 135998 2170 1 0 1 0 0 2032 2170 0 0 0 0
+ 179       1 mmcblk0p1 2105 1064 131598 2080 1 0 1 0 0 1964 2080 0 0 0 0
+ 179       2 mmcblk0p2 60 0 2768 51 0 0 0 0 0 56 51 0 0 0 0
+   9       0 md0 2364 0 19826 0 27 0 132 0 0 0 0 0 0 0 0
+ 253       0 dm-0 37100 0 2340530 8048 97528 0 3276144 175752 0 63920 183800 0 0 0 0
+`
+	fixtures := []struct {
+		diskname   string
+		partitions []string
+	}{
+		{"sda", []string{"sda1", "sda10"}},
+		{"nvme0n1", []string{"nvme0n1p1", "nvme0n1p2"}},
+		{"nvme0n10", []string{"nvme0n10p1"}},
+		{"mmcblk0", []string{"mmcblk0p1", "mmcblk0p2"}},
+		{"md0", []string{}},
+		{"dm-0", []string{}},
+	}
+
+	stats, err := NewDiskstats(bytes.NewBufferString(content))
+	require.NoError(t, err)
+
+	for _, fixture := range fixtures {
+		t.Run(fixture.diskname, func(t *testing.T) {
+			partitions := stats.findPartitions(fixture.diskname)
+			assert.ElementsMatch(t, fixture.partitions, partitions)
+		})
+	}
+}
+
+func TestDiskIORates(t *testing.T) {
+	stats1, err := NewDiskstats(bytes.NewBufferString(`   8       0 sda 1000 0 20000 5000 2000 0 40000 15000 0 10000 20000 10 0 800 5 3 7`))
+	require.NoError(t, err)
+	stats2, err := NewDiskstats(bytes.NewBufferString(`   8       0 sda 1100 0 22000 5500 2400 0 48000 17500 1 15000 23000 12 0 1000 6 5 9`))
+	require.NoError(t, err)
+	stats2.timestamp = stats1.timestamp.Add(10 * time.Second)
+
+	rates := stats2.RatesFrom(stats1, "sda")
+	assert.InDelta(t, 2000*512/10.0, rates.ReadBytesPerSecond, 0.001)
+	assert.InDelta(t, 8000*512/10.0, rates.WriteBytesPerSecond, 0.001)
+	assert.InDelta(t, 10.0, rates.ReadIOPS, 0.001)
+	assert.InDelta(t, 40.0, rates.WriteIOPS, 0.001)
+	assert.InDelta(t, 3000/500.0, rates.AverageAwait, 0.001)
+	assert.InDelta(t, 50.0, rates.Utilisation, 0.001)
+
+	counters := stats2.Counters("sda")
+	assert.Equal(t, DiskIOCounters{
+		Reads:          1100,
+		ReadBytes:      22000 * 512,
+		Writes:         2400,
+		WrittenBytes:   48000 * 512,
+		Discards:       12,
+		DiscardedBytes: 1000 * 512,
+		Flushes:        5,
+	}, counters)
+}
+
+func TestDiskIORatesWithoutPreviousSnapshot(t *testing.T) {
+	stats, err := NewDiskstats(bytes.NewBufferString(diskstats1[0]))
+	require.NoError(t, err)
+	assert.Equal(t, DiskIORates{}, stats.RatesFrom(nil, "sda"))
+	assert.Equal(t, DiskIORates{}, stats.RatesFrom(stats, "sda"))
+}
+
+func TestDiskIOCountersOnOlderKernels(t *testing.T) {
+	stats, err := NewDiskstats(bytes.NewBufferString(`   8       0 sda 1905 0 199840 67992 16887 50 3038272 97345 0 20056 40188
+   8       1 sda1 1191 186992 16887 3038272`))
+	require.NoError(t, err)
+
+	assert.Equal(t, DiskIOCounters{
+		Reads:        1905,
+		ReadBytes:    199840 * 512,
+		Writes:       16887,
+		WrittenBytes: 3038272 * 512,
+	}, stats.Counters("sda"))
+	assert.Equal(t, DiskIOCounters{
+		Reads:        1191,
+		ReadBytes:    186992 * 512,
+		Writes:       16887,
+		WrittenBytes: 3038272 * 512,
+	}, stats.Counters("sda1"))
+	assert.Equal(t, DiskIOCounters{}, stats.Counters("sdz"))
+}
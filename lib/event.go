@@ -0,0 +1,77 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/creativeprojects/clog"
+)
+
+const (
+	EventDiskHealthDegraded  = "disk_health_degraded"
+	EventDiskTemperatureHigh = "disk_temperature_high"
+	EventPoolStandby         = "pool_standby"
+	EventPoolWakeUp          = "pool_wake_up"
+	EventDiskIdle            = "disk_idle"
+	EventDiskBusy            = "disk_busy"
+	EventDiskAdded           = "disk_added"
+	EventDiskRemoved         = "disk_removed"
+	EventCriticalTemperature = "critical_temperature"
+)
+
+// eventLogLevels lowers the log level of the frequent events, the others are logged as warnings
+var eventLogLevels = map[string]clog.LogLevel{
+	EventDiskIdle: clog.LevelInfo,
+	EventDiskBusy: clog.LevelInfo,
+}
+
+// Event is raised when something worth noticing happened on the hardware.
+// Tasks can be scheduled to run on events with "on <event name>"
+type Event struct {
+	Name    string
+	Source  string // name of the disk, sensor, zone, etc.
+	Message string
+	Time    time.Time
+}
+
+// NewEvent creates a new event happening now
+func NewEvent(name, source, message string) Event {
+	return Event{
+		Name:    name,
+		Source:  source,
+		Message: message,
+		Time:    time.Now(),
+	}
+}
+
+func (e Event) expandEnv(input string) string {
+	switch input {
+	case "EVENT":
+		return e.Name
+	case "EVENT_SOURCE":
+		return e.Source
+	case "EVENT_MESSAGE":
+		return e.Message
+	}
+	return "$" + input
+}
+
+// RaiseEvent logs the event and runs the tasks scheduled on it
+func (g *Global) RaiseEvent(event Event) {
+	level, ok := eventLogLevels[event.Name]
+	if !ok {
+		level = clog.LevelWarning
+	}
+	clog.Logf(level, "%s: %s: %s", event.Name, event.Source, event.Message)
+	for _, schedule := range g.Schedules {
+		if schedule.Task == nil || !schedule.OnEvent(event.Name) {
+			continue
+		}
+		go func(task *Task) {
+			task.log.Debugf("running task on event %s", event.Name)
+			err := task.ExecuteEvent(event)
+			if err != nil {
+				task.log.Errorf("%s", err)
+			}
+		}(schedule.Task)
+	}
+}
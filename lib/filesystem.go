@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/creativeprojects/clog"
+)
+
+// fsPath converts a path from the configuration into a path usable with fs.FS:
+// the file system is rooted at "/" so absolute paths lose their leading "/"
+func fsPath(name string) string {
+	return strings.TrimPrefix(path.Clean(name), "/")
+}
+
+// resolveSingleFile expands the variables and the glob pattern. It returns an error if the pattern doesn't match exactly one file.
+func resolveSingleFile(fileSystem fs.FS, pattern string, expandEnv func(string) string) (string, error) {
+	filename := fsPath(os.Expand(pattern, expandEnv))
+	matches, err := fs.Glob(fileSystem, filename)
+	if err != nil {
+		return "", fmt.Errorf("cannot find file: %w", err)
+	}
+	if len(matches) != 1 {
+		return "", fmt.Errorf("expected 1 file but found %d: %q", len(matches), filename)
+	}
+	return matches[0], nil
+}
+
+// readSingleFile reads the content of the only file matching the pattern
+func readSingleFile(fileSystem fs.FS, pattern string, expandEnv func(string) string) (string, error) {
+	filename, err := resolveSingleFile(fileSystem, pattern, expandEnv)
+	if err != nil {
+		return "", err
+	}
+	// that's a bit much :)
+	clog.Tracef("reading file %q", filename)
+	return readFile(fileSystem, filename)
+}
+
+// readFile returns the trimmed content of the file
+func readFile(fileSystem fs.FS, filename string) (string, error) {
+	output, err := fs.ReadFile(fileSystem, fsPath(filename))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// resolveSymlink returns the destination of the link, or the name itself if it's not a link
+func resolveSymlink(fileSystem fs.FS, name string) (string, error) {
+	fi, err := fs.Lstat(fileSystem, fsPath(name))
+	if err != nil {
+		return "", err
+	}
+	if fi.Mode()&fs.ModeSymlink == 0 {
+		return name, nil
+	}
+	dest, err := fs.ReadLink(fileSystem, fsPath(name))
+	if err != nil {
+		return "", err
+	}
+	if path.IsAbs(dest) {
+		return dest, nil
+	}
+	return path.Join(path.Dir(name), dest), nil
+}
@@ -1,6 +1,11 @@
 package lib
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
 	"math/rand/v2"
 	"os"
 	"path/filepath"
@@ -13,33 +18,103 @@ import (
 	"github.com/creativeprojects/hardware-events/lib/simulation"
 )
 
+const defaultDiskstatsEvery = 10 * time.Second
+
 type Global struct {
 	config             cfg.Config
-	Disks              map[string]*Disk
+	fs                 fs.FS
+	disks              map[string]*Disk // never modified once published: use Disks() from the goroutines and the templates
+	disksMutex         sync.RWMutex
+	discovery          *DiskDiscovery
+	hotplugMutex       sync.Mutex
+	hotplugTimer       *time.Timer
 	DiskPools          map[string]*DiskPool
 	Templates          map[string]*Template
 	Tasks              map[string]*Task
 	Schedules          map[string]*Schedule
 	DiskStatuses       map[string]DiskStatuser
 	TemperatureSensors map[string]SensorGetter
+	sensorReadings     map[string]sensorValue // last value read from each sensor by the fan control
+	readingsMutex      sync.Mutex
 	FanControl         *Control
+	Health             *Health
+	Alerting           *Alerting
+	CriticalPolicies   map[string]*CriticalPolicy // by sensor or disk name
+	ZFS                *ZFS
 	templ              *template.Template
-	diskstats          *Diskstats
+	diskstats          *Diskstats // last snapshot, from the sampler or read on demand
+	sampledDiskstats   *Diskstats // last snapshot taken by the sampler
+	previousDiskstats  *Diskstats // snapshot taken by the sampler before sampledDiskstats
 	diskstatsMutex     sync.Mutex
+	diskstatsEvery     time.Duration
+	state              *State
+	stateMutex         sync.Mutex
+	stateSaveEvery     time.Duration
+	stateChanged       chan struct{} // wakes up the state saver
+	stateMaxAge        time.Duration
+	ctx                context.Context // cancelled by Close to stop the background loops
+	cancel             context.CancelFunc
 }
 
+// NewGlobal creates all the objects from the configuration, using the real file system
 func NewGlobal(config cfg.Config) (*Global, error) {
+	return NewGlobalFS(config, os.DirFS("/"))
+}
+
+// NewGlobalFS creates all the objects from the configuration.
+// All the sysfs and procfs files are read from the file system, which is expected to be rooted at "/"
+func NewGlobalFS(config cfg.Config, fileSystem fs.FS) (*Global, error) {
 	var err error
 	global := &Global{
 		config:             config,
+		fs:                 fileSystem,
 		DiskPools:          make(map[string]*DiskPool, len(config.DiskPools)),
-		Disks:              make(map[string]*Disk, len(config.Disks)),
+		disks:              make(map[string]*Disk, len(config.Disks)),
 		Templates:          make(map[string]*Template, len(config.Templates)),
 		Tasks:              make(map[string]*Task, len(config.Tasks)),
 		TemperatureSensors: make(map[string]SensorGetter, len(config.Sensors)),
+		sensorReadings:     make(map[string]sensorValue, len(config.Sensors)),
 		Schedules:          make(map[string]*Schedule, len(config.Schedule)),
 		DiskStatuses:       make(map[string]DiskStatuser, len(config.DiskPowerStatus)),
 		diskstatsMutex:     sync.Mutex{},
+		diskstatsEvery:     defaultDiskstatsEvery,
+		stateSaveEvery:     defaultStateSaveEvery,
+		stateChanged:       make(chan struct{}, 1),
+		stateMaxAge:        defaultStateMaxAge,
+		Health:             NewHealth(),
+	}
+	global.ctx, global.cancel = context.WithCancel(context.Background())
+
+	if config.Diskstats.SampleEvery != "" {
+		global.diskstatsEvery, err = time.ParseDuration(config.Diskstats.SampleEvery)
+		if err != nil {
+			return global, fmt.Errorf("invalid diskstats sampling interval: %w", err)
+		}
+		if global.diskstatsEvery <= 0 {
+			return global, fmt.Errorf("invalid diskstats sampling interval: %s", global.diskstatsEvery)
+		}
+	}
+
+	if config.StateSaveEvery != "" {
+		global.stateSaveEvery, err = time.ParseDuration(config.StateSaveEvery)
+		if err != nil {
+			return global, fmt.Errorf("invalid state saving interval: %w", err)
+		}
+		if global.stateSaveEvery <= 0 {
+			return global, fmt.Errorf("invalid state saving interval: %s", global.stateSaveEvery)
+		}
+	}
+	if config.StateMaxAge != "" {
+		global.stateMaxAge, err = time.ParseDuration(config.StateMaxAge)
+		if err != nil {
+			return global, fmt.Errorf("invalid state max age: %w", err)
+		}
+	}
+
+	global.state, err = loadState(config.StateFile)
+	if err != nil {
+		// not a reason to stop
+		clog.Errorf("cannot load state file: %s", err)
 	}
 
 	// Disk Power Status
@@ -47,8 +122,10 @@ func NewGlobal(config cfg.Config) (*Global, error) {
 		var diskStatus DiskStatuser
 		if config.Simulation {
 			diskStatus, err = simulation.NewDiskStatus(name, value)
+		} else if value.Type == DiskStatusTypeSGIO {
+			diskStatus, err = NewSGIODiskStatus(name, value)
 		} else {
-			diskStatus, err = NewDiskStatus(name, value)
+			diskStatus, err = NewDiskStatus(name, value, fileSystem)
 		}
 		if err != nil {
 			return global, err
@@ -64,12 +141,37 @@ func NewGlobal(config cfg.Config) (*Global, error) {
 			clog.Errorf("ignoring configuration for disk %q: %s", name, err)
 			continue
 		}
-		global.Disks[name] = disk
+		global.disks[name] = disk
+	}
+
+	// Disk discovery
+	if config.DiskDiscovery.Enabled {
+		global.discovery, err = NewDiskDiscovery(global, config.DiskDiscovery)
+		if err != nil {
+			return global, err
+		}
+		maps.Copy(global.disks, global.discoverDisks())
+	}
+
+	// ZFS pools
+	if config.ZFS.Enabled && !config.Simulation {
+		global.ZFS, err = NewZFS(config.ZFS)
+		if err != nil {
+			return global, err
+		}
+		err = global.ZFS.refresh()
+		if err != nil {
+			// not a reason to stop
+			clog.Errorf("cannot read ZFS pools: %s", err)
+		}
 	}
 
 	// Disk pools
 	for name, value := range config.DiskPools {
-		global.DiskPools[name] = NewDiskPool(global, name, value)
+		global.DiskPools[name], err = NewDiskPool(global, name, value)
+		if err != nil {
+			return global, err
+		}
 	}
 
 	// Templates
@@ -85,9 +187,11 @@ func NewGlobal(config cfg.Config) (*Global, error) {
 	}
 
 	// Now load the templates
-	global.templ, err = template.ParseFiles(templates...)
-	if err != nil {
-		return global, err
+	if len(templates) > 0 {
+		global.templ, err = template.New("").Funcs(templateFunctions).ParseFiles(templates...)
+		if err != nil {
+			return global, err
+		}
 	}
 
 	simulationRand := rand.New(rand.NewPCG(config.Seed1, config.Seed2))
@@ -97,8 +201,10 @@ func NewGlobal(config cfg.Config) (*Global, error) {
 		var sensor SensorGetter
 		if config.Simulation {
 			sensor, err = simulation.NewSensor(sensorName, sensorCfg, simulationRand)
+		} else if sensorCfg.Type == SensorTypeSmart {
+			sensor, err = NewSmartSensor(sensorName, sensorCfg, fileSystem)
 		} else {
-			sensor, err = NewSensor(sensorName, sensorCfg, os.DirFS("/"))
+			sensor, err = NewSensor(sensorName, sensorCfg, fileSystem)
 		}
 		if err != nil {
 			return global, err
@@ -125,42 +231,163 @@ func NewGlobal(config cfg.Config) (*Global, error) {
 	if err != nil {
 		return global, err
 	}
+	global.FanControl.health = global.Health
 
-	// Disk standby mode
-	for _, disk := range global.Disks {
-		disk.StartStandbyWatch()
+	global.CriticalPolicies = make(map[string]*CriticalPolicy, len(config.Critical))
+	for source, criticalCfg := range config.Critical {
+		global.CriticalPolicies[source], err = NewCriticalPolicy(global, source, criticalCfg, config.Simulation)
+		if err != nil {
+			return global, fmt.Errorf("critical policy for %s: %w", source, err)
+		}
+	}
+
+	if len(config.Alerts.Rules) > 0 {
+		global.Alerting, err = NewAlerting(global, config.Alerts)
+		if err != nil {
+			return global, err
+		}
 	}
+	global.restoreFanControl()
 
 	return global, nil
 }
 
+// Start runs the background loops: disk activity, standby mode and health, state saver, hot-plug, alerts, etc.
+func (g *Global) Start() {
+	g.StartDiskstatsSampler()
+	for _, disk := range g.Disks() {
+		disk.StartStandbyWatch()
+		disk.StartHealthWatch()
+	}
+	for _, pool := range g.DiskPools {
+		pool.StartStandbyWatch()
+	}
+	g.StartStateSaver()
+	if g.discovery != nil {
+		g.discovery.Start()
+	}
+	g.StartHotplug()
+	if g.Alerting != nil {
+		g.Alerting.Start()
+	}
+	for _, policy := range g.CriticalPolicies {
+		policy.Start()
+	}
+	if g.ZFS != nil {
+		g.ZFS.Start(g.ctx)
+	}
+}
+
+// Close stops the background loops. The global shouldn't be used afterwards
+func (g *Global) Close() {
+	g.cancel()
+	if g.Alerting != nil {
+		g.Alerting.Stop()
+	}
+	g.hotplugMutex.Lock()
+	defer g.hotplugMutex.Unlock()
+
+	if g.hotplugTimer != nil {
+		g.hotplugTimer.Stop()
+		g.hotplugTimer = nil
+	}
+}
+
+// sleep waits for the duration, and returns false when the global has been closed in the meantime
+func (g *Global) sleep(duration time.Duration) bool {
+	return sleepContext(g.ctx, duration)
+}
+
+// sleepContext waits for the duration, and returns false when the context is cancelled in the meantime
+func sleepContext(ctx context.Context, duration time.Duration) bool {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// GetDiskstats returns the last snapshot of /proc/diskstats. The file is only read again if the sampler is late.
 func (g *Global) GetDiskstats() (*Diskstats, error) {
 	g.diskstatsMutex.Lock()
 	defer g.diskstatsMutex.Unlock()
 
-	if g.diskstats == nil || g.diskstats.timestamp.Add(1*time.Minute).Before(time.Now()) {
+	if g.diskstats == nil || g.diskstats.timestamp.Add(g.diskstatsEvery).Before(time.Now()) {
 		return g.readDiskstats()
 	}
 	return g.diskstats, nil
 }
 
 func (g *Global) readDiskstats() (*Diskstats, error) {
-	clog.Debug("reading /proc/diskstats file")
-
-	file, err := os.Open("/proc/diskstats")
+	file, err := g.fs.Open("proc/diskstats")
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	g.diskstats, err = NewDiskstats(file)
+	diskstats, err := NewDiskstats(file)
 	if err != nil {
 		return nil, err
 	}
+	g.diskstats = diskstats
 
 	return g.diskstats, nil
 }
 
+// StartDiskstatsSampler reads /proc/diskstats at regular intervals and updates the activity of all the disks
+func (g *Global) StartDiskstatsSampler() {
+	if len(g.Disks()) == 0 && g.discovery == nil {
+		return
+	}
+	go func() {
+		clog.Debugf("sampling /proc/diskstats every %s", g.diskstatsEvery)
+		ticker := time.NewTicker(g.diskstatsEvery)
+		defer ticker.Stop()
+		for {
+			g.sampleDiskstats()
+			select {
+			case <-g.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (g *Global) sampleDiskstats() {
+	g.diskstatsMutex.Lock()
+	stats, err := g.readDiskstats()
+	if err == nil {
+		g.previousDiskstats = g.sampledDiskstats
+		g.sampledDiskstats = stats
+	}
+	g.diskstatsMutex.Unlock()
+	if err != nil {
+		clog.Errorf("cannot read /proc/diskstats: %s", err)
+		return
+	}
+	for _, disk := range g.Disks() {
+		disk.updateActivity(stats)
+	}
+}
+
+// GetDiskIORates returns the I/O rates of the device between the last two snapshots taken by the sampler.
+// The snapshots read on demand are not used, so the rates are always calculated over a full sampling interval.
+func (g *Global) GetDiskIORates(diskname string) (DiskIORates, error) {
+	g.diskstatsMutex.Lock()
+	current, previous := g.sampledDiskstats, g.previousDiskstats
+	g.diskstatsMutex.Unlock()
+
+	if current == nil {
+		return DiskIORates{}, errors.New("no sample of /proc/diskstats yet")
+	}
+	return current.RatesFrom(previous, diskname), nil
+}
+
 func (g *Global) GetStartupTasks() []*Task {
 	tasks := make([]*Task, 0, len(g.Schedules))
 	for _, schedule := range g.Schedules {
@@ -184,32 +411,104 @@ func (g *Global) GetTimerTasks() []Timer {
 
 func (g *Global) StartTimers() {
 	for _, timer := range g.GetTimerTasks() {
-		clog.Debugf("setting up timer task %s every %v", timer.task.Name, timer.every)
+		timer.task.log.Debugf("setting up timer task every %v", timer.every)
+		heartbeat := g.Health.Register("task "+timer.task.Name, false)
 		go func(timer Timer) {
-			for {
-				time.Sleep(timer.every)
-				clog.Debugf("running timer task %s", timer.task.Name)
+			// leave some time for the task to run
+			timeout := 2*timer.every + time.Minute
+			heartbeat.Beat(timeout)
+			for g.sleep(timer.every) {
+				timer.task.log.Debugf("running timer task")
 				err := timer.task.Execute()
 				if err != nil {
-					clog.Error(err)
+					timer.task.log.Errorf("%s", err)
 				}
+				heartbeat.Beat(timeout)
 			}
+			heartbeat.Done()
 		}(timer)
 	}
 }
 
+// Disks returns all the disks, including the ones found by the disk discovery.
+// The map returned is never modified afterwards.
+func (g *Global) Disks() map[string]*Disk {
+	g.disksMutex.RLock()
+	defer g.disksMutex.RUnlock()
+
+	return g.disks
+}
+
+// addDisks publishes a new map containing the current disks plus the new ones
+func (g *Global) addDisks(disks map[string]*Disk) {
+	g.disksMutex.Lock()
+	defer g.disksMutex.Unlock()
+
+	all := make(map[string]*Disk, len(g.disks)+len(disks))
+	maps.Copy(all, g.disks)
+	maps.Copy(all, disks)
+	g.disks = all
+}
+
 func (g *Global) GetSensorReader(sensorName string) func() (int, error) {
 	// try "standard" sensor first
 	if sensor, ok := g.TemperatureSensors[sensorName]; ok {
 		return func() (int, error) {
-			return sensor.Get(nil)
+			value, err := sensor.Get(nil)
+			if err == nil {
+				g.readingsMutex.Lock()
+				g.sensorReadings[sensorName] = sensorValue{value: value, readAt: time.Now()}
+				g.readingsMutex.Unlock()
+			}
+			return value, err
 		}
 	}
 	// then try disk sensor
-	if sensor, ok := g.Disks[sensorName]; ok {
+	if sensor, ok := g.Disks()[sensorName]; ok {
 		return func() (int, error) {
 			return sensor.Temperature(), nil
 		}
 	}
 	return nil
 }
+
+// readTemperature returns the value read by the fan control during the last maxAge, or reads the configured sensor,
+// or returns the temperature of a disk when it's monitored right now.
+// It returns false when the name is unknown or the disk temperature is not available.
+func (g *Global) readTemperature(name string, maxAge time.Duration) (int, bool, error) {
+	if sensor, ok := g.TemperatureSensors[name]; ok {
+		g.readingsMutex.Lock()
+		reading, found := g.sensorReadings[name]
+		g.readingsMutex.Unlock()
+		if found && time.Since(reading.readAt) <= maxAge {
+			return reading.value, true, nil
+		}
+		value, err := sensor.Get(nil)
+		return value, true, err
+	}
+	if disk, ok := g.Disks()[name]; ok && disk.TemperatureAvailable() {
+		return disk.Temperature(), true, nil
+	}
+	return 0, false, nil
+}
+
+// sensorValue is a value read from a sensor by the fan control
+type sensorValue struct {
+	value  int
+	readAt time.Time
+}
+
+// SensorTemperatures returns the value of each configured sensor, using the values read by the fan control during the last maxAge.
+// The sensors that cannot be read are left out.
+func (g *Global) SensorTemperatures(maxAge time.Duration) map[string]int {
+	temperatures := make(map[string]int, len(g.TemperatureSensors))
+	for name := range g.TemperatureSensors {
+		value, _, err := g.readTemperature(name, maxAge)
+		if err != nil {
+			clog.Debugf("sensor %s: %s", name, err)
+			continue
+		}
+		temperatures[name] = value
+	}
+	return temperatures
+}
@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"text/template"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobalFromFixtureTree(t *testing.T) {
+	config := cfg.Config{
+		DiskPowerStatus: map[string]cfg.DiskPowerStatus{
+			"sysfs": {
+				File:     "/sys/block/${DEVICE_NAME}/device/state",
+				Active:   "running",
+				Standby:  "offline",
+				Sleeping: "sleeping",
+			},
+		},
+		Sensors: map[string]cfg.Task{
+			"drivetemp": {File: "/sys/block/${DEVICE_NAME}/device/hwmon/hwmon*/temp1_input", Divider: 1000},
+			"cpu":       {Sensor: "coretemp/Package id 0", Divider: 1000},
+		},
+		DiskPools: map[string]cfg.DiskPool{
+			"pool": {Disks: []string{"first", "second"}},
+		},
+		Disks: map[string]cfg.Disk{
+			"first":   {Device: "/dev/disk/by-id/ata-ST2000DM001-first", TemperatureSensor: "drivetemp", MonitorTemperature: "always"},
+			"second":  {Device: "/dev/disk/by-id/ata-ST2000DM001-second", TemperatureSensor: "drivetemp", MonitorTemperature: "when_active"},
+			"missing": {Device: "/dev/disk/by-id/ata-ST2000DM001-third"},
+		},
+	}
+	global, err := NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+
+	require.Len(t, global.Disks(), 2)
+	assert.Equal(t, "/dev/sda", global.Disks()["first"].Device())
+	assert.Equal(t, "/dev/sdb", global.Disks()["second"].Device())
+
+	assert.True(t, global.Disks()["first"].IsActive())
+	assert.False(t, global.Disks()["second"].IsActive())
+	assert.Equal(t, 1, global.DiskPools["pool"].CountActive())
+
+	assert.Equal(t, 36, global.Disks()["first"].Temperature())
+	assert.Equal(t, 0, global.Disks()["second"].Temperature())
+
+	temperature, err := global.GetSensorReader("cpu")()
+	require.NoError(t, err)
+	assert.Equal(t, 45, temperature)
+
+	diskstats, err := global.GetDiskstats()
+	require.NoError(t, err)
+	assert.Len(t, diskstats.data, 6)
+}
+
+func TestGlobalCloseStopsLoops(t *testing.T) {
+	config := cfg.Config{
+		DiskPowerStatus: map[string]cfg.DiskPowerStatus{
+			"sysfs": {File: "/sys/block/${DEVICE_NAME}/device/state", Active: "running", Standby: "offline"},
+		},
+		Disks: map[string]cfg.Disk{
+			"first": {Device: "/dev/disk/by-id/ata-ST2000DM001-first", StandbyAfter: "1h"},
+		},
+	}
+	global, err := NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	// nothing runs until started
+	assert.Empty(t, global.Health.Report(time.Now()))
+
+	global.Start()
+	assert.Eventually(t, func() bool {
+		_, ok := global.Health.Report(time.Now())["standby first"]
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	global.Close()
+	assert.Eventually(t, func() bool {
+		_, ok := global.Health.Report(time.Now())["standby first"]
+		return !ok
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestDisksInTemplate(t *testing.T) {
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	templ, err := template.New("disks").Parse(`{{ (index .Disks "first").Device }}`)
+	require.NoError(t, err)
+	output := &strings.Builder{}
+	require.NoError(t, templ.Execute(output, global))
+	assert.Equal(t, "/dev/sda", output.String())
+}
+
+func TestSummary(t *testing.T) {
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	assert.Equal(t, "1/1 disks active", global.Summary())
+
+	var err error
+	global.TemperatureSensors = map[string]SensorGetter{"cpu": fixedSensor(55), "ambient": fixedSensor(25)}
+	global.sensorReadings = make(map[string]sensorValue)
+	rules := []cfg.SensorRule{{Temperature: cfg.FromTo{From: 20, To: 60}, Fan: cfg.SetFromTo{Set: 40}}}
+	global.FanControl, err = NewControl(global.GetSensorReader, cfg.FanControl{
+		SetCommand: "true",
+		Zones: map[string]cfg.FanZone{
+			"system": {ID: 0, RunEvery: "10s", Sensors: map[string]cfg.Sensor{"ambient": {Average: "10s", Rules: rules}}},
+			"cpu":    {ID: 1, RunEvery: "10s", Sensors: map[string]cfg.Sensor{"cpu": {Average: "10s", Rules: rules}}},
+		},
+	}, false)
+	require.NoError(t, err)
+	assert.Equal(t, "fans cpu 0%, system 0%; 1/1 disks active", global.Summary())
+
+	for _, zone := range global.FanControl.Zones {
+		for _, sensor := range zone.Sensors {
+			require.NoError(t, sensor.run())
+		}
+	}
+	assert.Equal(t, "fans cpu 40%, system 40%; hottest cpu 55°C; 1/1 disks active", global.Summary())
+}
+
+func TestDiskIORatesFromSamplerOnly(t *testing.T) {
+	files := fstest.MapFS{
+		"proc/diskstats": {Data: []byte(`   8       0 sda 1000 0 20000 5000 2000 0 40000 15000 0 10000 20000 10 0 800 5 3 7`)},
+	}
+	global := &Global{fs: files, diskstatsEvery: time.Hour}
+	_, err := global.GetDiskIORates("sda")
+	assert.Error(t, err)
+
+	global.sampleDiskstats()
+	files["proc/diskstats"] = &fstest.MapFile{Data: []byte(`   8       0 sda 1100 0 22000 5500 2400 0 48000 17500 1 15000 23000 12 0 1000 6 5 9`)}
+	global.sampleDiskstats()
+	global.previousDiskstats.timestamp = global.sampledDiskstats.timestamp.Add(-10 * time.Second)
+	rates, err := global.GetDiskIORates("sda")
+	require.NoError(t, err)
+	assert.InDelta(t, 10.0, rates.ReadIOPS, 0.001)
+
+	// reading the file outside of the sampler doesn't change the rates
+	files["proc/diskstats"] = &fstest.MapFile{Data: []byte(`   8       0 sda 1101 0 22008 5501 2400 0 48000 17500 1 15001 23001 12 0 1000 6 5 9`)}
+	global.diskstatsEvery = time.Nanosecond
+	stats, err := global.GetDiskstats()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1101), stats.Counters("sda").Reads)
+	after, err := global.GetDiskIORates("sda")
+	require.NoError(t, err)
+	assert.Equal(t, rates, after)
+}
@@ -0,0 +1,175 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric"
+)
+
+const (
+	GraphiteProtocolTCP = "tcp"
+	GraphiteProtocolUDP = "udp"
+
+	defaultGraphitePrefix = "hardware-events"
+	// keep the datagrams under the usual MTU
+	maxGraphiteDatagram = 1400
+	// graphiteEmptyValue replaces an empty attribute value in a metric path
+	graphiteEmptyValue = "none"
+)
+
+// graphitePathOrder is the order of the attributes in a metric path (when not tagged), the other attributes come next by name
+var graphitePathOrder = []attribute.Key{"zpool", "zone", "name", "sensor", "id", "device", "pool", "initiated", "result", "exit_status"}
+
+// graphite sends the metrics using the plaintext protocol
+type graphite struct {
+	address  string
+	protocol string
+	prefix   string
+	tagged   bool
+	timeout  time.Duration
+}
+
+// NewGraphiteReader creates a reader pushing the metrics to graphite at regular intervals
+func NewGraphiteReader(config cfg.Graphite) (metric.Reader, error) {
+	sink, err := newGraphite(config)
+	if err != nil {
+		return nil, err
+	}
+	return newPushReader("graphite", config.Interval, config.Timeout, sink.write)
+}
+
+func newGraphite(config cfg.Graphite) (*graphite, error) {
+	if config.Address == "" {
+		return nil, errors.New("missing graphite address")
+	}
+	protocol := config.Protocol
+	if protocol == "" {
+		protocol = GraphiteProtocolTCP
+	}
+	if protocol != GraphiteProtocolTCP && protocol != GraphiteProtocolUDP {
+		return nil, fmt.Errorf("unknown graphite protocol %q: expected %q or %q", config.Protocol, GraphiteProtocolTCP, GraphiteProtocolUDP)
+	}
+	timeout, err := parseDurationOrDefault(config.Timeout, defaultPushTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid graphite timeout: %w", err)
+	}
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = defaultGraphitePrefix
+	}
+	return &graphite{
+		address:  config.Address,
+		protocol: protocol,
+		prefix:   strings.TrimSuffix(prefix, "."),
+		tagged:   config.Tagged,
+		timeout:  timeout,
+	}, nil
+}
+
+func (g *graphite) write(ctx context.Context, points []metricPoint) error {
+	dialer := net.Dialer{Timeout: g.timeout}
+	conn, err := dialer.DialContext(ctx, g.protocol, g.address)
+	if err != nil {
+		return fmt.Errorf("cannot connect to graphite: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetWriteDeadline(time.Now().Add(g.timeout))
+
+	buffer := &bytes.Buffer{}
+	for _, point := range points {
+		line := g.line(point)
+		if g.protocol == GraphiteProtocolUDP && buffer.Len() > 0 && buffer.Len()+len(line) > maxGraphiteDatagram {
+			// one datagram per batch of lines
+			_, err = conn.Write(buffer.Bytes())
+			if err != nil {
+				return fmt.Errorf("cannot send metrics to graphite: %w", err)
+			}
+			buffer.Reset()
+		}
+		buffer.WriteString(line)
+	}
+	_, err = conn.Write(buffer.Bytes())
+	if err != nil {
+		return fmt.Errorf("cannot send metrics to graphite: %w", err)
+	}
+	return nil
+}
+
+// line returns the point as "path value timestamp", where the path is either
+// prefix.name.value1.value2 or prefix.name;key1=value1;key2=value2 when tagged
+func (g *graphite) line(point metricPoint) string {
+	builder := &strings.Builder{}
+	builder.WriteString(g.prefix)
+	builder.WriteByte('.')
+	builder.WriteString(graphiteName(point.name))
+	if g.tagged {
+		iterator := point.attributes.Iter()
+		for iterator.Next() {
+			attr := iterator.Attribute()
+			value := attr.Value.Emit()
+			if value == "" {
+				// graphite doesn't accept empty tags
+				continue
+			}
+			builder.WriteByte(';')
+			builder.WriteString(graphiteName(string(attr.Key)))
+			builder.WriteByte('=')
+			builder.WriteString(graphiteName(value))
+		}
+	} else {
+		for _, attr := range graphitePath(point.attributes) {
+			value := attr.Value.Emit()
+			if value == "" {
+				value = graphiteEmptyValue
+			}
+			builder.WriteByte('.')
+			builder.WriteString(graphiteName(value))
+		}
+	}
+	builder.WriteByte(' ')
+	builder.WriteString(strconv.FormatFloat(point.value, 'f', -1, 64))
+	builder.WriteByte(' ')
+	builder.WriteString(strconv.FormatInt(point.time.Unix(), 10))
+	builder.WriteByte('\n')
+	return builder.String()
+}
+
+// graphitePath returns the attributes in the order of the segments of a metric path:
+// the same attributes always give the same segments, even when a value is empty
+func graphitePath(attributes attribute.Set) []attribute.KeyValue {
+	path := make([]attribute.KeyValue, 0, attributes.Len())
+	for _, key := range graphitePathOrder {
+		if value, ok := attributes.Value(key); ok {
+			path = append(path, attribute.KeyValue{Key: key, Value: value})
+		}
+	}
+	// the set is sorted by key
+	iterator := attributes.Iter()
+	for iterator.Next() {
+		attr := iterator.Attribute()
+		if !slices.Contains(graphitePathOrder, attr.Key) {
+			path = append(path, attr)
+		}
+	}
+	return path
+}
+
+// graphiteName replaces the characters with a special meaning in a metric path
+func graphiteName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
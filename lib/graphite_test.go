@@ -0,0 +1,135 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var graphiteTestPoints = []metricPoint{
+	{
+		name:       "disk_temperature",
+		attributes: attribute.NewSet(attribute.String("name", "data.1"), attribute.String("pool", "")),
+		value:      38,
+		integer:    true,
+		time:       time.Unix(1700000000, 0),
+	},
+	{
+		name:       "command_duration_sum",
+		attributes: attribute.NewSet(attribute.String("name", "zpool_list"), attribute.Int("exit_status", 0)),
+		value:      0.25,
+		time:       time.Unix(1700000000, 0),
+	},
+}
+
+func TestGraphiteLines(t *testing.T) {
+	sink, err := newGraphite(cfg.Graphite{Address: "localhost:2003", Prefix: "nas."})
+	require.NoError(t, err)
+	assert.Equal(t, "nas.disk_temperature.data_1.none 38 1700000000\n", sink.line(graphiteTestPoints[0]))
+	assert.Equal(t, "nas.command_duration_sum.zpool_list.0 0.25 1700000000\n", sink.line(graphiteTestPoints[1]))
+
+	sink.tagged = true
+	assert.Equal(t, "nas.disk_temperature;name=data_1 38 1700000000\n", sink.line(graphiteTestPoints[0]))
+	assert.Equal(t, "nas.command_duration_sum;exit_status=0;name=zpool_list 0.25 1700000000\n", sink.line(graphiteTestPoints[1]))
+}
+
+func TestGraphitePathOrder(t *testing.T) {
+	sink, err := newGraphite(cfg.Graphite{Address: "localhost:2003"})
+	require.NoError(t, err)
+	point := metricPoint{
+		name: "disk_standby_actions",
+		attributes: attribute.NewSet(
+			attribute.String("result", "ok"),
+			attribute.String("pool", "data"),
+			attribute.String("name", "data1"),
+			attribute.String("device", "sda"),
+			attribute.String("extra", "value"),
+		),
+		value: 2,
+		time:  time.Unix(1700000000, 0),
+	}
+	assert.Equal(t, "hardware-events.disk_standby_actions.data1.sda.data.ok.value 2 1700000000\n", sink.line(point))
+}
+
+func TestGraphiteOverTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	sink, err := newGraphite(cfg.Graphite{Address: listener.Addr().String()})
+	require.NoError(t, err)
+	require.NoError(t, sink.write(context.Background(), graphiteTestPoints))
+
+	received := make([]string, 0, 2)
+	for line := range lines {
+		received = append(received, line)
+	}
+	assert.Equal(t, []string{
+		"hardware-events.disk_temperature.data_1.none 38 1700000000",
+		"hardware-events.command_duration_sum.zpool_list.0 0.25 1700000000",
+	}, received)
+}
+
+func TestGraphiteOverUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	sink, err := newGraphite(cfg.Graphite{Address: conn.LocalAddr().String(), Protocol: GraphiteProtocolUDP})
+	require.NoError(t, err)
+	// enough lines to need more than one datagram
+	points := make([]metricPoint, 0, 100)
+	for range 50 {
+		points = append(points, graphiteTestPoints...)
+	}
+	require.NoError(t, sink.write(context.Background(), points))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buffer := make([]byte, 65536)
+	count := 0
+	datagrams := 0
+	for count < len(points) {
+		n, _, err := conn.ReadFrom(buffer)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, n, maxGraphiteDatagram)
+		assert.True(t, strings.HasSuffix(string(buffer[:n]), "\n"))
+		count += strings.Count(string(buffer[:n]), "\n")
+		datagrams++
+	}
+	assert.Equal(t, len(points), count)
+	assert.Greater(t, datagrams, 1)
+}
+
+func TestInvalidGraphiteConfiguration(t *testing.T) {
+	for _, config := range []cfg.Graphite{
+		{},
+		{Address: "localhost:2003", Protocol: "http"},
+		{Address: "localhost:2003", Timeout: "soon"},
+		{Address: "localhost:2003", Interval: "often"},
+	} {
+		_, err := NewGraphiteReader(config)
+		assert.Error(t, err, config)
+	}
+}
@@ -0,0 +1,159 @@
+package lib
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Health keeps track of the heartbeats of the long-running loops
+type Health struct {
+	mutex      sync.Mutex
+	components map[string]*healthComponent
+}
+
+type healthComponent struct {
+	critical bool
+	started  bool
+	stopped  bool
+	lastBeat time.Time
+	deadline time.Time // zero when the loop is alive until stopped
+}
+
+// ComponentHealth is the state of a loop, suitable for JSON encoding
+type ComponentHealth struct {
+	Critical bool      `json:"critical"`
+	LastBeat time.Time `json:"last_beat"`
+	Stale    string    `json:"stale,omitempty"` // why the loop is not considered alive
+}
+
+// Heartbeat is used by a loop to report it's alive. A nil heartbeat does nothing
+type Heartbeat struct {
+	health *Health
+	name   string
+}
+
+func NewHealth() *Health {
+	return &Health{
+		components: make(map[string]*healthComponent),
+	}
+}
+
+// Register adds a loop to watch, starting from its first beat.
+// The daemon is unhealthy as soon as a critical loop misses its heartbeat
+func (h *Health) Register(name string, critical bool) *Heartbeat {
+	if h == nil {
+		return nil
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.components[name] = &healthComponent{critical: critical}
+	return &Heartbeat{health: h, name: name}
+}
+
+// Beat tells the loop is alive, and expects the next beat within timeout (or until stopped when zero)
+func (b *Heartbeat) Beat(timeout time.Duration) {
+	b.update(func(component *healthComponent) {
+		component.started = true
+		component.stopped = false
+		component.lastBeat = time.Now()
+		component.deadline = time.Time{}
+		if timeout > 0 {
+			component.deadline = component.lastBeat.Add(timeout)
+		}
+	})
+}
+
+// Stop tells the loop has ended unexpectedly
+func (b *Heartbeat) Stop() {
+	b.update(func(component *healthComponent) {
+		component.started = true
+		component.stopped = true
+	})
+}
+
+// Done tells the loop has ended on purpose: it's not watched anymore
+func (b *Heartbeat) Done() {
+	if b == nil {
+		return
+	}
+	b.health.mutex.Lock()
+	defer b.health.mutex.Unlock()
+
+	delete(b.health.components, b.name)
+}
+
+func (b *Heartbeat) update(change func(*healthComponent)) {
+	if b == nil {
+		return
+	}
+	b.health.mutex.Lock()
+	defer b.health.mutex.Unlock()
+
+	if component, ok := b.health.components[b.name]; ok {
+		change(component)
+	}
+}
+
+// Check returns an error naming the critical loops which stopped or missed their heartbeat
+func (h *Health) Check(now time.Time) error {
+	if h == nil {
+		return nil
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	stale := make([]string, 0)
+	for name, component := range h.components {
+		if !component.critical {
+			continue
+		}
+		if reason := component.stale(now); reason != "" {
+			stale = append(stale, name+" "+reason)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	slices.Sort(stale)
+	return fmt.Errorf("%s", strings.Join(stale, ", "))
+}
+
+// Report returns the state of all the loops
+func (h *Health) Report(now time.Time) map[string]ComponentHealth {
+	if h == nil {
+		return nil
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	report := make(map[string]ComponentHealth, len(h.components))
+	for name, component := range h.components {
+		if !component.started {
+			continue
+		}
+		report[name] = ComponentHealth{
+			Critical: component.critical,
+			LastBeat: component.lastBeat,
+			Stale:    component.stale(now),
+		}
+	}
+	return report
+}
+
+// stale returns why the loop is not alive, or an empty string
+func (c *healthComponent) stale(now time.Time) string {
+	if !c.started {
+		return ""
+	}
+	if c.stopped {
+		return "stopped"
+	}
+	if !c.deadline.IsZero() && now.After(c.deadline) {
+		return fmt.Sprintf("without heartbeat for %s", now.Sub(c.lastBeat).Truncate(time.Second))
+	}
+	return ""
+}
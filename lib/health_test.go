@@ -0,0 +1,79 @@
+package lib
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthHeartbeats(t *testing.T) {
+	health := NewHealth()
+	sensor := health.Register("sensor zone1/cpu", true)
+	task := health.Register("task backup", false)
+	server := health.Register("metrics server", true)
+
+	// nothing is watched before the first beat
+	now := time.Now()
+	assert.NoError(t, health.Check(now))
+	assert.Empty(t, health.Report(now))
+
+	sensor.Beat(time.Minute)
+	task.Beat(time.Minute)
+	server.Beat(0)
+	assert.NoError(t, health.Check(now))
+	assert.Len(t, health.Report(now), 3)
+
+	later := time.Now().Add(2*time.Minute + time.Second)
+	err := health.Check(later)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sensor zone1/cpu without heartbeat for 2m")
+	// not a critical loop
+	assert.NotContains(t, err.Error(), "task backup")
+	assert.NotEmpty(t, health.Report(later)["task backup"].Stale)
+
+	sensor.Beat(time.Minute)
+	server.Stop()
+	assert.EqualError(t, health.Check(time.Now()), "metrics server stopped")
+
+	server.Done()
+	assert.NoError(t, health.Check(time.Now()))
+	assert.NotContains(t, health.Report(time.Now()), "metrics server")
+}
+
+func TestNilHealth(t *testing.T) {
+	var health *Health
+	heartbeat := health.Register("sensor", true)
+	assert.Nil(t, heartbeat)
+	heartbeat.Beat(time.Minute)
+	heartbeat.Stop()
+	heartbeat.Done()
+	assert.NoError(t, health.Check(time.Now()))
+	assert.Nil(t, health.Report(time.Now()))
+}
+
+func TestSensorLoopHeartbeat(t *testing.T) {
+	control, err := NewControl(func(string) func() (int, error) {
+		return func() (int, error) { return 0, errors.New("sensor unavailable") }
+	}, cfg.FanControl{
+		SetCommand: "true",
+		Zones: map[string]cfg.FanZone{
+			"zone1": {ID: 1, RunEvery: "1ms", Sensors: map[string]cfg.Sensor{
+				"cpu": {Average: "10ms"},
+			}},
+		},
+	}, false)
+	require.NoError(t, err)
+	control.health = NewHealth()
+	control.Start()
+
+	// the loop keeps retrying, but its heartbeat gets late
+	assert.Eventually(t, func() bool {
+		err := control.health.Check(time.Now())
+		return err != nil && strings.Contains(err.Error(), "sensor zone1/cpu") && strings.Contains(err.Error(), "without heartbeat")
+	}, time.Second, time.Millisecond)
+}
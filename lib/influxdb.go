@@ -0,0 +1,201 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+	"go.opentelemetry.io/otel/sdk/metric"
+)
+
+const (
+	defaultInfluxDBBatchSize  = 5000
+	defaultInfluxDBMaxRetries = 3
+	defaultInfluxDBRetryDelay = 1 * time.Second
+)
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(`,`, `\,`, ` `, `\ `)
+	influxTagEscaper         = strings.NewReplacer(`,`, `\,`, ` `, `\ `, `=`, `\=`)
+)
+
+// influxDB sends the metrics to the InfluxDB v2 write API using the line protocol
+type influxDB struct {
+	client     *http.Client
+	writeURL   string
+	token      string
+	tags       map[string]string
+	batchSize  int
+	maxRetries int
+	retryDelay time.Duration
+}
+
+// NewInfluxDBReader creates a reader pushing the metrics to InfluxDB at regular intervals
+func NewInfluxDBReader(config cfg.InfluxDB) (metric.Reader, error) {
+	sink, err := newInfluxDB(config)
+	if err != nil {
+		return nil, err
+	}
+	return newPushReader("InfluxDB", config.Interval, config.Timeout, sink.write)
+}
+
+func newInfluxDB(config cfg.InfluxDB) (*influxDB, error) {
+	if config.URL == "" {
+		return nil, errors.New("missing InfluxDB url")
+	}
+	if config.Bucket == "" {
+		return nil, errors.New("missing InfluxDB bucket")
+	}
+	base, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid InfluxDB url: %w", err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("invalid InfluxDB url %q: expected http or https scheme", config.URL)
+	}
+	timeout, err := parseDurationOrDefault(config.Timeout, defaultPushTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid InfluxDB timeout: %w", err)
+	}
+	tlsConfig, err := newTLSClientConfig(config.TLS)
+	if err != nil {
+		return nil, err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	base = base.JoinPath("api", "v2", "write")
+	base.RawQuery = url.Values{
+		"org":       {config.Org},
+		"bucket":    {config.Bucket},
+		"precision": {"ns"},
+	}.Encode()
+
+	sink := &influxDB{
+		client:     &http.Client{Transport: transport, Timeout: timeout},
+		writeURL:   base.String(),
+		token:      config.Token,
+		tags:       config.Tags,
+		batchSize:  config.BatchSize,
+		maxRetries: config.MaxRetries,
+		retryDelay: defaultInfluxDBRetryDelay,
+	}
+	if sink.batchSize <= 0 {
+		sink.batchSize = defaultInfluxDBBatchSize
+	}
+	if sink.maxRetries <= 0 {
+		sink.maxRetries = defaultInfluxDBMaxRetries
+	}
+	return sink, nil
+}
+
+// write sends the points in batches of lines
+func (i *influxDB) write(ctx context.Context, points []metricPoint) error {
+	for start := 0; start < len(points); start += i.batchSize {
+		end := min(start+i.batchSize, len(points))
+		body := &bytes.Buffer{}
+		for _, point := range points[start:end] {
+			i.appendLine(body, point)
+		}
+		err := i.send(ctx, body.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// send posts the lines, retrying when the server is unavailable or busy
+func (i *influxDB) send(ctx context.Context, body []byte) error {
+	delay := i.retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := i.post(ctx, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= i.maxRetries {
+			return err
+		}
+		clog.Debugf("InfluxDB write failed, retrying in %s: %v", delay, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// post returns whether the request can be retried on error
+func (i *influxDB) post(ctx context.Context, body []byte) (bool, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, i.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if i.token != "" {
+		request.Header.Set("Authorization", "Token "+i.token)
+	}
+	response, err := i.client.Do(request)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		_, _ = io.Copy(io.Discard, response.Body)
+		return false, nil
+	}
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	err = fmt.Errorf("InfluxDB write returned %s: %s", response.Status, strings.TrimSpace(string(message)))
+	return response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500, err
+}
+
+// appendLine writes the point in line protocol: measurement,tag=value value=42i timestamp
+func (i *influxDB) appendLine(buffer *bytes.Buffer, point metricPoint) {
+	tags := make(map[string]string, len(i.tags)+point.attributes.Len())
+	for key, value := range i.tags {
+		tags[key] = value
+	}
+	iterator := point.attributes.Iter()
+	for iterator.Next() {
+		attr := iterator.Attribute()
+		tags[string(attr.Key)] = attr.Value.Emit()
+	}
+	keys := make([]string, 0, len(tags))
+	for key, value := range tags {
+		// empty tag values are not allowed
+		if value != "" {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	buffer.WriteString(influxMeasurementEscaper.Replace(point.name))
+	for _, key := range keys {
+		buffer.WriteByte(',')
+		buffer.WriteString(influxTagEscaper.Replace(key))
+		buffer.WriteByte('=')
+		buffer.WriteString(influxTagEscaper.Replace(tags[key]))
+	}
+	buffer.WriteString(" value=")
+	if point.integer {
+		buffer.WriteString(strconv.FormatInt(int64(point.value), 10))
+		buffer.WriteByte('i')
+	} else {
+		buffer.WriteString(strconv.FormatFloat(point.value, 'f', -1, 64))
+	}
+	buffer.WriteByte(' ')
+	buffer.WriteString(strconv.FormatInt(point.time.UnixNano(), 10))
+	buffer.WriteByte('\n')
+}
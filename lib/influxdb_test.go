@@ -0,0 +1,152 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// influxDBReceiver is a stand-in for the InfluxDB write API, failing the first requests
+type influxDBReceiver struct {
+	mutex    sync.Mutex
+	failures int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (r *influxDBReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.requests = append(r.requests, req)
+	if r.failures > 0 {
+		r.failures--
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+		return
+	}
+	r.bodies = append(r.bodies, string(body))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *influxDBReceiver) received() ([]*http.Request, []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.requests, r.bodies
+}
+
+func TestInfluxDBLineProtocol(t *testing.T) {
+	sink := &influxDB{tags: map[string]string{"host": "server lan"}}
+	timestamp := time.Unix(1700000000, 5)
+	buffer := &bytes.Buffer{}
+	sink.appendLine(buffer, metricPoint{
+		name:       "disk_temperature",
+		attributes: attribute.NewSet(attribute.String("name", "data,1"), attribute.String("pool", "")),
+		value:      38,
+		integer:    true,
+		time:       timestamp,
+	})
+	sink.appendLine(buffer, metricPoint{
+		name:       "command_duration_sum",
+		attributes: attribute.NewSet(attribute.String("name", "a=b")),
+		value:      0.25,
+		time:       timestamp,
+	})
+	assert.Equal(t,
+		"disk_temperature,host=server\\ lan,name=data\\,1 value=38i 1700000000000000005\n"+
+			"command_duration_sum,host=server\\ lan,name=a\\=b value=0.25 1700000000000000005\n",
+		buffer.String())
+}
+
+func TestInfluxDBWriteWithRetry(t *testing.T) {
+	receiver := &influxDBReceiver{failures: 1}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	sink, err := newInfluxDB(cfg.InfluxDB{
+		URL:       server.URL,
+		Org:       "home",
+		Bucket:    "hardware",
+		Token:     "secret",
+		BatchSize: 2,
+	})
+	require.NoError(t, err)
+	sink.retryDelay = time.Millisecond
+
+	points := []metricPoint{
+		{name: "first", value: 1, time: time.Now()},
+		{name: "second", value: 2, time: time.Now()},
+		{name: "third", value: 3, time: time.Now()},
+	}
+	require.NoError(t, sink.write(context.Background(), points))
+
+	requests, bodies := receiver.received()
+	require.Len(t, requests, 3)
+	assert.Equal(t, "/api/v2/write", requests[0].URL.Path)
+	assert.Equal(t, "home", requests[0].URL.Query().Get("org"))
+	assert.Equal(t, "hardware", requests[0].URL.Query().Get("bucket"))
+	assert.Equal(t, "ns", requests[0].URL.Query().Get("precision"))
+	assert.Equal(t, "Token secret", requests[0].Header.Get("Authorization"))
+	// the first batch was sent twice
+	require.Len(t, bodies, 2)
+	assert.Equal(t, 2, strings.Count(bodies[0], "\n"))
+	assert.True(t, strings.HasPrefix(bodies[1], "third "))
+}
+
+func TestInfluxDBWriteGivesUp(t *testing.T) {
+	receiver := &influxDBReceiver{failures: 10}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	sink, err := newInfluxDB(cfg.InfluxDB{URL: server.URL, Bucket: "hardware", MaxRetries: 2})
+	require.NoError(t, err)
+	sink.retryDelay = time.Millisecond
+
+	err = sink.write(context.Background(), []metricPoint{{name: "first", value: 1, time: time.Now()}})
+	assert.ErrorContains(t, err, "503")
+	requests, _ := receiver.received()
+	assert.Len(t, requests, 3)
+}
+
+func TestInfluxDBReader(t *testing.T) {
+	receiver := &influxDBReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	reader, err := NewInfluxDBReader(cfg.InfluxDB{URL: server.URL, Bucket: "hardware"})
+	require.NoError(t, err)
+	telemetry, err := NewTelemetry(newTestGlobal(t, map[string]string{"first": "/dev/sda"}), reader)
+	require.NoError(t, err)
+	// the last collection is pushed on shutdown
+	require.NoError(t, telemetry.Shutdown(context.Background()))
+
+	_, bodies := receiver.received()
+	require.Len(t, bodies, 1)
+	assert.Contains(t, bodies[0], "disk_active,device=/dev/sda,name=first value=1i ")
+}
+
+func TestInvalidInfluxDBConfiguration(t *testing.T) {
+	for _, config := range []cfg.InfluxDB{
+		{Bucket: "hardware"},
+		{URL: "http://localhost:8086"},
+		{URL: "localhost:8086", Bucket: "hardware"},
+		{URL: "http://localhost:8086", Bucket: "hardware", Timeout: "soon"},
+		{URL: "http://localhost:8086", Bucket: "hardware", Interval: "often"},
+		{URL: "https://localhost:8086", Bucket: "hardware", TLS: cfg.TLS{CAFile: "does/not/exist.pem"}},
+	} {
+		_, err := NewInfluxDBReader(config)
+		assert.Error(t, err, config)
+	}
+}
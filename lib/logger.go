@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/creativeprojects/clog"
+)
+
+// LogField identifies the component a log entry is about, like zone=zone1 or disk=datapool1
+type LogField struct {
+	Key   string
+	Value string
+}
+
+// LogMessage is sent to the clog handlers instead of a plain message.
+// The handlers unaware of the fields see the values of the fields in front of the message
+type LogMessage struct {
+	Fields []LogField
+	format string
+	args   []any
+}
+
+// Message returns the formatted message, without the fields
+func (m *LogMessage) Message() string {
+	return fmt.Sprintf(m.format, m.args...)
+}
+
+// String returns the formatted message, prefixed by the values of the fields like "zone1: cpu: message"
+func (m *LogMessage) String() string {
+	builder := &strings.Builder{}
+	for _, field := range m.Fields {
+		builder.WriteString(field.Value)
+		builder.WriteString(": ")
+	}
+	builder.WriteString(m.Message())
+	return builder.String()
+}
+
+// LogFields returns the fields attached to the log entry, if any
+func LogFields(entry clog.LogEntry) []LogField {
+	if message := logMessage(entry); message != nil {
+		return message.Fields
+	}
+	return nil
+}
+
+// LogText returns the message of the log entry, without the fields
+func LogText(entry clog.LogEntry) string {
+	if message := logMessage(entry); message != nil {
+		return message.Message()
+	}
+	return entry.GetMessage()
+}
+
+func logMessage(entry clog.LogEntry) *LogMessage {
+	if entry.Format != "" || len(entry.Values) != 1 {
+		return nil
+	}
+	message, _ := entry.Values[0].(*LogMessage)
+	return message
+}
+
+// Logger sends log entries to the default clog logger, with fields describing the component.
+// The zero value logs without any field
+type Logger struct {
+	fields []LogField
+}
+
+// NewLogger returns a logger with a first field
+func NewLogger(key, value string) Logger {
+	return Logger{fields: []LogField{{Key: key, Value: value}}}
+}
+
+// With returns a new logger with an additional field
+func (l Logger) With(key, value string) Logger {
+	fields := make([]LogField, len(l.fields), len(l.fields)+1)
+	copy(fields, l.fields)
+	return Logger{fields: append(fields, LogField{Key: key, Value: value})}
+}
+
+func (l Logger) Tracef(format string, args ...any) {
+	l.logf(clog.LevelTrace, format, args)
+}
+
+func (l Logger) Debugf(format string, args ...any) {
+	l.logf(clog.LevelDebug, format, args)
+}
+
+func (l Logger) Infof(format string, args ...any) {
+	l.logf(clog.LevelInfo, format, args)
+}
+
+func (l Logger) Warningf(format string, args ...any) {
+	l.logf(clog.LevelWarning, format, args)
+}
+
+func (l Logger) Errorf(format string, args ...any) {
+	l.logf(clog.LevelError, format, args)
+}
+
+func (l Logger) logf(level clog.LogLevel, format string, args []any) {
+	_ = clog.GetDefaultLogger().LogEntry(clog.LogEntry{
+		Calldepth: 1, // incremented by the logger
+		Level:     level,
+		Values:    []any{&LogMessage{Fields: l.fields, format: format, args: args}},
+	})
+}
@@ -0,0 +1,356 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	defaultMQTTTopicPrefix     = "hardware-events"
+	defaultMQTTDiscoveryPrefix = "homeassistant"
+	defaultMQTTPublishEvery    = 1 * time.Minute
+	mqttTimeout                = 10 * time.Second
+
+	mqttOnline        = "online"
+	mqttOffline       = "offline"
+	mqttAutomatic     = "auto"
+	mqttQoSAtLeastOne = 1
+)
+
+var mqttInvalidCharacters = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// MQTT publishes the sensors, disks and fan zones to a broker, with the Home Assistant discovery configuration
+type MQTT struct {
+	global          *Global
+	config          cfg.MQTT
+	client          mqtt.Client
+	nodeID          string
+	prefix          string
+	discoveryPrefix string
+	publishEvery    time.Duration
+	announced       map[string]bool // disks already announced to Home Assistant
+	announcedZones  bool            // sensors and zones already announced
+	mutex           sync.Mutex
+	done            chan struct{}
+}
+
+// haEntity is a Home Assistant MQTT discovery configuration
+type haEntity struct {
+	Name              string   `json:"name"`
+	UniqueID          string   `json:"unique_id"`
+	ObjectID          string   `json:"object_id,omitempty"`
+	StateTopic        string   `json:"state_topic,omitempty"`
+	CommandTopic      string   `json:"command_topic,omitempty"`
+	AvailabilityTopic string   `json:"availability_topic"`
+	DeviceClass       string   `json:"device_class,omitempty"`
+	StateClass        string   `json:"state_class,omitempty"`
+	EntityCategory    string   `json:"entity_category,omitempty"`
+	Unit              string   `json:"unit_of_measurement,omitempty"`
+	Min               *int     `json:"min,omitempty"`
+	Max               *int     `json:"max,omitempty"`
+	Mode              string   `json:"mode,omitempty"`
+	PayloadOn         string   `json:"payload_on,omitempty"`
+	PayloadOff        string   `json:"payload_off,omitempty"`
+	PayloadPress      string   `json:"payload_press,omitempty"`
+	Device            haDevice `json:"device"`
+}
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+	Model        string   `json:"model"`
+}
+
+// NewMQTT creates the MQTT publisher from the configuration. The connection is only made by Start.
+func NewMQTT(global *Global, config cfg.MQTT) (*MQTT, error) {
+	var err error
+
+	if config.Broker == "" {
+		return nil, errors.New("missing MQTT broker address")
+	}
+	publishEvery := defaultMQTTPublishEvery
+	if config.PublishEvery != "" {
+		publishEvery, err = time.ParseDuration(config.PublishEvery)
+		if err != nil {
+			return nil, fmt.Errorf("invalid MQTT publish interval: %w", err)
+		}
+		if publishEvery <= 0 {
+			return nil, fmt.Errorf("invalid MQTT publish interval: %s", publishEvery)
+		}
+	}
+	prefix := defaultMQTTTopicPrefix
+	if config.TopicPrefix != "" {
+		prefix = strings.TrimSuffix(config.TopicPrefix, "/")
+	}
+	discoveryPrefix := defaultMQTTDiscoveryPrefix
+	if config.DiscoveryPrefix != "" {
+		discoveryPrefix = strings.TrimSuffix(config.DiscoveryPrefix, "/")
+	}
+	clientID := config.ClientID
+	if clientID == "" {
+		hostname, _ := os.Hostname()
+		clientID = "hardware-events-" + hostname
+	}
+
+	m := &MQTT{
+		global:          global,
+		config:          config,
+		nodeID:          mqttID(clientID),
+		prefix:          prefix,
+		discoveryPrefix: discoveryPrefix,
+		publishEvery:    publishEvery,
+		announced:       make(map[string]bool),
+		done:            make(chan struct{}),
+	}
+
+	options := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(clientID).
+		SetUsername(config.Username).
+		SetPassword(config.Password).
+		SetConnectTimeout(mqttTimeout).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetWill(m.availabilityTopic(), mqttOffline, mqttQoSAtLeastOne, true).
+		SetOrderMatters(false). // the handlers are publishing
+		SetOnConnectHandler(m.onConnect)
+	if strings.HasPrefix(config.Broker, "ssl://") || strings.HasPrefix(config.Broker, "tls://") || strings.HasPrefix(config.Broker, "mqtts://") {
+		tlsConfig, err := newTLSClientConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+		options.SetTLSConfig(tlsConfig)
+	}
+	m.client = mqtt.NewClient(options)
+	return m, nil
+}
+
+// Start connects to the broker (retrying in the background if it's not available) and publishes the states at regular intervals
+func (m *MQTT) Start() {
+	clog.Debugf("mqtt: connecting to %s", m.config.Broker)
+	m.client.Connect()
+	go func() {
+		ticker := time.NewTicker(m.publishEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-ticker.C:
+				if m.client.IsConnectionOpen() {
+					m.publishStates()
+				}
+			}
+		}
+	}()
+}
+
+// Close publishes the offline status and disconnects from the broker
+func (m *MQTT) Close() {
+	close(m.done)
+	if m.client.IsConnectionOpen() {
+		m.publish(m.availabilityTopic(), mqttOffline, true)
+	}
+	m.client.Disconnect(uint(mqttTimeout / time.Millisecond))
+}
+
+// onConnect runs on the first connection and after each reconnection
+func (m *MQTT) onConnect(client mqtt.Client) {
+	clog.Infof("mqtt: connected to %s", m.config.Broker)
+	// the broker might have lost the retained messages
+	m.mutex.Lock()
+	clear(m.announced)
+	m.announcedZones = false
+	m.mutex.Unlock()
+
+	if m.config.Commands && m.global.FanControl != nil {
+		for _, zone := range m.global.FanControl.Zones {
+			topic := m.zoneTopic(zone, "set")
+			token := client.Subscribe(topic, mqttQoSAtLeastOne, func(_ mqtt.Client, message mqtt.Message) {
+				m.handleZoneCommand(zone, string(message.Payload()))
+			})
+			if token.WaitTimeout(mqttTimeout) && token.Error() != nil {
+				clog.Errorf("mqtt: cannot subscribe to %s: %s", topic, token.Error())
+			}
+		}
+	}
+	m.publish(m.availabilityTopic(), mqttOnline, true)
+	m.publishStates()
+}
+
+// handleZoneCommand sets or clears the manual fan speed of the zone: the payload is a speed, or "auto"
+func (m *MQTT) handleZoneCommand(zone *Zone, payload string) {
+	payload = strings.TrimSpace(payload)
+	if payload == "" || strings.EqualFold(payload, mqttAutomatic) {
+		zone.SetOverride(0)
+	} else {
+		speed, err := strconv.ParseFloat(payload, 64)
+		minSpeed, maxSpeed := zone.SpeedRange()
+		if err != nil || int(speed) < minSpeed || int(speed) > maxSpeed {
+			clog.Warningf("mqtt: invalid fan speed %q for %s", payload, zone.Name)
+			return
+		}
+		zone.SetOverride(int(speed))
+	}
+	m.publish(m.zoneTopic(zone, "speed"), strconv.Itoa(zone.CurrentFanSpeed()), false)
+}
+
+// publishStates publishes the current values, and announces the new disks to Home Assistant
+func (m *MQTT) publishStates() {
+	if m.config.HomeAssistant {
+		m.announce()
+	}
+	for name, value := range m.global.SensorTemperatures(m.publishEvery) {
+		m.publish(m.prefix+"/sensor/"+mqttID(name)+"/temperature", strconv.Itoa(value), false)
+	}
+	for _, disk := range m.global.Disks() {
+		if disk.TemperatureAvailable() {
+			m.publish(m.diskTopic(disk, "temperature"), strconv.Itoa(disk.Temperature()), false)
+		}
+		active := "OFF"
+		if disk.IsActive() {
+			active = "ON"
+		}
+		m.publish(m.diskTopic(disk, "active"), active, false)
+		m.publish(m.diskTopic(disk, "pool"), disk.Pool(), false)
+	}
+	if m.global.FanControl != nil {
+		for _, zone := range m.global.FanControl.Zones {
+			m.publish(m.zoneTopic(zone, "speed"), strconv.Itoa(zone.CurrentFanSpeed()), false)
+		}
+	}
+}
+
+// announce publishes the retained Home Assistant discovery configuration of the entities not announced yet
+func (m *MQTT) announce() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.announcedZones {
+		for name := range m.global.TemperatureSensors {
+			m.publishEntity("sensor", "sensor_"+name, haEntity{
+				Name:        name + " temperature",
+				StateTopic:  m.prefix + "/sensor/" + mqttID(name) + "/temperature",
+				DeviceClass: "temperature",
+				StateClass:  "measurement",
+				Unit:        "°C",
+			})
+		}
+		if m.global.FanControl != nil {
+			for _, zone := range m.global.FanControl.Zones {
+				m.announceZone(zone)
+			}
+		}
+		m.announcedZones = true
+	}
+	for name, disk := range m.global.Disks() {
+		if m.announced[name] {
+			continue
+		}
+		m.publishEntity("sensor", "disk_"+name+"_temperature", haEntity{
+			Name:        name + " temperature",
+			StateTopic:  m.diskTopic(disk, "temperature"),
+			DeviceClass: "temperature",
+			StateClass:  "measurement",
+			Unit:        "°C",
+		})
+		m.publishEntity("binary_sensor", "disk_"+name+"_active", haEntity{
+			Name:       name + " active",
+			StateTopic: m.diskTopic(disk, "active"),
+			PayloadOn:  "ON",
+			PayloadOff: "OFF",
+		})
+		m.publishEntity("sensor", "disk_"+name+"_pool", haEntity{
+			Name:           name + " pool",
+			StateTopic:     m.diskTopic(disk, "pool"),
+			EntityCategory: "diagnostic",
+		})
+		m.announced[name] = true
+	}
+}
+
+func (m *MQTT) announceZone(zone *Zone) {
+	m.publishEntity("sensor", "zone_"+zone.Name+"_speed", haEntity{
+		Name:       zone.Name + " fan speed",
+		StateTopic: m.zoneTopic(zone, "speed"),
+		StateClass: "measurement",
+		Unit:       "%",
+	})
+	if !m.config.Commands {
+		return
+	}
+	minSpeed, maxSpeed := zone.SpeedRange()
+	m.publishEntity("number", "zone_"+zone.Name+"_manual_speed", haEntity{
+		Name:         zone.Name + " manual fan speed",
+		StateTopic:   m.zoneTopic(zone, "speed"),
+		CommandTopic: m.zoneTopic(zone, "set"),
+		Min:          &minSpeed,
+		Max:          &maxSpeed,
+		Mode:         "slider",
+		Unit:         "%",
+	})
+	m.publishEntity("button", "zone_"+zone.Name+"_automatic", haEntity{
+		Name:         zone.Name + " automatic fan speed",
+		CommandTopic: m.zoneTopic(zone, "set"),
+		PayloadPress: mqttAutomatic,
+	})
+}
+
+func (m *MQTT) publishEntity(component, objectID string, entity haEntity) {
+	objectID = mqttID(objectID)
+	entity.UniqueID = m.nodeID + "_" + objectID
+	entity.ObjectID = entity.UniqueID
+	entity.AvailabilityTopic = m.availabilityTopic()
+	entity.Device = haDevice{
+		Identifiers:  []string{m.nodeID},
+		Name:         m.nodeID,
+		Manufacturer: "creativeprojects",
+		Model:        "hardware-events",
+	}
+	payload, err := json.Marshal(entity)
+	if err != nil {
+		clog.Errorf("mqtt: cannot encode discovery configuration: %s", err)
+		return
+	}
+	m.publish(m.discoveryPrefix+"/"+component+"/"+m.nodeID+"/"+objectID+"/config", string(payload), true)
+}
+
+func (m *MQTT) publish(topic, payload string, retained bool) {
+	token := m.client.Publish(topic, mqttQoSAtLeastOne, retained, payload)
+	if !token.WaitTimeout(mqttTimeout) {
+		clog.Warningf("mqtt: timeout publishing to %s", topic)
+		return
+	}
+	if err := token.Error(); err != nil {
+		clog.Errorf("mqtt: cannot publish to %s: %s", topic, err)
+	}
+}
+
+func (m *MQTT) availabilityTopic() string {
+	return m.prefix + "/status"
+}
+
+func (m *MQTT) diskTopic(disk *Disk, name string) string {
+	return m.prefix + "/disk/" + mqttID(disk.Name) + "/" + name
+}
+
+func (m *MQTT) zoneTopic(zone *Zone, name string) string {
+	return m.prefix + "/zone/" + mqttID(zone.Name) + "/" + name
+}
+
+// mqttID replaces the characters not allowed in the topics and the Home Assistant identifiers
+func mqttID(name string) string {
+	return mqttInvalidCharacters.ReplaceAllString(name, "_")
+}
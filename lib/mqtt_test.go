@@ -0,0 +1,151 @@
+package lib
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	mochi "github.com/mochi-mqtt/server/v2"
+	"github.com/mochi-mqtt/server/v2/hooks/auth"
+	"github.com/mochi-mqtt/server/v2/listeners"
+	"github.com/mochi-mqtt/server/v2/packets"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testBroker is an embedded MQTT broker keeping the last message published on each topic
+type testBroker struct {
+	*mochi.Server
+	address  string
+	mutex    sync.Mutex
+	messages map[string]string
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	t.Helper()
+	server := mochi.New(&mochi.Options{
+		InlineClient: true,
+		Logger:       slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	require.NoError(t, server.AddHook(new(auth.AllowHook), nil))
+	listener := listeners.NewTCP(listeners.Config{ID: "test", Address: "127.0.0.1:0"})
+	require.NoError(t, server.AddListener(listener))
+	broker := &testBroker{
+		Server:   server,
+		address:  "tcp://" + listener.Address(),
+		messages: make(map[string]string),
+	}
+	require.NoError(t, server.Subscribe("#", 1, func(_ *mochi.Client, _ packets.Subscription, packet packets.Packet) {
+		broker.mutex.Lock()
+		defer broker.mutex.Unlock()
+		broker.messages[packet.TopicName] = string(packet.Payload)
+	}))
+	go func() {
+		_ = server.Serve()
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	return broker
+}
+
+func (b *testBroker) message(topic string) (string, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	message, ok := b.messages[topic]
+	return message, ok
+}
+
+func TestMQTTWithHomeAssistant(t *testing.T) {
+	broker := newTestBroker(t)
+
+	global := newTestGlobal(t, map[string]string{"data1": "/dev/sda"})
+	global.TemperatureSensors = map[string]SensorGetter{"cpu": fixedSensor(42), "nvme": fixedSensor(35)}
+	global.sensorReadings = map[string]sensorValue{"cpu": {value: 42, readAt: time.Now()}}
+	global.disks["data1"].setPool("data", false)
+	var err error
+	global.FanControl, err = NewControl(global.GetSensorReader, cfg.FanControl{
+		SetCommand: "true",
+		Zones: map[string]cfg.FanZone{
+			"zone1": {ID: 1, MinSpeed: 20, MaxSpeed: 100, RunEvery: "10s", Sensors: map[string]cfg.Sensor{
+				"cpu": {Average: "10s", Rules: []cfg.SensorRule{{Temperature: cfg.FromTo{From: 20, To: 60}, Fan: cfg.SetFromTo{Set: 40}}}},
+			}},
+		},
+	}, false)
+	require.NoError(t, err)
+	zone := global.FanControl.Zones["zone1"]
+	require.NoError(t, zone.Sensors["cpu"].run())
+
+	client, err := NewMQTT(global, cfg.MQTT{
+		Broker:        broker.address,
+		ClientID:      "server.lan",
+		HomeAssistant: true,
+		Commands:      true,
+		PublishEvery:  "1h",
+	})
+	require.NoError(t, err)
+	client.Start()
+
+	published := func(topic, expected string) func() bool {
+		return func() bool {
+			message, ok := broker.message(topic)
+			return ok && message == expected
+		}
+	}
+	assert.Eventually(t, published("hardware-events/status", "online"), 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, published("hardware-events/zone/zone1/speed", "40"), 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, published("hardware-events/sensor/cpu/temperature", "42"), 5*time.Second, 10*time.Millisecond)
+	// not used by the fan control
+	assert.Eventually(t, published("hardware-events/sensor/nvme/temperature", "35"), 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, published("hardware-events/disk/data1/active", "ON"), 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, published("hardware-events/disk/data1/pool", "data"), 5*time.Second, 10*time.Millisecond)
+
+	message, ok := broker.message("homeassistant/binary_sensor/server_lan/disk_data1_active/config")
+	require.True(t, ok)
+	entity := haEntity{}
+	require.NoError(t, json.Unmarshal([]byte(message), &entity))
+	assert.Equal(t, "hardware-events/disk/data1/active", entity.StateTopic)
+	assert.Equal(t, "hardware-events/status", entity.AvailabilityTopic)
+	assert.Equal(t, "server_lan_disk_data1_active", entity.UniqueID)
+	assert.Equal(t, []string{"server_lan"}, entity.Device.Identifiers)
+
+	message, ok = broker.message("homeassistant/number/server_lan/zone_zone1_manual_speed/config")
+	require.True(t, ok)
+	entity = haEntity{}
+	require.NoError(t, json.Unmarshal([]byte(message), &entity))
+	assert.Equal(t, "hardware-events/zone/zone1/set", entity.CommandTopic)
+	require.NotNil(t, entity.Min)
+	assert.Equal(t, 20, *entity.Min)
+
+	// manual override
+	require.NoError(t, broker.Publish("hardware-events/zone/zone1/set", []byte("75"), false, 1))
+	assert.Eventually(t, published("hardware-events/zone/zone1/speed", "75"), 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 75, zone.Override())
+	// the sensors are ignored
+	zone.RequestFanSpeed("cpu", 50, false, false)
+	assert.Equal(t, 75, zone.CurrentFanSpeed())
+
+	// out of range
+	require.NoError(t, broker.Publish("hardware-events/zone/zone1/set", []byte("10"), false, 1))
+	// back to automatic
+	require.NoError(t, broker.Publish("hardware-events/zone/zone1/set", []byte("auto"), false, 1))
+	assert.Eventually(t, published("hardware-events/zone/zone1/speed", "50"), 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, zone.Override())
+
+	client.Close()
+	assert.Eventually(t, published("hardware-events/status", "offline"), 5*time.Second, 10*time.Millisecond)
+}
+
+func TestInvalidMQTTConfiguration(t *testing.T) {
+	_, err := NewMQTT(&Global{}, cfg.MQTT{})
+	assert.Error(t, err)
+	_, err = NewMQTT(&Global{}, cfg.MQTT{Broker: "tcp://localhost:1883", PublishEvery: "never"})
+	assert.Error(t, err)
+	_, err = NewMQTT(&Global{}, cfg.MQTT{Broker: "ssl://localhost:8883", TLS: cfg.TLS{CAFile: "does/not/exist.pem"}})
+	assert.Error(t, err)
+}
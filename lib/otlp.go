@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http"
+
+	defaultOTLPInterval = 1 * time.Minute
+)
+
+// NewOTLPReader creates a reader pushing the metrics to an OpenTelemetry collector at regular intervals
+func NewOTLPReader(ctx context.Context, config cfg.OTLP) (metric.Reader, error) {
+	var err error
+	interval := defaultOTLPInterval
+	if config.Interval != "" {
+		interval, err = time.ParseDuration(config.Interval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTLP interval: %w", err)
+		}
+	}
+	var timeout time.Duration
+	if config.Timeout != "" {
+		timeout, err = time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid OTLP timeout: %w", err)
+		}
+	}
+	if config.Compression != "" && config.Compression != "gzip" && config.Compression != "none" {
+		return nil, fmt.Errorf("unknown OTLP compression %q: expected %q or %q", config.Compression, "gzip", "none")
+	}
+	var tlsConfig *tls.Config
+	if !config.Insecure {
+		tlsConfig, err = newTLSClientConfig(config.TLS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var exporter metric.Exporter
+	switch config.Protocol {
+	case "", OTLPProtocolGRPC:
+		exporter, err = newOTLPGRPCExporter(ctx, config, timeout, tlsConfig)
+	case OTLPProtocolHTTP:
+		exporter, err = newOTLPHTTPExporter(ctx, config, timeout, tlsConfig)
+	default:
+		return nil, fmt.Errorf("unknown OTLP protocol %q: expected %q or %q", config.Protocol, OTLPProtocolGRPC, OTLPProtocolHTTP)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	options := []metric.PeriodicReaderOption{metric.WithInterval(interval)}
+	if timeout > 0 {
+		options = append(options, metric.WithTimeout(timeout))
+	}
+	return metric.NewPeriodicReader(exporter, options...), nil
+}
+
+func newOTLPGRPCExporter(ctx context.Context, config cfg.OTLP, timeout time.Duration, tlsConfig *tls.Config) (metric.Exporter, error) {
+	options := make([]otlpmetricgrpc.Option, 0, 5)
+	if config.Endpoint != "" {
+		if strings.Contains(config.Endpoint, "://") {
+			options = append(options, otlpmetricgrpc.WithEndpointURL(config.Endpoint))
+		} else {
+			options = append(options, otlpmetricgrpc.WithEndpoint(config.Endpoint))
+		}
+	}
+	if len(config.Headers) > 0 {
+		options = append(options, otlpmetricgrpc.WithHeaders(config.Headers))
+	}
+	if config.Compression == "gzip" {
+		options = append(options, otlpmetricgrpc.WithCompressor("gzip"))
+	}
+	if timeout > 0 {
+		options = append(options, otlpmetricgrpc.WithTimeout(timeout))
+	}
+	if tlsConfig == nil {
+		options = append(options, otlpmetricgrpc.WithInsecure())
+	} else {
+		options = append(options, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(tlsConfig)))
+	}
+	return otlpmetricgrpc.New(ctx, options...)
+}
+
+func newOTLPHTTPExporter(ctx context.Context, config cfg.OTLP, timeout time.Duration, tlsConfig *tls.Config) (metric.Exporter, error) {
+	options := make([]otlpmetrichttp.Option, 0, 6)
+	if config.Endpoint != "" {
+		if strings.Contains(config.Endpoint, "://") {
+			options = append(options, otlpmetrichttp.WithEndpointURL(config.Endpoint))
+		} else {
+			options = append(options, otlpmetrichttp.WithEndpoint(config.Endpoint))
+		}
+	}
+	if config.URLPath != "" {
+		options = append(options, otlpmetrichttp.WithURLPath(config.URLPath))
+	}
+	if len(config.Headers) > 0 {
+		options = append(options, otlpmetrichttp.WithHeaders(config.Headers))
+	}
+	if config.Compression == "gzip" {
+		options = append(options, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+	}
+	if timeout > 0 {
+		options = append(options, otlpmetrichttp.WithTimeout(timeout))
+	}
+	if tlsConfig == nil {
+		options = append(options, otlpmetrichttp.WithInsecure())
+	} else {
+		options = append(options, otlpmetrichttp.WithTLSClientConfig(tlsConfig))
+	}
+	return otlpmetrichttp.New(ctx, options...)
+}
+
+// newTLSClientConfig loads the certificates of the TLS configuration
+func newTLSClientConfig(config cfg.TLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load CA certificates: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", config.CAFile)
+		}
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		certificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
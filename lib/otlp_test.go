@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	collectorpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpReceiver is a stand-in for an OpenTelemetry collector, recording the names of the metrics received
+type otlpReceiver struct {
+	collectorpb.UnimplementedMetricsServiceServer
+	mutex   sync.Mutex
+	metrics []string
+	headers []string
+}
+
+func (r *otlpReceiver) Export(ctx context.Context, request *collectorpb.ExportMetricsServiceRequest) (*collectorpb.ExportMetricsServiceResponse, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		r.record(request, md.Get("x-api-key"))
+	} else {
+		r.record(request, nil)
+	}
+	return &collectorpb.ExportMetricsServiceResponse{}, nil
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	request := &collectorpb.ExportMetricsServiceRequest{}
+	err = proto.Unmarshal(body, request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.record(request, req.Header.Values("X-Api-Key"))
+	response, _ := proto.Marshal(&collectorpb.ExportMetricsServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	_, _ = w.Write(response)
+}
+
+func (r *otlpReceiver) record(request *collectorpb.ExportMetricsServiceRequest, headers []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.headers = append(r.headers, headers...)
+	for _, resource := range request.GetResourceMetrics() {
+		for _, scope := range resource.GetScopeMetrics() {
+			for _, metric := range scope.GetMetrics() {
+				r.metrics = append(r.metrics, metric.GetName())
+			}
+		}
+	}
+}
+
+func (r *otlpReceiver) received() ([]string, []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return r.metrics, r.headers
+}
+
+func TestOTLPOverHTTP(t *testing.T) {
+	receiver := &otlpReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	reader, err := NewOTLPReader(context.Background(), cfg.OTLP{
+		Protocol: OTLPProtocolHTTP,
+		Endpoint: server.URL + "/v1/metrics",
+		Headers:  map[string]string{"X-Api-Key": "secret"},
+		Insecure: true,
+	})
+	require.NoError(t, err)
+	telemetry, err := NewTelemetry(newTestGlobal(t, map[string]string{"first": "/dev/sda"}), reader)
+	require.NoError(t, err)
+	// the last collection is pushed on shutdown
+	require.NoError(t, telemetry.Shutdown(context.Background()))
+
+	metrics, headers := receiver.received()
+	assert.Contains(t, metrics, "disk_active")
+	assert.Contains(t, headers, "secret")
+}
+
+func TestOTLPOverGRPC(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	receiver := &otlpReceiver{}
+	server := grpc.NewServer()
+	collectorpb.RegisterMetricsServiceServer(server, receiver)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	reader, err := NewOTLPReader(context.Background(), cfg.OTLP{
+		Endpoint:    listener.Addr().String(),
+		Headers:     map[string]string{"x-api-key": "secret"},
+		Compression: "gzip",
+		Insecure:    true,
+	})
+	require.NoError(t, err)
+	telemetry, err := NewTelemetry(newTestGlobal(t, map[string]string{"first": "/dev/sda"}), reader)
+	require.NoError(t, err)
+	require.NoError(t, telemetry.Shutdown(context.Background()))
+
+	metrics, headers := receiver.received()
+	assert.Contains(t, metrics, "disk_active")
+	assert.Contains(t, headers, "secret")
+}
+
+func TestInvalidOTLPConfiguration(t *testing.T) {
+	for _, config := range []cfg.OTLP{
+		{Protocol: "udp"},
+		{Interval: "often"},
+		{Timeout: "soon"},
+		{Compression: "zstd"},
+		{TLS: cfg.TLS{CAFile: "does/not/exist.pem"}},
+		{TLS: cfg.TLS{CertFile: "does/not/exist.pem"}},
+	} {
+		_, err := NewOTLPReader(context.Background(), config)
+		assert.Error(t, err, config)
+	}
+}
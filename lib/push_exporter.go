@@ -0,0 +1,136 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+const (
+	defaultPushInterval = 1 * time.Minute
+	defaultPushTimeout  = 10 * time.Second
+)
+
+// metricPoint is a single value of a metric, as sent by the push sinks
+type metricPoint struct {
+	name       string
+	attributes attribute.Set
+	value      float64
+	integer    bool
+	time       time.Time
+}
+
+// pushExporter adapts a function sending the data points to the metric.Exporter interface
+type pushExporter struct {
+	push func(ctx context.Context, points []metricPoint) error
+}
+
+func (e *pushExporter) Temporality(kind metric.InstrumentKind) metricdata.Temporality {
+	return metric.DefaultTemporalitySelector(kind)
+}
+
+func (e *pushExporter) Aggregation(kind metric.InstrumentKind) metric.Aggregation {
+	return metric.DefaultAggregationSelector(kind)
+}
+
+func (e *pushExporter) Export(ctx context.Context, data *metricdata.ResourceMetrics) error {
+	points := flattenMetrics(data)
+	if len(points) == 0 {
+		return nil
+	}
+	return e.push(ctx, points)
+}
+
+func (e *pushExporter) ForceFlush(context.Context) error {
+	return nil
+}
+
+func (e *pushExporter) Shutdown(context.Context) error {
+	return nil
+}
+
+// newPushReader collects the metrics every interval and sends them with the push function
+func newPushReader(name, interval, timeout string, push func(context.Context, []metricPoint) error) (metric.Reader, error) {
+	every, err := parseDurationOrDefault(interval, defaultPushInterval)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s interval: %w", name, err)
+	}
+	wait, err := parseDurationOrDefault(timeout, defaultPushTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s timeout: %w", name, err)
+	}
+	exporter := &pushExporter{push: push}
+	return metric.NewPeriodicReader(exporter, metric.WithInterval(every), metric.WithTimeout(wait)), nil
+}
+
+func parseDurationOrDefault(value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// flattenMetrics converts the gauges, counters and histograms into a list of single values.
+// The histograms are sent as two values: name_count and name_sum
+func flattenMetrics(data *metricdata.ResourceMetrics) []metricPoint {
+	points := make([]metricPoint, 0)
+	for _, scope := range data.ScopeMetrics {
+		for _, m := range scope.Metrics {
+			switch aggregation := m.Data.(type) {
+			case metricdata.Gauge[int64]:
+				points = appendDataPoints(points, m.Name, aggregation.DataPoints)
+			case metricdata.Gauge[float64]:
+				points = appendDataPoints(points, m.Name, aggregation.DataPoints)
+			case metricdata.Sum[int64]:
+				points = appendDataPoints(points, m.Name, aggregation.DataPoints)
+			case metricdata.Sum[float64]:
+				points = appendDataPoints(points, m.Name, aggregation.DataPoints)
+			case metricdata.Histogram[int64]:
+				points = appendHistogramPoints(points, m.Name, aggregation.DataPoints)
+			case metricdata.Histogram[float64]:
+				points = appendHistogramPoints(points, m.Name, aggregation.DataPoints)
+			}
+		}
+	}
+	return points
+}
+
+func appendDataPoints[N int64 | float64](points []metricPoint, name string, dataPoints []metricdata.DataPoint[N]) []metricPoint {
+	for _, dataPoint := range dataPoints {
+		_, integer := any(dataPoint.Value).(int64)
+		points = append(points, metricPoint{
+			name:       name,
+			attributes: dataPoint.Attributes,
+			value:      float64(dataPoint.Value),
+			integer:    integer,
+			time:       dataPoint.Time,
+		})
+	}
+	return points
+}
+
+func appendHistogramPoints[N int64 | float64](points []metricPoint, name string, dataPoints []metricdata.HistogramDataPoint[N]) []metricPoint {
+	for _, dataPoint := range dataPoints {
+		_, integer := any(dataPoint.Sum).(int64)
+		points = append(points,
+			metricPoint{
+				name:       name + "_count",
+				attributes: dataPoint.Attributes,
+				value:      float64(dataPoint.Count),
+				integer:    true,
+				time:       dataPoint.Time,
+			},
+			metricPoint{
+				name:       name + "_sum",
+				attributes: dataPoint.Attributes,
+				value:      float64(dataPoint.Sum),
+				integer:    integer,
+				time:       dataPoint.Time,
+			})
+	}
+	return points
+}
@@ -17,6 +17,7 @@ type Rule struct {
 	FanFrom         int
 	FanTo           int
 	FanSet          int
+	Index           int // position of the rule in the configuration
 }
 
 // NewRule creates a new rule to convert a temperature into a fan speed
@@ -30,6 +30,16 @@ func (s *Schedule) OnStartup() bool {
 	return false
 }
 
+// OnEvent returns true when the task should run when the event is raised
+func (s *Schedule) OnEvent(name string) bool {
+	for _, when := range s.When {
+		if strings.HasPrefix(when, "on ") && strings.TrimSpace(when[3:]) == name {
+			return true
+		}
+	}
+	return false
+}
+
 func (s *Schedule) OnTimer() time.Duration {
 	for _, when := range s.When {
 		if strings.HasPrefix(when, "every ") {
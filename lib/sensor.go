@@ -4,8 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"io/fs"
-	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
@@ -31,6 +29,8 @@ type Sensor struct {
 	Command      CommandRunner
 	File         string
 	AverageFiles []string
+	Discovered   string // stable name of a sensor discovered in sysfs
+	sysfsFile    string // file resolved from the discovered sensor name
 }
 
 // NewSensor creates a new access to the hardware
@@ -47,7 +47,7 @@ func NewSensor(name string, config cfg.Task, fileSystem fs.FS) (*Sensor, error)
 	}
 
 	if config.Command != "" {
-		command, err := NewCommand(config.Command, config.Regexp, timeout)
+		command, err := newNamedCommand(name, config.Command, config.Regexp, timeout)
 		if err != nil {
 			return nil, err
 		}
@@ -72,6 +72,17 @@ func NewSensor(name string, config cfg.Task, fileSystem fs.FS) (*Sensor, error)
 			File:      config.File,
 		}, nil
 	}
+	if config.Sensor != "" {
+		return &Sensor{
+			config:     config,
+			mutex:      sync.Mutex{},
+			fs:         fileSystem,
+			aggregate:  aggregate,
+			timeout:    timeout,
+			Name:       name,
+			Discovered: config.Sensor,
+		}, nil
+	}
 	if len(config.Files) > 0 {
 		return &Sensor{
 			config:       config,
@@ -125,20 +136,26 @@ func (s *Sensor) getRaw(expandEnv func(string) string) ([]string, error) {
 		return []string{strings.TrimSpace(output)}, nil
 	}
 	if s.File != "" {
-		// resolve file name
-		filename := os.Expand(s.File, expandEnv)
-		matches, err := filepath.Glob(filename)
+		output, err := readSingleFile(s.fs, s.File, expandEnv)
 		if err != nil {
-			return nil, fmt.Errorf("cannot find sensor file: %w", err)
+			return nil, fmt.Errorf("cannot read sensor file: %w", err)
 		}
-		if len(matches) != 1 {
-			return nil, fmt.Errorf("expected 1 file but found %d: %q", len(matches), filename)
+		return []string{output}, nil
+	}
+	if s.Discovered != "" {
+		if s.sysfsFile == "" {
+			// hwmon numbering can change between reboots so we resolve the file the first time we need it
+			filename, err := FindDiscoveredSensor(s.fs, s.Discovered)
+			if err != nil {
+				return nil, err
+			}
+			clog.Debugf("sensor %s: %q resolved to %q", s.Name, s.Discovered, filename)
+			s.sysfsFile = filename
 		}
-		filename = matches[0]
-		// that's a bit much :)
-		clog.Tracef("reading file %q", filename)
-		output, err := s.readFile(filename)
+		output, err := readFile(s.fs, s.sysfsFile)
 		if err != nil {
+			// the device might have been removed: try to resolve it again next time
+			s.sysfsFile = ""
 			return nil, err
 		}
 		return []string{output}, nil
@@ -146,14 +163,14 @@ func (s *Sensor) getRaw(expandEnv func(string) string) ([]string, error) {
 	if len(s.AverageFiles) > 0 {
 		values := make([]string, 0, len(s.AverageFiles))
 		for _, fileglob := range s.AverageFiles {
-			matches, err := fs.Glob(s.fs, fileglob)
+			matches, err := fs.Glob(s.fs, fsPath(fileglob))
 			if err != nil {
 				return nil, err
 			}
 			for _, filename := range matches {
 				// that's a bit much :)
 				// clog.Tracef("reading file %q", filename)
-				output, err := s.readFile(filename)
+				output, err := readFile(s.fs, filename)
 				if err != nil {
 					return nil, err
 				}
@@ -164,13 +181,3 @@ func (s *Sensor) getRaw(expandEnv func(string) string) ([]string, error) {
 	}
 	return nil, nil
 }
-
-func (s *Sensor) readFile(filename string) (string, error) {
-	// to use fs.Readfile we need to strip the first "/" from the path. let's leave it for now
-	// file, err := fs.ReadFile(s.fs, filename)
-	output, err := os.ReadFile(filename)
-	if err != nil {
-		return "", err
-	}
-	return strings.TrimSpace(string(output)), nil
-}
@@ -0,0 +1,290 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/creativeprojects/hardware-events/lib/enum"
+)
+
+const (
+	DiskStatusTypeSGIO = "sgio"
+
+	SGIOProtocolATA  = "ata"
+	SGIOProtocolSCSI = "scsi"
+
+	defaultSGIOTimeout = 30 * time.Second
+
+	// SCSI operation codes
+	scsiRequestSense     = 0x03
+	scsiStartStopUnit    = 0x1b
+	scsiATAPassThrough16 = 0x85
+
+	// ATA commands
+	ataStandbyImmediate = 0xe0
+	ataIdleImmediate    = 0xe1
+	ataCheckPowerMode   = 0xe5
+
+	ataProtocolNonData  = 3 << 1
+	ataCheckCondition   = 0x20 // ask the device to return the ATA registers in the sense data
+	ataStatusError      = 0x01
+	ataStatusReturnCode = 0x09 // ATA status return sense data descriptor
+
+	// SCSI sense data
+	senseFixedCurrent       = 0x70
+	senseFixedDeferred      = 0x71
+	senseDescriptorCurrent  = 0x72
+	senseDescriptorDeferred = 0x73
+	senseLowPowerCondition  = 0x5e
+	requestSenseLength      = 252
+)
+
+// SGIOResult is the outcome of a SCSI command sent through the SG_IO interface
+type SGIOResult struct {
+	Status byte   // SCSI status
+	Sense  []byte // sense data, when returned by the device
+}
+
+// SGIOTransport sends a SCSI command to a block device. When data is not nil, the command is expected to read data from the device.
+type SGIOTransport interface {
+	Execute(device string, cdb, data []byte, timeout time.Duration) (SGIOResult, error)
+}
+
+// SGIODiskStatus checks and changes the power mode of a disk with ATA or SCSI commands, without forking an external program
+type SGIODiskStatus struct {
+	Name      string
+	protocol  string
+	timeout   time.Duration
+	transport SGIOTransport
+	mutex     sync.Mutex
+}
+
+// NewSGIODiskStatus creates a disk power status sending commands through the SG_IO interface of the kernel
+func NewSGIODiskStatus(name string, config cfg.DiskPowerStatus) (*SGIODiskStatus, error) {
+	return newSGIODiskStatus(name, config, newSGIOTransport())
+}
+
+func newSGIODiskStatus(name string, config cfg.DiskPowerStatus, transport SGIOTransport) (*SGIODiskStatus, error) {
+	var err error
+
+	timeout := defaultSGIOTimeout
+	if config.Timeout != "" {
+		timeout, err = time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	protocol := config.Protocol
+	if protocol == "" {
+		protocol = SGIOProtocolATA
+	}
+	if protocol != SGIOProtocolATA && protocol != SGIOProtocolSCSI {
+		return nil, fmt.Errorf("unknown SG_IO protocol %q: expected %q or %q", protocol, SGIOProtocolATA, SGIOProtocolSCSI)
+	}
+
+	return &SGIODiskStatus{
+		Name:      name,
+		protocol:  protocol,
+		timeout:   timeout,
+		transport: transport,
+		mutex:     sync.Mutex{},
+	}, nil
+}
+
+func (s *SGIODiskStatus) Get(expandEnv func(string) string) enum.DiskStatus {
+	device, err := sgioDevice(expandEnv)
+	if err != nil {
+		clog.Error(err)
+		return enum.DiskStatusUnknown
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var status enum.DiskStatus
+	if s.protocol == SGIOProtocolSCSI {
+		status, err = s.scsiPowerCondition(device)
+	} else {
+		status, err = s.ataPowerMode(device)
+	}
+	if err != nil {
+		clog.Debugf("%s: cannot check power mode of %s: %s", s.Name, device, err)
+		return enum.DiskStatusUnknown
+	}
+	return status
+}
+
+func (s *SGIODiskStatus) Standby(expandEnv func(string) string) error {
+	device, err := sgioDevice(expandEnv)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.protocol == SGIOProtocolSCSI {
+		// power condition STANDBY
+		return s.execute(device, scsiStartStopCDB(0x03<<4))
+	}
+	return s.execute(device, ataCDB(ataStandbyImmediate, false))
+}
+
+func (s *SGIODiskStatus) Wake(expandEnv func(string) string) error {
+	device, err := sgioDevice(expandEnv)
+	if err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.protocol == SGIOProtocolSCSI {
+		// START bit
+		return s.execute(device, scsiStartStopCDB(0x01))
+	}
+	return s.execute(device, ataCDB(ataIdleImmediate, false))
+}
+
+// execute sends a command that is not returning any data
+func (s *SGIODiskStatus) execute(device string, cdb []byte) error {
+	result, err := s.transport.Execute(device, cdb, nil, s.timeout)
+	if err != nil {
+		return err
+	}
+	if result.Status != 0 {
+		return fmt.Errorf("command 0x%02x failed on %s: SCSI status 0x%02x", cdb[0], device, result.Status)
+	}
+	return nil
+}
+
+// ataPowerMode sends CHECK POWER MODE through ATA PASS-THROUGH (16)
+func (s *SGIODiskStatus) ataPowerMode(device string) (enum.DiskStatus, error) {
+	result, err := s.transport.Execute(device, ataCDB(ataCheckPowerMode, true), nil, s.timeout)
+	if err != nil {
+		return enum.DiskStatusUnknown, err
+	}
+	count, status, err := parseATAStatusReturn(result.Sense)
+	if err != nil {
+		return enum.DiskStatusUnknown, err
+	}
+	if status&ataStatusError != 0 {
+		return enum.DiskStatusUnknown, fmt.Errorf("CHECK POWER MODE aborted by the device (status 0x%02x)", status)
+	}
+	return ataPowerModeStatus(count), nil
+}
+
+// scsiPowerCondition reads the power condition from the sense data returned by REQUEST SENSE
+func (s *SGIODiskStatus) scsiPowerCondition(device string) (enum.DiskStatus, error) {
+	data := make([]byte, requestSenseLength)
+	cdb := []byte{scsiRequestSense, 0, 0, 0, requestSenseLength, 0}
+	result, err := s.transport.Execute(device, cdb, data, s.timeout)
+	if err != nil {
+		return enum.DiskStatusUnknown, err
+	}
+	if result.Status != 0 {
+		return enum.DiskStatusUnknown, fmt.Errorf("REQUEST SENSE failed: SCSI status 0x%02x", result.Status)
+	}
+	asc, ascq, err := parseSenseCode(data)
+	if err != nil {
+		return enum.DiskStatusUnknown, err
+	}
+	return scsiPowerConditionStatus(asc, ascq), nil
+}
+
+// ataCDB builds an ATA PASS-THROUGH (16) command block for a non-data ATA command
+func ataCDB(command byte, checkCondition bool) []byte {
+	cdb := make([]byte, 16)
+	cdb[0] = scsiATAPassThrough16
+	cdb[1] = ataProtocolNonData
+	if checkCondition {
+		cdb[2] = ataCheckCondition
+	}
+	cdb[14] = command
+	return cdb
+}
+
+// scsiStartStopCDB builds a START STOP UNIT command block
+func scsiStartStopCDB(condition byte) []byte {
+	return []byte{scsiStartStopUnit, 0, 0, 0, condition, 0}
+}
+
+// parseATAStatusReturn returns the count and status registers returned in the sense data
+func parseATAStatusReturn(sense []byte) (count, status byte, err error) {
+	if len(sense) < 8 {
+		return 0, 0, errors.New("no sense data returned")
+	}
+	switch sense[0] & 0x7f {
+	case senseDescriptorCurrent, senseDescriptorDeferred:
+		end := min(8+int(sense[7]), len(sense))
+		for i := 8; i+1 < end; i += 2 + int(sense[i+1]) {
+			if sense[i] == ataStatusReturnCode && i+13 < end {
+				return sense[i+5], sense[i+13], nil
+			}
+		}
+		return 0, 0, errors.New("no ATA status return descriptor in sense data")
+
+	case senseFixedCurrent, senseFixedDeferred:
+		// the INFORMATION field contains the ERROR, STATUS, DEVICE and COUNT registers
+		return sense[6], sense[4], nil
+	}
+	return 0, 0, fmt.Errorf("unexpected sense data response code 0x%02x", sense[0])
+}
+
+// parseSenseCode returns the additional sense code and qualifier
+func parseSenseCode(sense []byte) (asc, ascq byte, err error) {
+	if len(sense) < 4 {
+		return 0, 0, errors.New("no sense data returned")
+	}
+	switch sense[0] & 0x7f {
+	case senseDescriptorCurrent, senseDescriptorDeferred:
+		return sense[2], sense[3], nil
+
+	case senseFixedCurrent, senseFixedDeferred:
+		if len(sense) < 14 {
+			return 0, 0, errors.New("sense data too short")
+		}
+		return sense[12], sense[13], nil
+	}
+	return 0, 0, fmt.Errorf("unexpected sense data response code 0x%02x", sense[0])
+}
+
+// ataPowerModeStatus converts the count register returned by CHECK POWER MODE
+func ataPowerModeStatus(count byte) enum.DiskStatus {
+	switch count {
+	case 0x00, 0x01, 0x40, 0x41:
+		// standby, standby_y, and NV cache power modes with the spindle spun down
+		return enum.DiskStatusStandby
+	case 0x80, 0x81, 0x82, 0x83, 0xff:
+		// idle modes and active
+		return enum.DiskStatusActive
+	}
+	return enum.DiskStatusUnknown
+}
+
+// scsiPowerConditionStatus converts the LOW POWER CONDITION ON sense code
+func scsiPowerConditionStatus(asc, ascq byte) enum.DiskStatus {
+	if asc != senseLowPowerCondition {
+		return enum.DiskStatusActive
+	}
+	switch ascq {
+	case 0x02, 0x04, 0x09, 0x0a:
+		// standby and standby_y condition activated by timer or command
+		return enum.DiskStatusStandby
+	}
+	// idle conditions
+	return enum.DiskStatusActive
+}
+
+func sgioDevice(expandEnv func(string) string) (string, error) {
+	if expandEnv == nil {
+		return "", errors.New("no device available")
+	}
+	device := expandEnv("DEVICE")
+	if device == "" || device == "$DEVICE" {
+		return "", errors.New("no device available")
+	}
+	return device, nil
+}
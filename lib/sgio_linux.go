@@ -0,0 +1,90 @@
+//go:build linux
+
+package lib
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	sgIO             = 0x2285
+	sgInterfaceID    = 'S'
+	sgDxferNone      = -1
+	sgDxferFromDev   = -3
+	sgDriverSense    = 0x08
+	sgMaxSenseLength = 64
+)
+
+// sgIOHeader is struct sg_io_hdr from <scsi/sg.h>
+type sgIOHeader struct {
+	interfaceID    int32
+	dxferDirection int32
+	cmdLen         uint8
+	mxSbLen        uint8
+	iovecCount     uint16
+	dxferLen       uint32
+	dxferp         unsafe.Pointer
+	cmdp           unsafe.Pointer
+	sbp            unsafe.Pointer
+	timeout        uint32
+	flags          uint32
+	packID         int32
+	usrPtr         unsafe.Pointer
+	status         uint8
+	maskedStatus   uint8
+	msgStatus      uint8
+	sbLenWr        uint8
+	hostStatus     uint16
+	driverStatus   uint16
+	resid          int32
+	duration       uint32
+	info           uint32
+}
+
+type linuxSGIO struct{}
+
+func newSGIOTransport() SGIOTransport {
+	return linuxSGIO{}
+}
+
+func (linuxSGIO) Execute(device string, cdb, data []byte, timeout time.Duration) (SGIOResult, error) {
+	// O_NONBLOCK: don't wait for the device to be ready
+	file, err := os.OpenFile(device, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return SGIOResult{}, err
+	}
+	defer file.Close()
+
+	sense := make([]byte, sgMaxSenseLength)
+	header := sgIOHeader{
+		interfaceID:    sgInterfaceID,
+		dxferDirection: sgDxferNone,
+		cmdLen:         uint8(len(cdb)),
+		mxSbLen:        uint8(len(sense)),
+		cmdp:           unsafe.Pointer(&cdb[0]),
+		sbp:            unsafe.Pointer(&sense[0]),
+		timeout:        uint32(timeout.Milliseconds()),
+	}
+	if len(data) > 0 {
+		header.dxferDirection = sgDxferFromDev
+		header.dxferLen = uint32(len(data))
+		header.dxferp = unsafe.Pointer(&data[0])
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), sgIO, uintptr(unsafe.Pointer(&header)))
+	if errno != 0 {
+		return SGIOResult{}, fmt.Errorf("SG_IO ioctl on %s: %w", device, errno)
+	}
+	if header.hostStatus != 0 || header.driverStatus&^sgDriverSense != 0 {
+		return SGIOResult{}, fmt.Errorf("SG_IO on %s: host status 0x%x, driver status 0x%x", device, header.hostStatus, header.driverStatus)
+	}
+	result := SGIOResult{Status: header.status}
+	if header.sbLenWr > 0 {
+		result.Sense = sense[:header.sbLenWr]
+	}
+	return result, nil
+}
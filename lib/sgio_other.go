@@ -0,0 +1,18 @@
+//go:build !linux
+
+package lib
+
+import (
+	"errors"
+	"time"
+)
+
+type unsupportedSGIO struct{}
+
+func newSGIOTransport() SGIOTransport {
+	return unsupportedSGIO{}
+}
+
+func (unsupportedSGIO) Execute(device string, cdb, data []byte, timeout time.Duration) (SGIOResult, error) {
+	return SGIOResult{}, errors.New("SG_IO is only available on linux")
+}
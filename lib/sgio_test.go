@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/creativeprojects/hardware-events/lib/enum"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSGIO struct {
+	devices []string
+	cdbs    [][]byte
+	result  SGIOResult
+	data    []byte // returned for data-in commands
+	err     error
+}
+
+func (f *fakeSGIO) Execute(device string, cdb, data []byte, timeout time.Duration) (SGIOResult, error) {
+	f.devices = append(f.devices, device)
+	f.cdbs = append(f.cdbs, cdb)
+	copy(data, f.data)
+	return f.result, f.err
+}
+
+func sgioExpandEnv(input string) string {
+	if input == "DEVICE" {
+		return "/dev/sda"
+	}
+	return "$" + input
+}
+
+// descriptor format sense data with an ATA status return descriptor
+func ataDescriptorSense(count, status byte) []byte {
+	return []byte{
+		0x72, 0x01, 0x00, 0x1d, 0, 0, 0, 14,
+		0x09, 0x0c, 0x00, 0x00, 0x00, count, 0, 0, 0, 0, 0, 0, 0x00, status,
+	}
+}
+
+func TestSGIOUnknownProtocol(t *testing.T) {
+	_, err := newSGIODiskStatus("sgio", cfg.DiskPowerStatus{Protocol: "sata"}, &fakeSGIO{})
+	assert.Error(t, err)
+}
+
+func TestATACheckPowerMode(t *testing.T) {
+	testData := []struct {
+		count  byte
+		status enum.DiskStatus
+	}{
+		{0x00, enum.DiskStatusStandby},
+		{0x01, enum.DiskStatusStandby},
+		{0x40, enum.DiskStatusStandby},
+		{0x80, enum.DiskStatusActive},
+		{0x83, enum.DiskStatusActive},
+		{0xff, enum.DiskStatusActive},
+		{0x10, enum.DiskStatusUnknown},
+	}
+	for _, testItem := range testData {
+		transport := &fakeSGIO{result: SGIOResult{Status: 0x02, Sense: ataDescriptorSense(testItem.count, 0x50)}}
+		diskStatus, err := newSGIODiskStatus("sgio", cfg.DiskPowerStatus{Type: DiskStatusTypeSGIO}, transport)
+		require.NoError(t, err)
+
+		assert.Equal(t, testItem.status, diskStatus.Get(sgioExpandEnv), "count register 0x%02x", testItem.count)
+		assert.Equal(t, []string{"/dev/sda"}, transport.devices)
+		assert.Equal(t, []byte{0x85, 0x06, 0x20, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xe5, 0}, transport.cdbs[0])
+	}
+}
+
+func TestATACheckPowerModeFixedSense(t *testing.T) {
+	sense := []byte{0x70, 0, 0x00, 0x00, 0x50, 0x00, 0xff, 10, 0, 0, 0, 0, 0, 0x1d, 0, 0, 0, 0}
+	transport := &fakeSGIO{result: SGIOResult{Status: 0x02, Sense: sense}}
+	diskStatus, err := newSGIODiskStatus("sgio", cfg.DiskPowerStatus{}, transport)
+	require.NoError(t, err)
+	assert.Equal(t, enum.DiskStatusActive, diskStatus.Get(sgioExpandEnv))
+}
+
+func TestATACheckPowerModeErrors(t *testing.T) {
+	testData := []*fakeSGIO{
+		{err: errors.New("no such device")},
+		{result: SGIOResult{Status: 0x02}},
+		{result: SGIOResult{Status: 0x02, Sense: ataDescriptorSense(0x00, 0x51)}},                // ERR bit set
+		{result: SGIOResult{Status: 0x02, Sense: []byte{0x72, 0x05, 0x20, 0x00, 0, 0, 0, 0, 0}}}, // no descriptor
+	}
+	for _, transport := range testData {
+		diskStatus, err := newSGIODiskStatus("sgio", cfg.DiskPowerStatus{}, transport)
+		require.NoError(t, err)
+		assert.Equal(t, enum.DiskStatusUnknown, diskStatus.Get(sgioExpandEnv))
+	}
+
+	// no device to send the command to
+	transport := &fakeSGIO{}
+	diskStatus, err := newSGIODiskStatus("sgio", cfg.DiskPowerStatus{}, transport)
+	require.NoError(t, err)
+	assert.Equal(t, enum.DiskStatusUnknown, diskStatus.Get(nil))
+	assert.Empty(t, transport.cdbs)
+}
+
+func TestATAStandbyAndWake(t *testing.T) {
+	transport := &fakeSGIO{}
+	diskStatus, err := newSGIODiskStatus("sgio", cfg.DiskPowerStatus{}, transport)
+	require.NoError(t, err)
+
+	require.NoError(t, diskStatus.Standby(sgioExpandEnv))
+	require.NoError(t, diskStatus.Wake(sgioExpandEnv))
+	require.Len(t, transport.cdbs, 2)
+	assert.Equal(t, []byte{0x85, 0x06, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xe0, 0}, transport.cdbs[0])
+	assert.Equal(t, []byte{0x85, 0x06, 0x00, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xe1, 0}, transport.cdbs[1])
+
+	transport.result.Status = 0x02
+	assert.Error(t, diskStatus.Standby(sgioExpandEnv))
+}
+
+func TestSCSIPowerCondition(t *testing.T) {
+	testData := []struct {
+		sense  []byte
+		status enum.DiskStatus
+	}{
+		{[]byte{0x70, 0, 0x00, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0x00, 0x00}, enum.DiskStatusActive},
+		{[]byte{0x70, 0, 0x00, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0x5e, 0x04}, enum.DiskStatusStandby},
+		{[]byte{0x70, 0, 0x00, 0, 0, 0, 0, 10, 0, 0, 0, 0, 0x5e, 0x01}, enum.DiskStatusActive},
+		{[]byte{0x72, 0x00, 0x5e, 0x02, 0, 0, 0, 0}, enum.DiskStatusStandby},
+		{[]byte{0x00}, enum.DiskStatusUnknown},
+	}
+	for _, testItem := range testData {
+		transport := &fakeSGIO{data: testItem.sense}
+		diskStatus, err := newSGIODiskStatus("sgio", cfg.DiskPowerStatus{Protocol: SGIOProtocolSCSI}, transport)
+		require.NoError(t, err)
+
+		assert.Equal(t, testItem.status, diskStatus.Get(sgioExpandEnv))
+		assert.Equal(t, []byte{0x03, 0, 0, 0, 252, 0}, transport.cdbs[0])
+	}
+}
+
+func TestSCSIStandbyAndWake(t *testing.T) {
+	transport := &fakeSGIO{}
+	diskStatus, err := newSGIODiskStatus("sgio", cfg.DiskPowerStatus{Protocol: SGIOProtocolSCSI}, transport)
+	require.NoError(t, err)
+
+	require.NoError(t, diskStatus.Standby(sgioExpandEnv))
+	require.NoError(t, diskStatus.Wake(sgioExpandEnv))
+	assert.Equal(t, [][]byte{
+		{0x1b, 0, 0, 0, 0x30, 0},
+		{0x1b, 0, 0, 0, 0x01, 0},
+	}, transport.cdbs)
+}
@@ -14,6 +14,7 @@ type DiskStatus struct {
 	name           string
 	checkCommand   string
 	standbyCommand string
+	wakeCommand    string
 	mutex          sync.Mutex
 }
 
@@ -23,6 +24,7 @@ func NewDiskStatus(name string, config cfg.DiskPowerStatus) (*DiskStatus, error)
 		name:           name,
 		checkCommand:   config.CheckCommand,
 		standbyCommand: config.StandbyCommand,
+		wakeCommand:    config.WakeCommand,
 		mutex:          sync.Mutex{},
 	}, nil
 }
@@ -53,3 +55,15 @@ func (s *DiskStatus) Standby(expandEnv func(string) string) error {
 	s.status[disk] = enum.DiskStatusStandby
 	return nil
 }
+
+func (s *DiskStatus) Wake(expandEnv func(string) string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	command := os.Expand(s.wakeCommand, expandEnv)
+	clog.Debugf("command: %s", command)
+
+	disk := expandEnv("DEVICE")
+	s.status[disk] = enum.DiskStatusActive
+	return nil
+}
@@ -0,0 +1,215 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+)
+
+const (
+	SensorTypeSmart = "smart"
+
+	// -n standby: smartctl won't wake up a disk in standby mode
+	defaultSmartCommand = "smartctl --json -a -n standby ${DEVICE}"
+
+	smartAttributeReallocatedSectors = 5
+	smartAttributePendingSectors     = 197
+	smartAttributeCRCErrors          = 199
+)
+
+// SmartGetter is a sensor able to return SMART data
+type SmartGetter interface {
+	SensorGetter
+	GetSmart(expandEnv func(string) string) (SmartData, error)
+}
+
+// SmartData contains the disk health attributes we're interested in
+type SmartData struct {
+	Temperature        int   `json:"temperature"`
+	PowerOnHours       int   `json:"power_on_hours"`
+	ReallocatedSectors int64 `json:"reallocated_sectors"`
+	PendingSectors     int64 `json:"pending_sectors"`
+	CRCErrors          int64 `json:"crc_errors"`
+	CriticalWarning    int   `json:"critical_warning"` // NVMe only
+	Healthy            bool  `json:"healthy"`
+}
+
+// smartctlOutput is the part of `smartctl --json` we decode
+type smartctlOutput struct {
+	Smartctl struct {
+		ExitStatus int `json:"exit_status"`
+		Messages   []struct {
+			String   string `json:"string"`
+			Severity string `json:"severity"`
+		} `json:"messages"`
+	} `json:"smartctl"`
+	SmartStatus *struct {
+		Passed bool `json:"passed"`
+	} `json:"smart_status"`
+	Temperature struct {
+		Current int `json:"current"`
+	} `json:"temperature"`
+	PowerOnTime struct {
+		Hours int `json:"hours"`
+	} `json:"power_on_time"`
+	AtaSmartAttributes struct {
+		Table []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+			Raw  struct {
+				Value int64 `json:"value"`
+			} `json:"raw"`
+		} `json:"table"`
+	} `json:"ata_smart_attributes"`
+	NvmeSmartHealthInformationLog *struct {
+		CriticalWarning int `json:"critical_warning"`
+		Temperature     int `json:"temperature"`
+		PowerOnHours    int `json:"power_on_hours"`
+	} `json:"nvme_smart_health_information_log"`
+}
+
+// ParseSmartctlJSON decodes the output of `smartctl --json`
+func ParseSmartctlJSON(input []byte) (SmartData, error) {
+	output := smartctlOutput{}
+	err := json.Unmarshal(input, &output)
+	if err != nil {
+		return SmartData{}, fmt.Errorf("invalid smartctl output: %w", err)
+	}
+	if output.SmartStatus == nil {
+		// no SMART data returned: the disk is in standby or SMART is not available
+		message := "no SMART data available"
+		if len(output.Smartctl.Messages) > 0 {
+			message = output.Smartctl.Messages[0].String
+		}
+		return SmartData{}, errors.New(message)
+	}
+	data := SmartData{
+		Temperature:  output.Temperature.Current,
+		PowerOnHours: output.PowerOnTime.Hours,
+		Healthy:      output.SmartStatus.Passed,
+	}
+	for _, attribute := range output.AtaSmartAttributes.Table {
+		switch attribute.ID {
+		case smartAttributeReallocatedSectors:
+			data.ReallocatedSectors = attribute.Raw.Value
+		case smartAttributePendingSectors:
+			data.PendingSectors = attribute.Raw.Value
+		case smartAttributeCRCErrors:
+			data.CRCErrors = attribute.Raw.Value
+		}
+	}
+	if nvme := output.NvmeSmartHealthInformationLog; nvme != nil {
+		data.CriticalWarning = nvme.CriticalWarning
+		if data.Temperature == 0 {
+			data.Temperature = nvme.Temperature
+		}
+		if data.PowerOnHours == 0 {
+			data.PowerOnHours = nvme.PowerOnHours
+		}
+	}
+	return data, nil
+}
+
+// Degraded returns a description of the health attributes which got worse since the previous reading
+func (d SmartData) Degraded(previous SmartData) []string {
+	var changes []string
+	if previous.Healthy && !d.Healthy {
+		changes = append(changes, "SMART overall health check failed")
+	}
+	if d.ReallocatedSectors > previous.ReallocatedSectors {
+		changes = append(changes, fmt.Sprintf("reallocated sectors raised from %d to %d", previous.ReallocatedSectors, d.ReallocatedSectors))
+	}
+	if d.PendingSectors > previous.PendingSectors {
+		changes = append(changes, fmt.Sprintf("pending sectors raised from %d to %d", previous.PendingSectors, d.PendingSectors))
+	}
+	if d.CRCErrors > previous.CRCErrors {
+		changes = append(changes, fmt.Sprintf("CRC errors raised from %d to %d", previous.CRCErrors, d.CRCErrors))
+	}
+	if d.CriticalWarning != previous.CriticalWarning && d.CriticalWarning != 0 {
+		changes = append(changes, fmt.Sprintf("NVMe critical warning %#x", d.CriticalWarning))
+	}
+	return changes
+}
+
+// SmartSensor reads SMART data from `smartctl --json` or from a file containing its output
+type SmartSensor struct {
+	mutex   sync.Mutex
+	fs      fs.FS
+	Name    string
+	Command CommandRunner
+	File    string
+}
+
+// NewSmartSensor creates a sensor running smartctl. The default command is used when none is specified.
+func NewSmartSensor(name string, config cfg.Task, fileSystem fs.FS) (*SmartSensor, error) {
+	var timeout time.Duration
+	var err error
+
+	if config.Timeout != "" {
+		timeout, err = time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sensor := &SmartSensor{
+		mutex: sync.Mutex{},
+		fs:    fileSystem,
+		Name:  name,
+		File:  config.File,
+	}
+	if config.File != "" {
+		return sensor, nil
+	}
+	commandLine := config.Command
+	if commandLine == "" {
+		commandLine = defaultSmartCommand
+	}
+	sensor.Command, err = newNamedCommand(name, commandLine, "", timeout)
+	if err != nil {
+		return nil, err
+	}
+	return sensor, nil
+}
+
+// Get returns the disk temperature
+func (s *SmartSensor) Get(expandEnv func(string) string) (int, error) {
+	data, err := s.GetSmart(expandEnv)
+	if err != nil {
+		return 0, err
+	}
+	return data.Temperature, nil
+}
+
+// GetSmart returns all the SMART data from the disk
+func (s *SmartSensor) GetSmart(expandEnv func(string) string) (SmartData, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var output string
+	var err error
+	if s.File != "" {
+		output, err = readSingleFile(s.fs, s.File, expandEnv)
+		if err != nil {
+			return SmartData{}, err
+		}
+	} else {
+		// smartctl returns a non-zero exit code as a bit mask: some bits are not fatal (like error logs present)
+		// so we try to decode the output anyway
+		output, err = s.Command.Run(nil, expandEnv)
+		if output == "" && err != nil {
+			return SmartData{}, err
+		}
+	}
+	data, err := ParseSmartctlJSON([]byte(output))
+	if err != nil {
+		return data, fmt.Errorf("%s: %w", s.Name, err)
+	}
+	clog.Tracef("%s: %+v", s.Name, data)
+	return data, nil
+}
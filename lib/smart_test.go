@@ -0,0 +1,115 @@
+package lib
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSmartctlJSON(t *testing.T) {
+	testData := []struct {
+		file     string
+		expected SmartData
+	}{
+		{"sda", SmartData{Temperature: 34, PowerOnHours: 56482, ReallocatedSectors: 8, PendingSectors: 2, CRCErrors: 1, Healthy: true}},
+		{"nvme0n1", SmartData{Temperature: 38, PowerOnHours: 8915, CriticalWarning: 4, Healthy: false}},
+	}
+	for _, testItem := range testData {
+		t.Run(testItem.file, func(t *testing.T) {
+			content, err := os.ReadFile("test_files/smartctl/" + testItem.file + ".json")
+			require.NoError(t, err)
+			data, err := ParseSmartctlJSON(content)
+			require.NoError(t, err)
+			assert.Equal(t, testItem.expected, data)
+		})
+	}
+}
+
+func TestParseSmartctlJSONInStandby(t *testing.T) {
+	content, err := os.ReadFile("test_files/smartctl/sdb.json")
+	require.NoError(t, err)
+	_, err = ParseSmartctlJSON(content)
+	assert.ErrorContains(t, err, "STANDBY")
+}
+
+func TestSmartDegraded(t *testing.T) {
+	previous := SmartData{Healthy: true, ReallocatedSectors: 8, PendingSectors: 2}
+	assert.Empty(t, previous.Degraded(previous))
+	assert.Empty(t, SmartData{Healthy: true, ReallocatedSectors: 8}.Degraded(previous))
+
+	current := SmartData{Healthy: false, ReallocatedSectors: 10, PendingSectors: 3, CRCErrors: 1, CriticalWarning: 1}
+	assert.Len(t, current.Degraded(previous), 5)
+}
+
+func TestSmartSensorFromFile(t *testing.T) {
+	sensor, err := NewSmartSensor("smart", cfg.Task{Type: SensorTypeSmart, File: "test_files/smartctl/${DEVICE_NAME}.json"}, testingFS)
+	require.NoError(t, err)
+
+	temperature, err := sensor.Get(func(input string) string {
+		return "sda"
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 34, temperature)
+}
+
+func TestSmartSensorFromCommand(t *testing.T) {
+	sensor, err := NewSmartSensor("smart", cfg.Task{Type: SensorTypeSmart, Command: "cat test_files/smartctl/nvme0n1.json"}, nil)
+	require.NoError(t, err)
+
+	data, err := sensor.GetSmart(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 4, data.CriticalWarning)
+}
+
+func TestSmartSensorDefaultCommand(t *testing.T) {
+	sensor, err := NewSmartSensor("smart", cfg.Task{Type: SensorTypeSmart}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, defaultSmartCommand, sensor.Command.(*Command).CommandLine)
+}
+
+type eventCommand chan string
+
+func (c eventCommand) Run(stdin io.Reader, expand func(string) string) (string, error) {
+	c <- os.Expand("$EVENT $EVENT_SOURCE: $EVENT_MESSAGE", expand)
+	return "", nil
+}
+
+func TestDiskHealthDegradedEvent(t *testing.T) {
+	events := make(eventCommand, 1)
+	global := &Global{
+		fs: os.DirFS("."),
+		TemperatureSensors: map[string]SensorGetter{
+			"smart": &SmartSensor{Name: "smart", fs: testingFS, File: "test_files/smartctl/sda.json"},
+		},
+		Schedules: map[string]*Schedule{
+			"alert": {Task: &Task{Name: "alert", Command: events}, When: []string{"on " + EventDiskHealthDegraded}},
+		},
+	}
+	disk, err := NewDisk(global, "disk", cfg.Disk{
+		Device:             "fs_test_files/dev/sda",
+		TemperatureSensor:  "smart",
+		MonitorTemperature: "always",
+	}, nil)
+	require.NoError(t, err)
+
+	assert.True(t, disk.HasSmart())
+	assert.Equal(t, 34, disk.Temperature())
+	data := disk.Smart()
+	require.NotNil(t, data)
+	assert.Equal(t, int64(8), data.ReallocatedSectors)
+
+	// pretend the previous reading was better
+	disk.previousSmart = &SmartData{Healthy: true, ReallocatedSectors: 4, PendingSectors: 2, CRCErrors: 1}
+	disk.checkHealth(*data)
+	select {
+	case event := <-events:
+		assert.Equal(t, "disk_health_degraded disk: reallocated sectors raised from 4 to 8", event)
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+	}
+}
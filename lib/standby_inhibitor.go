@@ -0,0 +1,317 @@
+package lib
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+)
+
+const (
+	InhibitTargetDisk = "disk"
+	InhibitTargetPool = "pool"
+
+	procPath = "proc"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// StandbyInhibitor prevents a disk or a pool from being put in standby mode
+type StandbyInhibitor struct {
+	fs      fs.FS
+	config  cfg.Inhibit
+	windows []timeWindow
+	mutex   sync.Mutex
+	until   time.Time // inhibited from the API
+	reason  string
+}
+
+// InhibitorReport is the status of a standby inhibitor
+type InhibitorReport struct {
+	Inhibited string     `json:"inhibited,omitempty"` // reason why the standby is currently inhibited
+	Windows   []string   `json:"windows,omitempty"`
+	Processes []string   `json:"processes,omitempty"`
+	LockFile  string     `json:"lock_file,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+}
+
+// NewStandbyInhibitor creates a standby inhibitor from the configuration
+func NewStandbyInhibitor(fileSystem fs.FS, config cfg.Inhibit) (*StandbyInhibitor, error) {
+	windows := make([]timeWindow, len(config.Windows))
+	for i, window := range config.Windows {
+		var err error
+		windows[i], err = parseTimeWindow(window)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, process := range config.Processes {
+		if _, err := path.Match(process, ""); err != nil {
+			return nil, fmt.Errorf("invalid process name %q: %w", process, err)
+		}
+	}
+	return &StandbyInhibitor{
+		fs:      fileSystem,
+		config:  config,
+		windows: windows,
+	}, nil
+}
+
+// Inhibit prevents the standby for the duration
+func (i *StandbyInhibitor) Inhibit(duration time.Duration, reason string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.until = time.Now().Add(duration)
+	i.reason = reason
+}
+
+// SavedInhibit is an inhibit set from the API, kept in the state file
+type SavedInhibit struct {
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// saved returns the inhibit set by Inhibit to keep in the state file, if still active
+func (i *StandbyInhibitor) saved(now time.Time) (SavedInhibit, bool) {
+	until, reason := i.inhibited(now)
+	return SavedInhibit{Until: until, Reason: reason}, !until.IsZero()
+}
+
+// restore sets back the inhibit saved in the state file. An expired inhibit is ignored
+func (i *StandbyInhibitor) restore(saved SavedInhibit) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.until = saved.Until
+	i.reason = saved.Reason
+}
+
+// Release cancels the inhibit set by Inhibit
+func (i *StandbyInhibitor) Release() {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	i.until = time.Time{}
+	i.reason = ""
+}
+
+// Reason returns why the standby is inhibited at this time, or an empty string
+func (i *StandbyInhibitor) Reason(now time.Time) string {
+	if until, reason := i.inhibited(now); !until.IsZero() {
+		if reason == "" {
+			reason = "no reason given"
+		}
+		return fmt.Sprintf("inhibited until %s: %s", until.Format(time.DateTime), reason)
+	}
+	for index, window := range i.windows {
+		if window.contains(now) {
+			return "inside maintenance window " + i.config.Windows[index]
+		}
+	}
+	if i.config.LockFile != "" {
+		if _, err := fs.Stat(i.fs, fsPath(i.config.LockFile)); err == nil {
+			return "lock file " + i.config.LockFile + " exists"
+		}
+	}
+	if len(i.config.Processes) > 0 {
+		if process := i.findProcess(); process != "" {
+			return "process " + process + " is running"
+		}
+	}
+	return ""
+}
+
+// Report returns the configuration and the current state of the inhibitor,
+// or nil if there's nothing to report
+func (i *StandbyInhibitor) Report(now time.Time) *InhibitorReport {
+	until, reason := i.inhibited(now)
+	if until.IsZero() && len(i.windows) == 0 && len(i.config.Processes) == 0 && i.config.LockFile == "" {
+		return nil
+	}
+	report := &InhibitorReport{
+		Inhibited: i.Reason(now),
+		Windows:   i.config.Windows,
+		Processes: i.config.Processes,
+		LockFile:  i.config.LockFile,
+		Reason:    reason,
+	}
+	if !until.IsZero() {
+		report.Until = &until
+	}
+	return report
+}
+
+// inhibited returns the expiry and the reason of the inhibit set by Inhibit, if still active
+func (i *StandbyInhibitor) inhibited(now time.Time) (time.Time, string) {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	if i.until.IsZero() || !i.until.After(now) {
+		return time.Time{}, ""
+	}
+	return i.until, i.reason
+}
+
+// findProcess returns the name of the first running process matching the configuration
+func (i *StandbyInhibitor) findProcess() string {
+	entries, err := fs.ReadDir(i.fs, procPath)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(entry.Name()); err != nil {
+			continue
+		}
+		for _, name := range processNames(i.fs, path.Join(procPath, entry.Name())) {
+			if matchGlobs(i.config.Processes, name) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// processNames returns the names of the process: the kernel name (truncated to 15 characters)
+// and the base name of the executable from the command line
+func processNames(fileSystem fs.FS, processDir string) []string {
+	names := make([]string, 0, 2)
+	if comm := readTrimmed(fileSystem, path.Join(processDir, "comm")); comm != "" {
+		names = append(names, comm)
+	}
+	cmdline, err := fs.ReadFile(fileSystem, path.Join(processDir, "cmdline"))
+	if err == nil && len(cmdline) > 0 {
+		executable, _, _ := bytes.Cut(cmdline, []byte{0})
+		if len(executable) > 0 {
+			names = append(names, path.Base(string(executable)))
+		}
+	}
+	return names
+}
+
+// timeWindow is a time of day range, optionally restricted to some days of the week
+type timeWindow struct {
+	days  map[time.Weekday]bool // all days when empty
+	start int                   // minutes since midnight
+	end   int
+}
+
+// parseTimeWindow reads a window like "01:00-05:00" or "Sat,Sun 22:00-02:00"
+func parseTimeWindow(window string) (timeWindow, error) {
+	parsed := timeWindow{}
+	fields := strings.Fields(window)
+	if len(fields) == 0 || len(fields) > 2 {
+		return parsed, fmt.Errorf("invalid time window %q: expected [days] HH:MM-HH:MM", window)
+	}
+	if len(fields) == 2 {
+		parsed.days = make(map[time.Weekday]bool)
+		for day := range strings.SplitSeq(fields[0], ",") {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return parsed, fmt.Errorf("invalid time window %q: unknown day %q", window, day)
+			}
+			parsed.days[weekday] = true
+		}
+	}
+	start, end, found := strings.Cut(fields[len(fields)-1], "-")
+	if !found {
+		return parsed, fmt.Errorf("invalid time window %q: expected [days] HH:MM-HH:MM", window)
+	}
+	var err error
+	parsed.start, err = parseTimeOfDay(start)
+	if err != nil {
+		return parsed, fmt.Errorf("invalid time window %q: %w", window, err)
+	}
+	parsed.end, err = parseTimeOfDay(end)
+	if err != nil {
+		return parsed, fmt.Errorf("invalid time window %q: %w", window, err)
+	}
+	if parsed.start == parsed.end {
+		return parsed, fmt.Errorf("invalid time window %q: the window is empty", window)
+	}
+	return parsed, nil
+}
+
+func parseTimeOfDay(value string) (int, error) {
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// contains returns true when the time is inside the window. A window ending before it starts finishes the next day
+func (w timeWindow) contains(now time.Time) bool {
+	minutes := now.Hour()*60 + now.Minute()
+	if w.start <= w.end {
+		return w.onDay(now.Weekday()) && minutes >= w.start && minutes < w.end
+	}
+	if minutes >= w.start {
+		return w.onDay(now.Weekday())
+	}
+	if minutes < w.end {
+		// started the day before
+		return w.onDay((now.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+func (w timeWindow) onDay(day time.Weekday) bool {
+	return len(w.days) == 0 || w.days[day]
+}
+
+// inhibitKey is the key of an inhibit in the state file
+func inhibitKey(target, name string) string {
+	return target + "/" + name
+}
+
+// InhibitStandby prevents the disk or the pool from being put in standby mode for the duration.
+// A duration of zero releases the inhibit.
+func (g *Global) InhibitStandby(target, name string, duration time.Duration, reason string) error {
+	var inhibitor *StandbyInhibitor
+	switch target {
+	case InhibitTargetDisk:
+		disk, ok := g.Disks()[name]
+		if !ok {
+			return fmt.Errorf("disk %q not found", name)
+		}
+		inhibitor = disk.inhibitor
+	case InhibitTargetPool:
+		pool, ok := g.DiskPools[name]
+		if !ok {
+			return fmt.Errorf("pool %q not found", name)
+		}
+		inhibitor = pool.inhibitor
+	default:
+		return fmt.Errorf("unknown inhibit target %q: expected %q or %q", target, InhibitTargetDisk, InhibitTargetPool)
+	}
+	if duration < 0 {
+		return fmt.Errorf("invalid inhibit duration: %s", duration)
+	}
+	if duration == 0 {
+		clog.Infof("%s %s: standby inhibit released", target, name)
+		inhibitor.Release()
+	} else {
+		clog.Infof("%s %s: standby inhibited for %s: %s", target, name, duration, reason)
+		inhibitor.Inhibit(duration, reason)
+	}
+	// keep the inhibit after a restart
+	g.stateChangedNotify()
+	return nil
+}
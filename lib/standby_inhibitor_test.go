@@ -0,0 +1,138 @@
+package lib
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeWindow(t *testing.T) {
+	// 2026-10-17 is a Saturday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.Local)
+	}
+	fixtures := []struct {
+		window   string
+		time     time.Time
+		contains bool
+	}{
+		{"01:00-05:30", at(17, 1, 0), true},
+		{"01:00-05:30", at(17, 5, 29), true},
+		{"01:00-05:30", at(17, 5, 30), false},
+		{"01:00-05:30", at(17, 0, 59), false},
+		{"22:00-02:00", at(17, 23, 0), true},
+		{"22:00-02:00", at(17, 1, 59), true},
+		{"22:00-02:00", at(17, 2, 0), false},
+		{"Sat,Sun 01:00-05:00", at(17, 2, 0), true},
+		{"Sat,Sun 01:00-05:00", at(19, 2, 0), false},
+		// started on Sunday night
+		{"sun 22:00-02:00", at(19, 1, 0), true},
+		{"sun 22:00-02:00", at(18, 1, 0), false},
+		{"sun 22:00-02:00", at(18, 22, 30), true},
+	}
+	for _, fixture := range fixtures {
+		t.Run(fixture.window+" "+fixture.time.Format(time.DateTime), func(t *testing.T) {
+			window, err := parseTimeWindow(fixture.window)
+			require.NoError(t, err)
+			assert.Equal(t, fixture.contains, window.contains(fixture.time))
+		})
+	}
+}
+
+func TestInvalidTimeWindow(t *testing.T) {
+	for _, window := range []string{"", "01:00", "1am-2am", "Someday 01:00-02:00", "Mon 01:00-02:00 extra", "01:00-25:00", "02:00-02:00"} {
+		_, err := parseTimeWindow(window)
+		assert.Error(t, err, window)
+	}
+}
+
+func TestStandbyInhibitorRules(t *testing.T) {
+	fileSystem := os.DirFS("test_files/inhibitor")
+	now := time.Now()
+
+	inhibitor, err := NewStandbyInhibitor(fileSystem, cfg.Inhibit{})
+	require.NoError(t, err)
+	assert.Empty(t, inhibitor.Reason(now))
+	assert.Nil(t, inhibitor.Report(now))
+
+	inhibitor, err = NewStandbyInhibitor(fileSystem, cfg.Inhibit{Processes: []string{"rsync", "borg*"}, LockFile: "/run/other.lock"})
+	require.NoError(t, err)
+	assert.Empty(t, inhibitor.Reason(now))
+
+	// the kernel name of the process
+	inhibitor, err = NewStandbyInhibitor(fileSystem, cfg.Inhibit{Processes: []string{"restic"}})
+	require.NoError(t, err)
+	assert.Equal(t, "process restic is running", inhibitor.Reason(now))
+
+	// the name of the executable
+	inhibitor, err = NewStandbyInhibitor(fileSystem, cfg.Inhibit{Processes: []string{"python*"}})
+	require.NoError(t, err)
+	assert.Equal(t, "process python3 is running", inhibitor.Reason(now))
+
+	inhibitor, err = NewStandbyInhibitor(fileSystem, cfg.Inhibit{LockFile: "/run/backup.lock"})
+	require.NoError(t, err)
+	assert.Equal(t, "lock file /run/backup.lock exists", inhibitor.Reason(now))
+
+	inhibitor, err = NewStandbyInhibitor(fileSystem, cfg.Inhibit{Windows: []string{"00:00-23:59"}})
+	require.NoError(t, err)
+	assert.Equal(t, "inside maintenance window 00:00-23:59", inhibitor.Reason(time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)))
+
+	_, err = NewStandbyInhibitor(fileSystem, cfg.Inhibit{Windows: []string{"noon"}})
+	assert.Error(t, err)
+	_, err = NewStandbyInhibitor(fileSystem, cfg.Inhibit{Processes: []string{"[borg"}})
+	assert.Error(t, err)
+}
+
+func TestStandbyInhibitorExpiry(t *testing.T) {
+	inhibitor, err := NewStandbyInhibitor(os.DirFS("test_files/inhibitor"), cfg.Inhibit{})
+	require.NoError(t, err)
+
+	inhibitor.Inhibit(time.Hour, "backup")
+	now := time.Now()
+	assert.Contains(t, inhibitor.Reason(now), "backup")
+	report := inhibitor.Report(now)
+	require.NotNil(t, report)
+	assert.Equal(t, "backup", report.Reason)
+	require.NotNil(t, report.Until)
+
+	// expired
+	assert.Empty(t, inhibitor.Reason(now.Add(time.Hour)))
+	assert.Nil(t, inhibitor.Report(now.Add(time.Hour)))
+
+	inhibitor.Release()
+	assert.Empty(t, inhibitor.Reason(now))
+}
+
+func TestPoolStandbyInhibitedFromAPI(t *testing.T) {
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda", "second": "/dev/sda"})
+	for _, disk := range global.Disks() {
+		disk.lastActivity = time.Now().Add(-2 * time.Hour)
+	}
+	pool, err := NewDiskPool(global, "data", cfg.DiskPool{Disks: []string{"first", "second"}, StandbyAfter: "1h"})
+	require.NoError(t, err)
+	global.DiskPools["data"] = pool
+
+	require.NoError(t, global.InhibitStandby(InhibitTargetPool, "data", time.Hour, "backup"))
+	assert.Contains(t, global.Disks()["first"].standbyInhibitor(), "backup")
+	pool.standbyWhenIdle()
+	assert.Equal(t, 2, pool.CountActive())
+	status := global.Status()
+	assert.Contains(t, status.Pools["data"].StandbyInhibited, "backup")
+	assert.Contains(t, status.Disks["second"].StandbyInhibited, "backup")
+
+	// the pool is released but one disk is still inhibited
+	require.NoError(t, global.InhibitStandby(InhibitTargetPool, "data", 0, ""))
+	require.NoError(t, global.InhibitStandby(InhibitTargetDisk, "second", time.Hour, ""))
+	pool.standbyWhenIdle()
+	assert.Equal(t, 1, pool.CountActive())
+	assert.True(t, global.Disks()["second"].IsActive())
+
+	assert.Error(t, global.InhibitStandby(InhibitTargetDisk, "third", time.Hour, ""))
+	assert.Error(t, global.InhibitStandby(InhibitTargetPool, "other", time.Hour, ""))
+	assert.Error(t, global.InhibitStandby("zone", "data", time.Hour, ""))
+	assert.Error(t, global.InhibitStandby(InhibitTargetDisk, "first", -time.Hour, ""))
+}
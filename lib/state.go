@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/creativeprojects/clog"
+)
+
+// State is saved into a file so it survives a restart of the daemon
+type State struct {
+	SavedAt        time.Time                `json:"saved_at"`
+	SmartHistory   map[string][]SmartSample `json:"smart_history,omitempty"`
+	SpinCounters   map[string]SpinCounters  `json:"spin_counters,omitempty"`
+	LastActivity   map[string]time.Time     `json:"last_activity,omitempty"`
+	SensorAverages map[string][]int         `json:"sensor_averages,omitempty"` // indexed by "zone/sensor"
+	ZoneSpeeds     map[string]int           `json:"zone_speeds,omitempty"`
+	Inhibits       map[string]SavedInhibit  `json:"inhibits,omitempty"` // indexed by "disk/name" or "pool/name"
+}
+
+const (
+	defaultStateSaveEvery = 5 * time.Minute
+	defaultStateMaxAge    = 1 * time.Hour
+)
+
+func newState() *State {
+	return &State{
+		SmartHistory:   make(map[string][]SmartSample),
+		SpinCounters:   make(map[string]SpinCounters),
+		LastActivity:   make(map[string]time.Time),
+		SensorAverages: make(map[string][]int),
+		ZoneSpeeds:     make(map[string]int),
+		Inhibits:       make(map[string]SavedInhibit),
+	}
+}
+
+// recent returns true when the state has been saved less than maxAge ago.
+// Volatile values like the last activity or the fan speeds are only restored from a recent state.
+func (s *State) recent(maxAge time.Duration) bool {
+	return !s.SavedAt.IsZero() && time.Since(s.SavedAt) <= maxAge
+}
+
+// loadState reads the state file. It returns an empty state if the file doesn't exist yet
+func loadState(filename string) (*State, error) {
+	state := newState()
+	if filename == "" {
+		return state, nil
+	}
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return state, nil
+		}
+		return state, err
+	}
+	err = json.Unmarshal(content, state)
+	if err != nil {
+		return newState(), err
+	}
+	return state, nil
+}
+
+// save writes the state into a temporary file first, so we never leave a half written file behind
+func (s *State) save(filename string) error {
+	s.SavedAt = time.Now()
+	content, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(filename), 0o755)
+	if err != nil {
+		return err
+	}
+	temp := filename + ".tmp"
+	err = os.WriteFile(temp, content, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(temp, filename)
+}
+
+// SaveState collects the state of all the components and saves it into the state file (if configured)
+func (g *Global) SaveState() error {
+	if g.config.StateFile == "" {
+		return nil
+	}
+	g.stateMutex.Lock()
+	defer g.stateMutex.Unlock()
+
+	now := time.Now()
+	state := newState()
+	for name, disk := range g.Disks() {
+		history := disk.SmartHistory()
+		if len(history) > 0 {
+			state.SmartHistory[name] = history
+		}
+		state.SpinCounters[name] = disk.SpinCounters()
+		state.LastActivity[name] = disk.LastActivity()
+		if inhibit, ok := disk.inhibitor.saved(now); ok {
+			state.Inhibits[inhibitKey(InhibitTargetDisk, name)] = inhibit
+		}
+	}
+	for name, pool := range g.DiskPools {
+		if inhibit, ok := pool.inhibitor.saved(now); ok {
+			state.Inhibits[inhibitKey(InhibitTargetPool, name)] = inhibit
+		}
+	}
+	if g.FanControl != nil {
+		for zoneName, zone := range g.FanControl.Zones {
+			state.ZoneSpeeds[zoneName] = zone.CurrentFanSpeed()
+			for sensorName, sensor := range zone.Sensors {
+				state.SensorAverages[zoneName+"/"+sensorName] = sensor.Values()
+			}
+		}
+	}
+	clog.Debugf("saving state into %q", g.config.StateFile)
+	return state.save(g.config.StateFile)
+}
+
+// StartStateSaver saves the state at regular intervals, so it's not lost if the daemon is killed,
+// and after each change requested with stateChangedNotify
+func (g *Global) StartStateSaver() {
+	if g.config.StateFile == "" {
+		return
+	}
+	go func() {
+		timer := time.NewTimer(g.stateSaveEvery)
+		for {
+			select {
+			case <-g.ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			case <-g.stateChanged:
+				timer.Stop()
+			}
+			timer.Reset(g.stateSaveEvery)
+			err := g.SaveState()
+			if err != nil {
+				clog.Errorf("cannot save state: %s", err)
+			}
+		}
+	}()
+}
+
+// stateChangedNotify asks the state saver to save the state now. It never blocks the caller
+func (g *Global) stateChangedNotify() {
+	select {
+	case g.stateChanged <- struct{}{}:
+	default:
+		// a save is already pending
+	}
+}
+
+// restoreFanControl sets the fan speeds and the sensor averages back from a recent state
+func (g *Global) restoreFanControl() {
+	if g.FanControl == nil || g.state == nil || !g.state.recent(g.stateMaxAge) {
+		return
+	}
+	for zoneName, zone := range g.FanControl.Zones {
+		if speed, ok := g.state.ZoneSpeeds[zoneName]; ok {
+			zone.restoreSpeed(speed)
+		}
+		for sensorName, sensor := range zone.Sensors {
+			sensor.restoreValues(g.state.SensorAverages[zoneName+"/"+sensorName])
+		}
+	}
+}
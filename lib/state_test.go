@@ -0,0 +1,117 @@
+package lib
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func stateTestConfig(t *testing.T) cfg.Config {
+	t.Helper()
+	return cfg.Config{
+		StateFile: filepath.Join(t.TempDir(), "state.json"),
+		Disks: map[string]cfg.Disk{
+			"first": {Device: "/dev/disk/by-id/ata-ST2000DM001-first"},
+		},
+		FanControl: cfg.FanControl{
+			SetCommand: "true",
+			Zones: map[string]cfg.FanZone{
+				"cpu": {
+					RunEvery: "10s",
+					Sensors: map[string]cfg.Sensor{
+						"cpu": {Average: "30s"},
+					},
+				},
+			},
+		},
+	}
+}
+
+func writeTestState(t *testing.T, filename string, state *State) {
+	t.Helper()
+	content, err := json.Marshal(state)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filename, content, 0o644))
+}
+
+func TestSaveAndRestoreRecentState(t *testing.T) {
+	config := stateTestConfig(t)
+	global, err := NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+
+	lastActivity := time.Now().Add(-40 * time.Minute).Round(0)
+	global.Disks()["first"].restoreLastActivity(lastActivity)
+	zone := global.FanControl.Zones["cpu"]
+	for _, temperature := range []int{40, 42, 44, 46} {
+		zone.Sensors["cpu"].average(temperature)
+	}
+	zone.RequestFanSpeed("cpu", 60, false, false)
+	require.NoError(t, global.SaveState())
+
+	// new instance
+	global, err = NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	assert.True(t, lastActivity.Equal(global.Disks()["first"].LastActivity()))
+	zone = global.FanControl.Zones["cpu"]
+	assert.Equal(t, []int{42, 44, 46}, zone.Sensors["cpu"].Values())
+	assert.Equal(t, 60, zone.restoredSpeed)
+}
+
+func TestOldStateIsOnlyPartiallyRestored(t *testing.T) {
+	config := stateTestConfig(t)
+	lastActivity := time.Now().Add(-3 * time.Hour)
+	state := newState()
+	state.SavedAt = time.Now().Add(-2 * time.Hour)
+	state.LastActivity["first"] = lastActivity
+	state.SpinCounters["first"] = SpinCounters{SpinUps: 3}
+	state.ZoneSpeeds["cpu"] = 60
+	state.SensorAverages["cpu/cpu"] = []int{42, 44, 46}
+	writeTestState(t, config.StateFile, state)
+
+	global, err := NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	// counters are always restored
+	assert.Equal(t, int64(3), global.Disks()["first"].SpinCounters().SpinUps)
+	// but not the volatile values
+	assert.True(t, global.Disks()["first"].LastActivity().After(lastActivity))
+	zone := global.FanControl.Zones["cpu"]
+	assert.Empty(t, zone.Sensors["cpu"].Values())
+	assert.Zero(t, zone.restoredSpeed)
+
+	// unless the maximum age is long enough
+	config.StateMaxAge = "3h"
+	global, err = NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	assert.True(t, lastActivity.Equal(global.Disks()["first"].LastActivity()))
+	assert.Equal(t, 60, global.FanControl.Zones["cpu"].restoredSpeed)
+}
+
+func TestSaveAndRestoreInhibits(t *testing.T) {
+	config := stateTestConfig(t)
+	config.DiskPools = map[string]cfg.DiskPool{"data": {Disks: []string{"first"}}}
+	global, err := NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	require.NoError(t, global.InhibitStandby(InhibitTargetDisk, "first", time.Hour, "backup"))
+	require.NoError(t, global.InhibitStandby(InhibitTargetPool, "data", time.Hour, "scrub"))
+	require.NoError(t, global.SaveState())
+
+	// new instance
+	global, err = NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	assert.Contains(t, global.Disks()["first"].inhibitor.Reason(time.Now()), "backup")
+	assert.Contains(t, global.DiskPools["data"].inhibitor.Reason(time.Now()), "scrub")
+
+	// released inhibits are not saved
+	require.NoError(t, global.InhibitStandby(InhibitTargetDisk, "first", 0, ""))
+	require.NoError(t, global.SaveState())
+	global, err = NewGlobalFS(config, os.DirFS("fs_test_files"))
+	require.NoError(t, err)
+	assert.Empty(t, global.Disks()["first"].inhibitor.Reason(time.Now()))
+	assert.Contains(t, global.DiskPools["data"].inhibitor.Reason(time.Now()), "scrub")
+}
@@ -0,0 +1,133 @@
+package lib
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Status is a snapshot of the state of the hardware, suitable for JSON encoding
+type Status struct {
+	Time  time.Time             `json:"time"`
+	Disks map[string]DiskReport `json:"disks"`
+	Pools map[string]PoolReport `json:"pools,omitempty"`
+	// heartbeats of the long-running loops
+	Health map[string]ComponentHealth `json:"health,omitempty"`
+	Alerts []Alert                    `json:"alerts,omitempty"`
+	// sensors and disks above their critical temperature, with the emergency actions taken
+	Critical []string `json:"critical,omitempty"`
+}
+
+// DiskReport is the status of a disk
+type DiskReport struct {
+	Device      string       `json:"device"`
+	Present     bool         `json:"present"`
+	Pool        string       `json:"pool,omitempty"`
+	Active      bool         `json:"active"`
+	Temperature int          `json:"temperature,omitempty"`
+	Health      DiskHealth   `json:"health"`
+	Spins       SpinCounters `json:"spins"`
+	// reason why the disk won't be put in standby mode right now (inhibitor of the disk or of its pool)
+	StandbyInhibited string           `json:"standby_inhibited,omitempty"`
+	Inhibitor        *InhibitorReport `json:"inhibitor,omitempty"`
+}
+
+// PoolReport is the status of a disk pool
+type PoolReport struct {
+	Disks  []string `json:"disks"`
+	Active int      `json:"active"`
+	ZFS    *Zpool   `json:"zfs,omitempty"`
+	// reason why the disks of the pool won't be put in standby mode right now
+	StandbyInhibited string           `json:"standby_inhibited,omitempty"`
+	Inhibitor        *InhibitorReport `json:"inhibitor,omitempty"`
+}
+
+// Status returns the current state of the hardware
+func (g *Global) Status() Status {
+	disks := g.Disks()
+	status := Status{
+		Time:  time.Now(),
+		Disks: make(map[string]DiskReport, len(disks)),
+	}
+	status.Health = g.Health.Report(status.Time)
+	if g.Alerting != nil {
+		status.Alerts = g.Alerting.Firing()
+	}
+	for source, policy := range g.CriticalPolicies {
+		if policy.Triggered() {
+			status.Critical = append(status.Critical, source)
+		}
+	}
+	slices.Sort(status.Critical)
+	for name, disk := range disks {
+		report := DiskReport{
+			Device:  disk.Device(),
+			Present: disk.Present(),
+			Pool:    disk.Pool(),
+			Active:  disk.IsActive(),
+			Health:  disk.Health(),
+			Spins:   disk.SpinCounters(),
+		}
+		report.StandbyInhibited = disk.standbyInhibitor()
+		report.Inhibitor = disk.inhibitor.Report(status.Time)
+		if disk.TemperatureAvailable() {
+			report.Temperature = disk.Temperature()
+		}
+		status.Disks[name] = report
+	}
+	if len(g.DiskPools) > 0 {
+		status.Pools = make(map[string]PoolReport, len(g.DiskPools))
+	}
+	for name, pool := range g.DiskPools {
+		members := pool.members()
+		report := PoolReport{
+			Disks:  make([]string, len(members)),
+			Active: pool.CountActive(),
+		}
+		for i, disk := range members {
+			report.Disks[i] = disk.Name
+		}
+		report.StandbyInhibited = pool.standbyInhibitor()
+		report.Inhibitor = pool.inhibitor.Report(status.Time)
+		if zpool, ok := pool.Zpool(); ok {
+			report.ZFS = &zpool
+		}
+		status.Pools[name] = report
+	}
+	return status
+}
+
+// Summary returns a one-line overview: fan speed of each zone, hottest sensor of the fan control and number of active disks
+func (g *Global) Summary() string {
+	parts := make([]string, 0, 3)
+	if g.FanControl != nil && len(g.FanControl.Zones) > 0 {
+		speeds := make([]string, 0, len(g.FanControl.Zones))
+		hottest, hottestTemperature := "", 0
+		for _, name := range slices.Sorted(maps.Keys(g.FanControl.Zones)) {
+			zone := g.FanControl.Zones[name]
+			speeds = append(speeds, fmt.Sprintf("%s %d%%", name, zone.CurrentFanSpeed()))
+			for _, sensorName := range slices.Sorted(maps.Keys(zone.Sensors)) {
+				state, ok := zone.Sensors[sensorName].State()
+				if ok && (hottest == "" || state.Temperature > hottestTemperature) {
+					hottest, hottestTemperature = sensorName, state.Temperature
+				}
+			}
+		}
+		parts = append(parts, "fans "+strings.Join(speeds, ", "))
+		if hottest != "" {
+			parts = append(parts, fmt.Sprintf("hottest %s %d°C", hottest, hottestTemperature))
+		}
+	}
+	if disks := g.Disks(); len(disks) > 0 {
+		active := 0
+		for _, disk := range disks {
+			if disk.IsActive() {
+				active++
+			}
+		}
+		parts = append(parts, fmt.Sprintf("%d/%d disks active", active, len(disks)))
+	}
+	return strings.Join(parts, "; ")
+}
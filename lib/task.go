@@ -2,10 +2,10 @@ package lib
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"time"
 
-	"github.com/creativeprojects/clog"
 	"github.com/creativeprojects/hardware-events/cfg"
 	"github.com/creativeprojects/hardware-events/lib/simulation"
 )
@@ -15,6 +15,7 @@ type Task struct {
 	Name          string
 	Command       CommandRunner
 	InputTemplate string
+	log           Logger
 }
 
 func NewTask(global *Global, name string, config cfg.Task, simulate bool) (*Task, error) {
@@ -34,7 +35,7 @@ func NewTask(global *Global, name string, config cfg.Task, simulate bool) (*Task
 			return nil, err
 		}
 	} else {
-		command, err = NewCommand(config.Command, "", timeout) // an error can only be thrown by a wrong regexp
+		command, err = newNamedCommand(name, config.Command, "", timeout) // an error can only be thrown by a wrong regexp
 		if err != nil {
 			return nil, err
 		}
@@ -44,21 +45,35 @@ func NewTask(global *Global, name string, config cfg.Task, simulate bool) (*Task
 		Name:          name,
 		Command:       command,
 		InputTemplate: config.Stdin.Template,
+		log:           NewLogger("task", name),
 	}, nil
 }
 
 func (t *Task) Execute() error {
+	return t.execute(nil)
+}
+
+// ExecuteEvent runs the task with the event details available as variables
+func (t *Task) ExecuteEvent(event Event) error {
+	return t.execute(event.expandEnv)
+}
+
+func (t *Task) execute(expandEnv func(string) string) error {
 	var stdin io.Reader
 	if t.InputTemplate != "" {
+		templ, ok := t.global.Templates[t.InputTemplate]
+		if !ok || t.global.templ == nil {
+			return fmt.Errorf("task %s: template %q not found", t.Name, t.InputTemplate)
+		}
 		input := &bytes.Buffer{}
-		err := t.global.templ.ExecuteTemplate(input, t.global.Templates[t.InputTemplate].ID, t.global)
+		err := t.global.templ.ExecuteTemplate(input, templ.ID, t.global)
 		if err != nil {
 			return err
 		}
-		clog.Tracef("template %s:\n%s", t.InputTemplate, input.String())
+		t.log.Tracef("template %s:\n%s", t.InputTemplate, input.String())
 		stdin = input
 	}
-	_, err := t.Command.Run(stdin, nil)
+	_, err := t.Command.Run(stdin, expandEnv)
 	return err
 }
 
@@ -2,20 +2,31 @@ package lib
 
 import (
 	"context"
+	"time"
 
 	"go.opentelemetry.io/otel/attribute"
 	api "go.opentelemetry.io/otel/metric"
 	"go.opentelemetry.io/otel/sdk/metric"
 )
 
-const meterName = "hardware-events"
+const (
+	meterName = "hardware-events"
+	// sensorMaxAge is how long a value read by the fan control is reported instead of reading the sensor again
+	sensorMaxAge = 10 * time.Second
+)
 
 type Telemetry struct {
-	provider *metric.MeterProvider
+	provider       *metric.MeterProvider
+	removeObserver func()
 }
 
-func NewTelemetry(global *Global, exporter metric.Reader) (*Telemetry, error) {
-	provider := metric.NewMeterProvider(metric.WithReader(exporter))
+// NewTelemetry registers all the metrics on a provider exporting to all the readers
+func NewTelemetry(global *Global, readers ...metric.Reader) (*Telemetry, error) {
+	options := make([]metric.Option, len(readers))
+	for i, reader := range readers {
+		options[i] = metric.WithReader(reader)
+	}
+	provider := metric.NewMeterProvider(options...)
 
 	meter := provider.Meter(meterName)
 
@@ -24,6 +35,33 @@ func NewTelemetry(global *Global, exporter metric.Reader) (*Telemetry, error) {
 		return nil, err
 	}
 
+	err = setupDiskHealth(meter, global.Disks)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setupDiskSpins(meter, global.Disks)
+	if err != nil {
+		return nil, err
+	}
+
+	err = setupDiskIO(meter, global.Disks)
+	if err != nil {
+		return nil, err
+	}
+
+	if global.ZFS != nil {
+		err = setupZFS(meter, global.ZFS)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = setupSensors(meter, func() map[string]int { return global.SensorTemperatures(sensorMaxAge) })
+	if err != nil {
+		return nil, err
+	}
+
 	if global.FanControl != nil {
 		err := setupFanZones(meter, global.FanControl.Zones)
 		if err != nil {
@@ -31,21 +69,28 @@ func NewTelemetry(global *Global, exporter metric.Reader) (*Telemetry, error) {
 		}
 	}
 
+	observer, err := setupCommands(meter)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Telemetry{
-		provider: provider,
+		provider:       provider,
+		removeObserver: AddCommandObserver(observer),
 	}, nil
 }
 
 func (t *Telemetry) Shutdown(ctx context.Context) error {
+	t.removeObserver()
 	return t.provider.Shutdown(ctx)
 }
 
-func setupDisks(meter api.Meter, disks map[string]*Disk) error {
+func setupDisks(meter api.Meter, disks func() map[string]*Disk) error {
 	_, err := meter.Int64ObservableGauge("disk_temperature",
 		api.WithDescription("Disk internal temperature sensor"),
 		api.WithUnit("degree Celsius"),
 		api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
-			for _, disk := range disks {
+			for _, disk := range disks() {
 				if disk == nil {
 					return nil
 				}
@@ -60,10 +105,30 @@ func setupDisks(meter api.Meter, disks map[string]*Disk) error {
 		return err
 	}
 
+	_, err = meter.Int64ObservableGauge("disk_present",
+		api.WithDescription("Device present: 0 when the disk has been removed, 1 when present"),
+		api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+			for _, disk := range disks() {
+				if disk == nil {
+					continue
+				}
+				var value int64 = 0
+				if disk.Present() {
+					value = 1
+				}
+				fo.Observe(value, api.WithAttributeSet(attribute.NewSet(diskAttributes(disk)...)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
 	_, err = meter.Int64ObservableGauge("disk_active",
 		api.WithDescription("Active device: 0 when inactive, 1 when active"),
 		api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
-			for _, disk := range disks {
+			for _, disk := range disks() {
 				if disk == nil {
 					return nil
 				}
@@ -82,6 +147,252 @@ func setupDisks(meter api.Meter, disks map[string]*Disk) error {
 	return nil
 }
 
+func setupDiskHealth(meter api.Meter, disks func() map[string]*Disk) error {
+	gauges := []struct {
+		name        string
+		description string
+		unit        string
+		value       func(data *SmartData) int64
+	}{
+		{"disk_power_on_hours", "Disk power-on time", "hours", func(data *SmartData) int64 { return int64(data.PowerOnHours) }},
+		{"disk_reallocated_sectors", "Disk reallocated sectors count", "", func(data *SmartData) int64 { return data.ReallocatedSectors }},
+		{"disk_pending_sectors", "Disk current pending sectors count", "", func(data *SmartData) int64 { return data.PendingSectors }},
+		{"disk_crc_errors", "Disk interface CRC errors count", "", func(data *SmartData) int64 { return data.CRCErrors }},
+		{"disk_critical_warning", "NVMe critical warning bit field", "", func(data *SmartData) int64 { return int64(data.CriticalWarning) }},
+		{"disk_healthy", "SMART overall health: 0 when failing, 1 when passed", "", func(data *SmartData) int64 {
+			if data.Healthy {
+				return 1
+			}
+			return 0
+		}},
+	}
+	_, err := meter.Int64ObservableGauge("disk_health_alerts",
+		api.WithDescription("Number of health alerts from the last SMART check"),
+		api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+			for _, disk := range disks() {
+				if disk == nil || !disk.HasSmart() {
+					continue
+				}
+				health := disk.Health()
+				if health.LastCheck.IsZero() {
+					continue
+				}
+				fo.Observe(int64(len(health.Alerts)), api.WithAttributeSet(attribute.NewSet(diskAttributes(disk)...)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, gauge := range gauges {
+		_, err := meter.Int64ObservableGauge(gauge.name,
+			api.WithDescription(gauge.description),
+			api.WithUnit(gauge.unit),
+			api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+				for _, disk := range disks() {
+					if disk == nil || !disk.HasSmart() {
+						continue
+					}
+					// data collected every smart_every, instead of running smartctl at each collection
+					if data := disk.Health().Smart; data != nil {
+						fo.Observe(gauge.value(data), api.WithAttributeSet(attribute.NewSet(diskAttributes(disk)...)))
+					}
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setupDiskSpins(meter api.Meter, disks func() map[string]*Disk) error {
+	_, err := meter.Int64ObservableCounter("disk_spin_ups",
+		api.WithDescription("Number of transitions from standby or sleeping to active"),
+		api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+			for _, disk := range disks() {
+				if disk == nil {
+					continue
+				}
+				counters := disk.SpinCounters()
+				fo.Observe(counters.SpinUpsByDaemon, api.WithAttributeSet(attribute.NewSet(spinAttributes(disk, "daemon")...)))
+				fo.Observe(counters.SpinUps-counters.SpinUpsByDaemon, api.WithAttributeSet(attribute.NewSet(spinAttributes(disk, "external")...)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.Int64ObservableCounter("disk_spin_downs",
+		api.WithDescription("Number of transitions from active to standby or sleeping"),
+		api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+			for _, disk := range disks() {
+				if disk == nil {
+					continue
+				}
+				counters := disk.SpinCounters()
+				fo.Observe(counters.SpinDownsByDaemon, api.WithAttributeSet(attribute.NewSet(spinAttributes(disk, "daemon")...)))
+				fo.Observe(counters.SpinDowns-counters.SpinDownsByDaemon, api.WithAttributeSet(attribute.NewSet(spinAttributes(disk, "external")...)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.Int64ObservableCounter("disk_standby_actions",
+		api.WithDescription("Number of times a disk was due for standby, by result: succeeded, failed, inhibited or budget_spent"),
+		api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+			for _, disk := range disks() {
+				if disk == nil {
+					continue
+				}
+				for result, count := range disk.StandbyActions() {
+					attributes := append(diskAttributes(disk), attribute.KeyValue{Key: "result", Value: attribute.StringValue(result)})
+					fo.Observe(count, api.WithAttributeSet(attribute.NewSet(attributes...)))
+				}
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = meter.Int64ObservableGauge("disk_spin_ups_last_day",
+		api.WithDescription("Number of spin ups during the last 24 hours"),
+		api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+			for _, disk := range disks() {
+				if disk == nil {
+					continue
+				}
+				fo.Observe(int64(disk.SpinUpsLastDay()), api.WithAttributeSet(attribute.NewSet(diskAttributes(disk)...)))
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func setupDiskIO(meter api.Meter, disks func() map[string]*Disk) error {
+	gauges := []struct {
+		name        string
+		description string
+		unit        string
+		value       func(rates DiskIORates) float64
+	}{
+		{"disk_read_bytes_per_second", "Disk read throughput", "bytes/s", func(rates DiskIORates) float64 { return rates.ReadBytesPerSecond }},
+		{"disk_write_bytes_per_second", "Disk write throughput", "bytes/s", func(rates DiskIORates) float64 { return rates.WriteBytesPerSecond }},
+		{"disk_read_iops", "Disk read requests per second", "requests/s", func(rates DiskIORates) float64 { return rates.ReadIOPS }},
+		{"disk_write_iops", "Disk write requests per second", "requests/s", func(rates DiskIORates) float64 { return rates.WriteIOPS }},
+		{"disk_await", "Average time for read and write requests to be served", "ms", func(rates DiskIORates) float64 { return rates.AverageAwait }},
+		{"disk_utilisation", "Percentage of time the disk was busy doing I/O", "percent", func(rates DiskIORates) float64 { return rates.Utilisation }},
+	}
+	for _, gauge := range gauges {
+		_, err := meter.Float64ObservableGauge(gauge.name,
+			api.WithDescription(gauge.description),
+			api.WithUnit(gauge.unit),
+			api.WithFloat64Callback(func(ctx context.Context, fo api.Float64Observer) error {
+				for _, disk := range disks() {
+					if disk == nil {
+						continue
+					}
+					fo.Observe(gauge.value(disk.IORates()), api.WithAttributeSet(attribute.NewSet(diskAttributes(disk)...)))
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	counters := []struct {
+		name        string
+		description string
+		unit        string
+		value       func(counters DiskIOCounters) int64
+	}{
+		{"disk_read_bytes", "Total bytes read from the disk", "bytes", func(counters DiskIOCounters) int64 { return counters.ReadBytes }},
+		{"disk_written_bytes", "Total bytes written to the disk", "bytes", func(counters DiskIOCounters) int64 { return counters.WrittenBytes }},
+		{"disk_discards", "Total discard requests completed", "", func(counters DiskIOCounters) int64 { return counters.Discards }},
+		{"disk_discarded_bytes", "Total bytes discarded", "bytes", func(counters DiskIOCounters) int64 { return counters.DiscardedBytes }},
+		{"disk_flushes", "Total flush requests completed", "", func(counters DiskIOCounters) int64 { return counters.Flushes }},
+	}
+	for _, counter := range counters {
+		_, err := meter.Int64ObservableCounter(counter.name,
+			api.WithDescription(counter.description),
+			api.WithUnit(counter.unit),
+			api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+				for _, disk := range disks() {
+					if disk == nil {
+						continue
+					}
+					fo.Observe(counter.value(disk.IOCounters()), api.WithAttributeSet(attribute.NewSet(diskAttributes(disk)...)))
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setupZFS(meter api.Meter, zfs *ZFS) error {
+	gauges := []struct {
+		name        string
+		description string
+		unit        string
+		value       func(pool Zpool) int64
+	}{
+		{"zfs_pool_healthy", "ZFS pool state: 1 when online, 0 otherwise", "", func(pool Zpool) int64 {
+			if pool.Healthy() {
+				return 1
+			}
+			return 0
+		}},
+		{"zfs_pool_scan_in_progress", "Scrub or resilver in progress: 1 when running, 0 otherwise", "", func(pool Zpool) int64 {
+			if pool.ScanInProgress() {
+				return 1
+			}
+			return 0
+		}},
+		{"zfs_pool_scan_progress", "Progress of the running scrub or resilver", "percent", func(pool Zpool) int64 { return int64(pool.ScanProgress) }},
+		{"zfs_pool_size", "ZFS pool size", "bytes", func(pool Zpool) int64 { return pool.Size }},
+		{"zfs_pool_allocated", "ZFS pool allocated space", "bytes", func(pool Zpool) int64 { return pool.Allocated }},
+		{"zfs_pool_free", "ZFS pool free space", "bytes", func(pool Zpool) int64 { return pool.Free }},
+		{"zfs_pool_capacity", "ZFS pool used capacity", "percent", func(pool Zpool) int64 { return int64(pool.Capacity) }},
+	}
+	for _, gauge := range gauges {
+		_, err := meter.Int64ObservableGauge(gauge.name,
+			api.WithDescription(gauge.description),
+			api.WithUnit(gauge.unit),
+			api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+				for _, pool := range zfs.Pools() {
+					fo.Observe(gauge.value(pool), api.WithAttributeSet(attribute.NewSet(attribute.KeyValue{Key: "zpool", Value: attribute.StringValue(pool.Name)})))
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func setupFanZones(meter api.Meter, zones map[string]*Zone) error {
 	_, err := meter.Int64ObservableGauge("fan_speed",
 		api.WithDescription("Fan speed from 0 to 100%"),
@@ -100,19 +411,115 @@ func setupFanZones(meter api.Meter, zones map[string]*Zone) error {
 	if err != nil {
 		return err
 	}
+
+	gauges := []struct {
+		name        string
+		description string
+		unit        string
+		value       func(state SensorState) int64
+	}{
+		{"zone_sensor_temperature", "Last temperature read by the zone sensor", "degree Celsius", func(state SensorState) int64 { return int64(state.Temperature) }},
+		{"zone_sensor_average_temperature", "Average temperature used by the rules of the zone sensor", "degree Celsius", func(state SensorState) int64 { return int64(state.Average) }},
+		{"zone_sensor_rule", "Index of the rule matched in the configuration, -1 when no rule matched", "", func(state SensorState) int64 { return int64(state.Rule) }},
+	}
+	for _, gauge := range gauges {
+		_, err := meter.Int64ObservableGauge(gauge.name,
+			api.WithDescription(gauge.description),
+			api.WithUnit(gauge.unit),
+			api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+				for _, zone := range zones {
+					for _, sensor := range zone.Sensors {
+						if state, ok := sensor.State(); ok {
+							fo.Observe(gauge.value(state), api.WithAttributeSet(attribute.NewSet(zoneSensorAttributes(zone, sensor.Name)...)))
+						}
+					}
+				}
+				return nil
+			}),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = meter.Int64ObservableGauge("zone_fan_speed_bid",
+		api.WithDescription("Fan speed requested by the zone sensor: the zone runs at the highest bid"),
+		api.WithUnit("percent"),
+		api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+			for _, zone := range zones {
+				for sensorName, bid := range zone.Bids() {
+					fo.Observe(int64(bid), api.WithAttributeSet(attribute.NewSet(zoneSensorAttributes(zone, sensorName)...)))
+				}
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
+func setupSensors(meter api.Meter, readings func() map[string]int) error {
+	_, err := meter.Int64ObservableGauge("sensor_temperature",
+		api.WithDescription("Temperature of the sensor"),
+		api.WithUnit("degree Celsius"),
+		api.WithInt64Callback(func(ctx context.Context, fo api.Int64Observer) error {
+			for name, value := range readings() {
+				fo.Observe(int64(value), api.WithAttributeSet(attribute.NewSet(attribute.KeyValue{Key: "sensor", Value: attribute.StringValue(name)})))
+			}
+			return nil
+		}),
+	)
+	return err
+}
+
+// setupCommands returns the observer recording the runs of all the commands
+func setupCommands(meter api.Meter) (CommandObserver, error) {
+	runs, err := meter.Int64Counter("command_runs",
+		api.WithDescription("Number of commands run, by task or sensor name and exit status"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	durations, err := meter.Float64Histogram("command_duration",
+		api.WithDescription("Duration of the commands, by task or sensor name and exit status"),
+		api.WithUnit("s"),
+		api.WithExplicitBucketBoundaries(0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return func(name string, exitStatus int, duration time.Duration) {
+		attributes := api.WithAttributeSet(attribute.NewSet(
+			attribute.KeyValue{Key: "name", Value: attribute.StringValue(name)},
+			attribute.KeyValue{Key: "exit_status", Value: attribute.IntValue(exitStatus)},
+		))
+		ctx := context.Background()
+		runs.Add(ctx, 1, attributes)
+		durations.Record(ctx, duration.Seconds(), attributes)
+	}, nil
+}
+
+func zoneSensorAttributes(zone *Zone, sensorName string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		{Key: "zone", Value: attribute.StringValue(zone.Name)},
+		{Key: "sensor", Value: attribute.StringValue(sensorName)},
+	}
+}
+
 func diskAttributes(disk *Disk) []attribute.KeyValue {
-	attributes := []attribute.KeyValue{
+	// the pool is always there, even when empty, so each metric keeps the same attributes
+	return []attribute.KeyValue{
 		{Key: "name", Value: attribute.StringValue(disk.Name)},
-		{Key: "device", Value: attribute.StringValue(disk.Device)},
-	}
-	if disk.Pool != "" {
-		attributes = append(attributes, attribute.KeyValue{
-			Key:   "pool",
-			Value: attribute.StringValue(disk.Pool),
-		})
+		{Key: "device", Value: attribute.StringValue(disk.Device())},
+		{Key: "pool", Value: attribute.StringValue(disk.Pool())},
 	}
-	return attributes
+}
+
+func spinAttributes(disk *Disk, initiated string) []attribute.KeyValue {
+	return append(diskAttributes(disk), attribute.KeyValue{
+		Key:   "initiated",
+		Value: attribute.StringValue(initiated),
+	})
 }
@@ -0,0 +1,155 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+type fixedSensor int
+
+func (s fixedSensor) Get(func(string) string) (int, error) {
+	return int(s), nil
+}
+
+// collectMetrics returns the data points of all the metrics, indexed by name
+func collectMetrics(t *testing.T, reader *metric.ManualReader) map[string]metricdata.Aggregation {
+	t.Helper()
+	data := metricdata.ResourceMetrics{}
+	require.NoError(t, reader.Collect(context.Background(), &data))
+	metrics := make(map[string]metricdata.Aggregation)
+	for _, scope := range data.ScopeMetrics {
+		for _, metric := range scope.Metrics {
+			metrics[metric.Name] = metric.Data
+		}
+	}
+	return metrics
+}
+
+// gaugeValue returns the value of the data point having the attribute
+func gaugeValue(t *testing.T, data metricdata.Aggregation, key, value string) int64 {
+	t.Helper()
+	gauge, ok := data.(metricdata.Gauge[int64])
+	require.True(t, ok)
+	for _, point := range gauge.DataPoints {
+		if found, ok := point.Attributes.Value(attribute.Key(key)); ok && found.AsString() == value {
+			return point.Value
+		}
+	}
+	t.Fatalf("no data point with %s=%s", key, value)
+	return 0
+}
+
+func TestZoneAndSensorMetrics(t *testing.T) {
+	global := &Global{
+		disks:              make(map[string]*Disk),
+		TemperatureSensors: map[string]SensorGetter{"cpu": fixedSensor(55), "ambient": fixedSensor(25), "nvme": fixedSensor(35)},
+		sensorReadings:     make(map[string]sensorValue),
+	}
+	rules := []cfg.SensorRule{
+		{Temperature: cfg.FromTo{From: 20, To: 40}, Fan: cfg.SetFromTo{Set: 40}},
+		{Temperature: cfg.FromTo{From: 40, To: 60}, Fan: cfg.SetFromTo{From: 50, To: 90}},
+	}
+	var err error
+	global.FanControl, err = NewControl(global.GetSensorReader, cfg.FanControl{
+		SetCommand: "true",
+		Zones: map[string]cfg.FanZone{
+			"zone1": {ID: 1, RunEvery: "10s", Sensors: map[string]cfg.Sensor{
+				"cpu":     {Average: "10s", Rules: rules},
+				"ambient": {Average: "10s", Rules: rules},
+			}},
+		},
+	}, false)
+	require.NoError(t, err)
+
+	reader := metric.NewManualReader()
+	telemetry, err := NewTelemetry(global, reader)
+	require.NoError(t, err)
+	defer telemetry.Shutdown(context.Background())
+
+	zone := global.FanControl.Zones["zone1"]
+	for _, sensor := range zone.Sensors {
+		require.NoError(t, sensor.run())
+	}
+
+	metrics := collectMetrics(t, reader)
+	assert.Equal(t, int64(55), gaugeValue(t, metrics["sensor_temperature"], "sensor", "cpu"))
+	// not used by the fan control
+	assert.Equal(t, int64(35), gaugeValue(t, metrics["sensor_temperature"], "sensor", "nvme"))
+	assert.Equal(t, int64(55), gaugeValue(t, metrics["zone_sensor_average_temperature"], "sensor", "cpu"))
+	assert.Equal(t, int64(1), gaugeValue(t, metrics["zone_sensor_rule"], "sensor", "cpu"))
+	assert.Equal(t, int64(0), gaugeValue(t, metrics["zone_sensor_rule"], "sensor", "ambient"))
+	assert.Equal(t, int64(80), gaugeValue(t, metrics["zone_fan_speed_bid"], "sensor", "cpu"))
+	assert.Equal(t, int64(40), gaugeValue(t, metrics["zone_fan_speed_bid"], "sensor", "ambient"))
+	assert.Equal(t, int64(80), gaugeValue(t, metrics["fan_speed"], "name", "zone1"))
+
+	// the fan speed was sent by the set command
+	runs, ok := metrics["command_runs"].(metricdata.Sum[int64])
+	require.True(t, ok)
+	require.NotEmpty(t, runs.DataPoints)
+	name, _ := runs.DataPoints[0].Attributes.Value("name")
+	status, _ := runs.DataPoints[0].Attributes.Value("exit_status")
+	assert.Equal(t, "fan_control_set", name.AsString())
+	assert.Equal(t, int64(0), status.AsInt64())
+	_, ok = metrics["command_duration"].(metricdata.Histogram[float64])
+	assert.True(t, ok)
+}
+
+func TestStandbyActionsMetric(t *testing.T) {
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda"})
+	disk := global.Disks()["first"]
+	assert.True(t, disk.standby())
+	// counted once while the disk stays inhibited
+	disk.blockStandby(StandbyInhibited)
+	disk.blockStandby(StandbyInhibited)
+	disk.unblockStandby()
+	disk.blockStandby(StandbyInhibited)
+
+	reader := metric.NewManualReader()
+	telemetry, err := NewTelemetry(global, reader)
+	require.NoError(t, err)
+	defer telemetry.Shutdown(context.Background())
+
+	actions, ok := collectMetrics(t, reader)["disk_standby_actions"].(metricdata.Sum[int64])
+	require.True(t, ok)
+	counts := make(map[string]int64)
+	for _, point := range actions.DataPoints {
+		result, _ := point.Attributes.Value("result")
+		counts[result.AsString()] = point.Value
+	}
+	assert.Equal(t, map[string]int64{StandbySucceeded: 1, StandbyInhibited: 2}, counts)
+}
+
+func TestDiskHealthMetricsFromLastCheck(t *testing.T) {
+	global := &Global{
+		fs:    os.DirFS("."),
+		disks: make(map[string]*Disk),
+		TemperatureSensors: map[string]SensorGetter{
+			"smart": &SmartSensor{Name: "smart", fs: testingFS, File: "test_files/smartctl/sda.json"},
+		},
+	}
+	disk, err := NewDisk(global, "disk", cfg.Disk{Device: "fs_test_files/dev/sda", TemperatureSensor: "smart", MonitorTemperature: "always"}, nil)
+	require.NoError(t, err)
+	global.disks["disk"] = disk
+
+	reader := metric.NewManualReader()
+	telemetry, err := NewTelemetry(global, reader)
+	require.NoError(t, err)
+	defer telemetry.Shutdown(context.Background())
+
+	// smartctl is not called from the metrics
+	assert.NotContains(t, collectMetrics(t, reader), "disk_power_on_hours")
+
+	disk.recordSmart(SmartSample{Time: time.Now(), SmartData: SmartData{Healthy: true, PowerOnHours: 1234}})
+	metrics := collectMetrics(t, reader)
+	assert.Equal(t, int64(1234), gaugeValue(t, metrics["disk_power_on_hours"], "name", "disk"))
+	assert.Equal(t, int64(1), gaugeValue(t, metrics["disk_healthy"], "name", "disk"))
+}
@@ -6,11 +6,23 @@ import (
 	"sync"
 	"time"
 
-	"github.com/creativeprojects/clog"
 	"github.com/creativeprojects/hardware-events/cfg"
 	"github.com/creativeprojects/hardware-events/intmath"
 )
 
+// maxSensorRetryDelay is the longest wait between two failed readings of a sensor, unless its timer is longer
+const maxSensorRetryDelay = time.Minute
+
+// NoRuleMatched is the rule index when the temperature is outside all the rules (the minimum or maximum speed is requested)
+const NoRuleMatched = -1
+
+// SensorState is the last reading of a zone sensor
+type SensorState struct {
+	Temperature int // last temperature read
+	Average     int // average temperature used by the rules
+	Rule        int // index of the rule matched in the configuration, or NoRuleMatched
+}
+
 type TemperatureSensor struct {
 	valuesCount     int
 	values          []int
@@ -23,6 +35,10 @@ type TemperatureSensor struct {
 	Rules           []Rule
 	minTemp         int // minimum temp from the rules
 	maxTemp         int // maximum temp from the rules
+	state           SensorState
+	hasState        bool
+	heartbeat       *Heartbeat
+	log             Logger
 }
 
 func NewTemperatureSensor(config cfg.Sensor, name string, timer time.Duration, readTemperature func() (int, error), requestSpeed func(string, int, bool, bool)) (*TemperatureSensor, error) {
@@ -49,6 +65,7 @@ func NewTemperatureSensor(config cfg.Sensor, name string, timer time.Duration, r
 		if err != nil {
 			return nil, err
 		}
+		rule.Index = id
 		rules[id] = rule
 	}
 	// sort rules by temperature descending
@@ -94,34 +111,63 @@ func NewTemperatureSensor(config cfg.Sensor, name string, timer time.Duration, r
 }
 
 // Run reads temperature and requests a change in fan speed. This method runs in a infinite loop and should be called inside a goroutine.
+//
+// A failed reading is retried with an increasing delay: the heartbeat of the loop gets late while the temperature cannot be read.
 func (s *TemperatureSensor) Run() {
+	s.setRunning(true)
+	defer s.setRunning(false)
+	if err := s.attached(); err != nil {
+		s.log.Errorf("%s", err)
+		return
+	}
+	wait := s.RunTimer
 	for {
-		time.Sleep(s.RunTimer)
+		time.Sleep(wait)
 		err := s.run()
 		if err != nil {
-			clog.Error(err)
-			return
+			wait = min(2*wait, max(s.RunTimer, maxSensorRetryDelay))
+			s.log.Errorf("%s (next try in %s)", err, wait)
+			continue
 		}
+		wait = s.RunTimer
+		s.setRunning(true)
 	}
 }
 
-func (s *TemperatureSensor) run() error {
+// setRunning sends the heartbeat of the Run loop, which is late after 3 times its timer
+func (s *TemperatureSensor) setRunning(running bool) {
+	if running {
+		s.heartbeat.Beat(3 * s.RunTimer)
+	} else {
+		s.heartbeat.Stop()
+	}
+}
+
+// attached returns an error when the sensor has nothing to read or nothing to send the fan speed to
+func (s *TemperatureSensor) attached() error {
 	if s.readTemperature == nil {
-		// nothing for me to do here
 		return fmt.Errorf("%s: no temperature sensor attached, cancelling Run() now", s.Name)
 	}
 	if s.requestSpeed == nil {
-		// nothing for me to do here
 		return fmt.Errorf("%s: no fan speed attached, cancelling Run() now", s.Name)
 	}
+	return nil
+}
+
+func (s *TemperatureSensor) run() error {
+	if err := s.attached(); err != nil {
+		return err
+	}
 	temperature, err := s.readTemperature()
 	if err != nil {
 		return fmt.Errorf("%s: %v", s.Name, err)
 	}
+	reading := temperature
 	temperature = s.average(temperature)
-	clog.Tracef("%s: %d°C (average %d * %v)", s.Name, temperature, s.valuesCount, s.RunTimer)
+	s.log.Tracef("%d°C (average %d * %v)", temperature, s.valuesCount, s.RunTimer)
 	for _, rule := range s.Rules {
 		if rule.MatchTemperature(temperature) {
+			s.setState(SensorState{Temperature: reading, Average: temperature, Rule: rule.Index})
 			speed, timer := rule.CalculateFanSpeed(temperature)
 			if timer > 0 {
 				s.RunTimer = timer
@@ -134,6 +180,7 @@ func (s *TemperatureSensor) run() error {
 	}
 
 	// No temperature found, let's guess if we go for the min or the max
+	s.setState(SensorState{Temperature: reading, Average: temperature, Rule: NoRuleMatched})
 	if temperature > s.maxTemp {
 		s.requestSpeed(s.Name, 0, false, true)
 		// don't change the timer at this stage (keep the latest set)
@@ -146,6 +193,46 @@ func (s *TemperatureSensor) run() error {
 	return nil
 }
 
+// State returns the last reading of the sensor, if any
+func (s *TemperatureSensor) State() (SensorState, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.state, s.hasState
+}
+
+func (s *TemperatureSensor) setState(state SensorState) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.state = state
+	s.hasState = true
+}
+
+// Values returns a copy of the temperatures kept for the average
+func (s *TemperatureSensor) Values() []int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	values := make([]int, len(s.values))
+	copy(values, s.values)
+	return values
+}
+
+// restoreValues loads the temperatures saved in the state file, keeping only the most recent ones that fit the average window
+func (s *TemperatureSensor) restoreValues(values []int) {
+	if len(values) == 0 {
+		return
+	}
+	if len(values) > s.valuesCount {
+		values = values[len(values)-s.valuesCount:]
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.values = append(s.values[:0], values...)
+}
+
 func (s *TemperatureSensor) average(temperature int) int {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
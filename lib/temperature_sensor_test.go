@@ -1,7 +1,10 @@
 package lib
 
 import (
+	"errors"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -116,3 +119,46 @@ func TestRunValidCalculation(t *testing.T) {
 		})
 	}
 }
+
+func TestSensorLoopAlive(t *testing.T) {
+	health := NewHealth()
+	sensor, err := NewTemperatureSensor(cfg.Sensor{Average: "10ms"}, "cpu", time.Millisecond, nil, nil)
+	require.NoError(t, err)
+	sensor.heartbeat = health.Register("sensor zone1/cpu", true)
+	assert.NoError(t, health.Check(time.Now()))
+
+	sensor.setRunning(true)
+	assert.NoError(t, health.Check(time.Now()))
+	assert.ErrorContains(t, health.Check(time.Now().Add(time.Second)), "without heartbeat")
+
+	// the loop ends when no temperature can be read
+	sensor.Run()
+	assert.ErrorContains(t, health.Check(time.Now()), "sensor zone1/cpu stopped")
+}
+
+func TestSensorLoopKeepsRunningAfterReadError(t *testing.T) {
+	health := NewHealth()
+	failing := atomic.Bool{}
+	failing.Store(true)
+	readTemperature := func() (int, error) {
+		if failing.Load() {
+			return 0, errors.New("cannot read")
+		}
+		return 40, nil
+	}
+	sensor, err := NewTemperatureSensor(cfg.Sensor{Average: "10ms"}, "cpu", time.Millisecond, readTemperature, func(string, int, bool, bool) {})
+	require.NoError(t, err)
+	sensor.heartbeat = health.Register("sensor zone1/cpu", true)
+
+	go sensor.Run()
+	// the loop is wedged on the failing sensor, but not stopped
+	assert.Eventually(t, func() bool {
+		err := health.Check(time.Now())
+		return err != nil && strings.Contains(err.Error(), "without heartbeat")
+	}, time.Second, time.Millisecond)
+
+	failing.Store(false)
+	assert.Eventually(t, func() bool {
+		return health.Check(time.Now()) == nil
+	}, time.Second, time.Millisecond)
+}
@@ -1,7 +1,9 @@
 package lib
 
 import (
+	"encoding/json"
 	"path/filepath"
+	"text/template"
 
 	"github.com/creativeprojects/hardware-events/cfg"
 )
@@ -19,3 +21,12 @@ func NewTemplate(global *Global, name string, config cfg.Template) *Template {
 		ID:     filepath.Base(config.Source),
 	}
 }
+
+// templateFunctions are available in all the templates
+var templateFunctions = template.FuncMap{
+	// json encodes a value, like {{ json .Message }} to get a quoted and escaped string
+	"json": func(value any) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	UeventActionAdd    = "add"
+	UeventActionRemove = "remove"
+	UeventActionChange = "change"
+)
+
+// Uevent is a message sent by the kernel when a device is added, removed or changed
+type Uevent struct {
+	Action    string
+	DevPath   string
+	Subsystem string
+	DevName   string
+	DevType   string
+	Env       map[string]string
+}
+
+// ParseUevent decodes a kernel uevent message: a header "action@devpath" followed by KEY=VALUE pairs, all separated by a null byte
+func ParseUevent(message []byte) (Uevent, error) {
+	if bytes.HasPrefix(message, []byte("libudev")) {
+		return Uevent{}, errors.New("udev message: only kernel messages are supported")
+	}
+	fields := strings.Split(strings.TrimRight(string(message), "\x00"), "\x00")
+	action, devPath, found := strings.Cut(fields[0], "@")
+	if !found || action == "" {
+		return Uevent{}, fmt.Errorf("invalid uevent header %q", fields[0])
+	}
+	event := Uevent{
+		Action:  action,
+		DevPath: devPath,
+		Env:     make(map[string]string, len(fields)-1),
+	}
+	for _, field := range fields[1:] {
+		key, value, found := strings.Cut(field, "=")
+		if !found {
+			continue
+		}
+		event.Env[key] = value
+	}
+	if value, ok := event.Env["ACTION"]; ok {
+		event.Action = value
+	}
+	if value, ok := event.Env["DEVPATH"]; ok {
+		event.DevPath = value
+	}
+	event.Subsystem = event.Env["SUBSYSTEM"]
+	event.DevName = event.Env["DEVNAME"]
+	event.DevType = event.Env["DEVTYPE"]
+	return event, nil
+}
+
+// IsBlockDisk returns true when the event is about a whole disk (not a partition)
+func (e Uevent) IsBlockDisk() bool {
+	return e.Subsystem == "block" && e.DevType == "disk"
+}
@@ -0,0 +1,61 @@
+//go:build linux
+
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/creativeprojects/clog"
+)
+
+const (
+	ueventKernelGroup = 1
+	ueventBufferSize  = 64 * 1024
+	// the context is checked each time the socket stops waiting for a uevent
+	ueventReceiveTimeout = 1 * time.Second
+)
+
+// listenUevents receives the kernel uevents from the netlink socket until an error occurs or the context is cancelled
+func listenUevents(ctx context.Context, handler func(Uevent)) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return fmt.Errorf("cannot open netlink socket: %w", err)
+	}
+	socket := os.NewFile(uintptr(fd), "uevent")
+	defer socket.Close()
+
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: ueventKernelGroup})
+	if err != nil {
+		return fmt.Errorf("cannot bind netlink socket: %w", err)
+	}
+	timeout := syscall.NsecToTimeval(ueventReceiveTimeout.Nanoseconds())
+	err = syscall.SetsockoptTimeval(fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &timeout)
+	if err != nil {
+		return fmt.Errorf("cannot set netlink socket timeout: %w", err)
+	}
+
+	buffer := make([]byte, ueventBufferSize)
+	for ctx.Err() == nil {
+		n, _, err := syscall.Recvfrom(fd, buffer, 0)
+		if err != nil {
+			if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EINTR) || errors.Is(err, syscall.ENOBUFS) {
+				// EAGAIN: no uevent before the timeout
+				// ENOBUFS: some messages were lost, but we can keep going
+				continue
+			}
+			return fmt.Errorf("cannot receive uevent: %w", err)
+		}
+		event, err := ParseUevent(buffer[:n])
+		if err != nil {
+			clog.Tracef("ignoring uevent: %s", err)
+			continue
+		}
+		handler(event)
+	}
+	return nil
+}
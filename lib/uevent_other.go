@@ -0,0 +1,12 @@
+//go:build !linux
+
+package lib
+
+import (
+	"context"
+	"errors"
+)
+
+func listenUevents(ctx context.Context, handler func(Uevent)) error {
+	return errors.New("uevents are only available on linux")
+}
@@ -0,0 +1,52 @@
+package lib
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseUevent(t *testing.T) {
+	testData := []struct {
+		file      string
+		action    string
+		subsystem string
+		devName   string
+		devType   string
+		disk      bool
+	}{
+		{"add_disk.bin", UeventActionAdd, "block", "sdb", "disk", true},
+		{"add_partition.bin", UeventActionAdd, "block", "sdb1", "partition", false},
+		{"remove_disk.bin", UeventActionRemove, "block", "sdb", "disk", true},
+		{"add_usb.bin", UeventActionAdd, "usb", "bus/usb/001/004", "usb_device", false},
+	}
+	for _, testItem := range testData {
+		t.Run(testItem.file, func(t *testing.T) {
+			message, err := os.ReadFile(filepath.Join("test_files/uevent", testItem.file))
+			require.NoError(t, err)
+
+			event, err := ParseUevent(message)
+			require.NoError(t, err)
+			assert.Equal(t, testItem.action, event.Action)
+			assert.Equal(t, testItem.subsystem, event.Subsystem)
+			assert.Equal(t, testItem.devName, event.DevName)
+			assert.Equal(t, testItem.devType, event.DevType)
+			assert.Equal(t, testItem.disk, event.IsBlockDisk())
+			assert.Contains(t, event.DevPath, "/devices/pci0000:00/")
+			assert.NotEmpty(t, event.Env["SEQNUM"])
+		})
+	}
+}
+
+func TestParseInvalidUevent(t *testing.T) {
+	message, err := os.ReadFile("test_files/uevent/libudev.bin")
+	require.NoError(t, err)
+
+	for _, message := range [][]byte{message, []byte("ACTION=add\x00SUBSYSTEM=block\x00"), {}} {
+		_, err := ParseUevent(message)
+		assert.Error(t, err)
+	}
+}
@@ -0,0 +1,290 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+)
+
+const (
+	// -P: full path of the devices, -L: resolve the symlinks (like /dev/disk/by-id) to the real devices
+	defaultZpoolStatusCommand = "zpool status -P -L"
+	// -H: no header and tab separated, -p: exact values in bytes
+	defaultZpoolListCommand = "zpool list -H -p -o name,size,alloc,free,cap,health"
+	defaultZFSCheckEvery    = 1 * time.Minute
+
+	zpoolStateOnline = "ONLINE"
+)
+
+var scanProgressPattern = regexp.MustCompile(`([0-9.]+)% done`)
+
+// Zpool is the state of a ZFS pool
+type Zpool struct {
+	Name         string   `json:"name"`
+	State        string   `json:"state"`
+	Scrubbing    bool     `json:"scrubbing"`
+	Resilvering  bool     `json:"resilvering"`
+	ScanProgress float64  `json:"scan_progress,omitempty"` // percent
+	Scan         string   `json:"scan,omitempty"`
+	Devices      []string `json:"devices"` // full path of the leaf devices
+	Size         int64    `json:"size"`
+	Allocated    int64    `json:"allocated"`
+	Free         int64    `json:"free"`
+	Capacity     int      `json:"capacity"` // percent
+}
+
+// Healthy returns true when the pool is online
+func (z Zpool) Healthy() bool {
+	return z.State == zpoolStateOnline
+}
+
+// ScanInProgress returns true during a scrub or a resilver
+func (z Zpool) ScanInProgress() bool {
+	return z.Scrubbing || z.Resilvering
+}
+
+// ZFS reads the state of the ZFS pools
+type ZFS struct {
+	statusCommand CommandRunner
+	listCommand   CommandRunner
+	checkEvery    time.Duration
+	pools         map[string]Zpool
+	mutex         sync.Mutex
+}
+
+// NewZFS creates a ZFS source from the configuration
+func NewZFS(config cfg.ZFS) (*ZFS, error) {
+	var timeout time.Duration
+	var err error
+
+	if config.Timeout != "" {
+		timeout, err = time.ParseDuration(config.Timeout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	checkEvery := defaultZFSCheckEvery
+	if config.CheckEvery != "" {
+		checkEvery, err = time.ParseDuration(config.CheckEvery)
+		if err != nil {
+			return nil, err
+		}
+	}
+	statusCommandLine := defaultZpoolStatusCommand
+	if config.StatusCommand != "" {
+		statusCommandLine = config.StatusCommand
+	}
+	listCommandLine := defaultZpoolListCommand
+	if config.ListCommand != "" {
+		listCommandLine = config.ListCommand
+	}
+	statusCommand, err := newNamedCommand("zpool_status", statusCommandLine, "", timeout)
+	if err != nil {
+		return nil, err
+	}
+	listCommand, err := newNamedCommand("zpool_list", listCommandLine, "", timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &ZFS{
+		statusCommand: statusCommand,
+		listCommand:   listCommand,
+		checkEvery:    checkEvery,
+		pools:         make(map[string]Zpool),
+		mutex:         sync.Mutex{},
+	}, nil
+}
+
+// Pool returns the last known state of the ZFS pool
+func (z *ZFS) Pool(name string) (Zpool, bool) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	pool, ok := z.pools[name]
+	return pool, ok
+}
+
+// Pools returns the last known state of all the ZFS pools
+func (z *ZFS) Pools() []Zpool {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	pools := make([]Zpool, 0, len(z.pools))
+	for _, pool := range z.pools {
+		pools = append(pools, pool)
+	}
+	return pools
+}
+
+// Start refreshes the state of the pools at regular intervals, until the context is cancelled
+func (z *ZFS) Start(ctx context.Context) {
+	go func() {
+		for sleepContext(ctx, z.checkEvery) {
+			err := z.refresh()
+			if err != nil {
+				clog.Errorf("cannot read ZFS pools: %s", err)
+			}
+		}
+	}()
+}
+
+// refresh runs the zpool commands and replaces the state of all the pools
+func (z *ZFS) refresh() error {
+	output, err := z.statusCommand.Run(nil, nil)
+	if err != nil {
+		return err
+	}
+	pools, err := ParseZpoolStatus(output)
+	if err != nil {
+		return err
+	}
+	output, err = z.listCommand.Run(nil, nil)
+	if err != nil {
+		return err
+	}
+	err = ParseZpoolList(output, pools)
+	if err != nil {
+		return err
+	}
+
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+	z.pools = pools
+	return nil
+}
+
+// ParseZpoolStatus decodes the output of "zpool status -P"
+func ParseZpoolStatus(output string) (map[string]Zpool, error) {
+	pools := make(map[string]Zpool)
+	var pool *Zpool
+	inConfig, inScan := false, false
+
+	save := func() {
+		if pool != nil {
+			pools[pool.Name] = *pool
+		}
+	}
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value, hasKey := strings.Cut(line, ":")
+		if hasKey && !strings.Contains(key, " ") {
+			value = strings.TrimSpace(value)
+			inScan = false
+			switch key {
+			case "pool":
+				save()
+				pool = &Zpool{Name: value}
+				inConfig = false
+				continue
+			case "state":
+				if pool != nil {
+					pool.State = value
+				}
+				continue
+			case "scan":
+				if pool != nil {
+					pool.Scan = value
+					pool.Scrubbing = strings.HasPrefix(value, "scrub in progress")
+					pool.Resilvering = strings.HasPrefix(value, "resilver in progress")
+					inScan = true
+				}
+				continue
+			case "config":
+				inConfig = true
+				continue
+			case "errors":
+				inConfig = false
+				continue
+			}
+		}
+		if pool == nil || line == "" {
+			continue
+		}
+		if inScan {
+			// progress of the scan is on the following lines
+			if matches := scanProgressPattern.FindStringSubmatch(line); matches != nil {
+				pool.ScanProgress, _ = strconv.ParseFloat(matches[1], 64)
+			}
+			continue
+		}
+		if inConfig {
+			fields := strings.Fields(line)
+			// with -P all the leaf devices are displayed with their full path
+			if strings.HasPrefix(fields[0], "/") {
+				pool.Devices = append(pool.Devices, fields[0])
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	save()
+	if len(pools) == 0 && strings.TrimSpace(output) != "no pools available" && strings.TrimSpace(output) != "" {
+		return nil, fmt.Errorf("unexpected zpool status output: %q", firstLine(output))
+	}
+	return pools, nil
+}
+
+// ParseZpoolList adds the capacity from the output of "zpool list -H -p -o name,size,alloc,free,cap,health" to the pools
+func ParseZpoolList(output string, pools map[string]Zpool) error {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 6 {
+			return fmt.Errorf("unexpected zpool list output: %q", scanner.Text())
+		}
+		pool := pools[fields[0]]
+		pool.Name = fields[0]
+		values := make([]int64, 4)
+		for i := range values {
+			value, err := strconv.ParseInt(strings.TrimSuffix(fields[i+1], "%"), 10, 64)
+			if err != nil {
+				return fmt.Errorf("unexpected zpool list output: %q: %w", scanner.Text(), err)
+			}
+			values[i] = value
+		}
+		pool.Size, pool.Allocated, pool.Free, pool.Capacity = values[0], values[1], values[2], int(values[3])
+		if pool.State == "" {
+			pool.State = fields[5]
+		}
+		pools[pool.Name] = pool
+	}
+	return scanner.Err()
+}
+
+// zpoolMembers returns the names of the disks having a device in the ZFS pool (directly, or through a partition or a device mapper)
+func zpoolMembers(pool Zpool, disks map[string]*Disk) []string {
+	members := make([]string, 0, len(pool.Devices))
+	for name, disk := range disks {
+		if !disk.Present() {
+			continue
+		}
+		devices := disk.blockDevices()
+		for _, device := range pool.Devices {
+			if slices.Contains(devices, path.Base(device)) {
+				members = append(members, name)
+				break
+			}
+		}
+	}
+	return members
+}
+
+func firstLine(output string) string {
+	line, _, _ := strings.Cut(output, "\n")
+	return line
+}
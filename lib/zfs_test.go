@@ -0,0 +1,127 @@
+package lib
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fileCommand returns the content of the file as the output of the command
+type fileCommand string
+
+func (c fileCommand) Run(stdin io.Reader, expand func(string) string) (string, error) {
+	content, err := os.ReadFile(string(c))
+	return string(content), err
+}
+
+func newTestZFS(t *testing.T) *ZFS {
+	t.Helper()
+	zfs, err := NewZFS(cfg.ZFS{})
+	require.NoError(t, err)
+	zfs.statusCommand = fileCommand("test_files/zfs/status.txt")
+	zfs.listCommand = fileCommand("test_files/zfs/list.txt")
+	require.NoError(t, zfs.refresh())
+	return zfs
+}
+
+func TestParseZpoolStatus(t *testing.T) {
+	zfs := newTestZFS(t)
+	assert.Len(t, zfs.Pools(), 2)
+
+	rpool, ok := zfs.Pool("rpool")
+	require.True(t, ok)
+	assert.True(t, rpool.Healthy())
+	assert.False(t, rpool.ScanInProgress())
+	assert.Equal(t, []string{"/dev/nvme0n1p2", "/dev/nvme1n1p2"}, rpool.Devices)
+	assert.Equal(t, int64(498216206336), rpool.Size)
+	assert.Equal(t, 24, rpool.Capacity)
+
+	tank, ok := zfs.Pool("tank")
+	require.True(t, ok)
+	assert.False(t, tank.Healthy())
+	assert.Equal(t, "DEGRADED", tank.State)
+	assert.True(t, tank.Scrubbing)
+	assert.False(t, tank.Resilvering)
+	assert.InDelta(t, 13.21, tank.ScanProgress, 0.001)
+	assert.Equal(t, []string{"/dev/sda1", "/dev/dm-1", "/dev/nvme0n1p1"}, tank.Devices)
+	assert.Equal(t, int64(3793398300000), tank.Allocated)
+	assert.Equal(t, int64(8198150390432), tank.Free)
+	assert.Equal(t, 31, tank.Capacity)
+}
+
+func TestParseZpoolStatusResilver(t *testing.T) {
+	pools, err := ParseZpoolStatus("  pool: tank\n state: ONLINE\n  scan: resilver in progress since Sun Oct 19 10:00:00 2025\n\t0B resilvered, 2.50% done, 10:00:00 to go\n")
+	require.NoError(t, err)
+	assert.True(t, pools["tank"].Resilvering)
+	assert.InDelta(t, 2.5, pools["tank"].ScanProgress, 0.001)
+}
+
+func TestParseInvalidZpoolOutput(t *testing.T) {
+	pools, err := ParseZpoolStatus("no pools available\n")
+	require.NoError(t, err)
+	assert.Empty(t, pools)
+
+	_, err = ParseZpoolStatus("command not found")
+	assert.Error(t, err)
+
+	assert.Error(t, ParseZpoolList("tank\t123\n", pools))
+	assert.Error(t, ParseZpoolList("tank\tbig\t1\t1\t1\tONLINE\n", pools))
+}
+
+func TestZFSPoolMembersAndScrub(t *testing.T) {
+	global := newTestGlobal(t, map[string]string{"first": "/dev/sda", "second": "/dev/sdb"})
+	global.ZFS = newTestZFS(t)
+	for _, disk := range global.Disks() {
+		disk.lastActivity = time.Now().Add(-2 * time.Hour)
+	}
+
+	_, err := NewDiskPool(global, "nope", cfg.DiskPool{ZFS: "tank"})
+	require.NoError(t, err)
+	global.ZFS = nil
+	_, err = NewDiskPool(global, "nope", cfg.DiskPool{ZFS: "tank"})
+	assert.Error(t, err)
+	global.ZFS = newTestZFS(t)
+
+	pool, err := NewDiskPool(global, "data", cfg.DiskPool{Disks: []string{"second"}, ZFS: "tank", StandbyAfter: "1h"})
+	require.NoError(t, err)
+	global.DiskPools["data"] = pool
+	// sda1 is a partition of the first disk, dm-1 is stacked on the second disk: listed only once
+	assert.Equal(t, []string{"second", "first"}, pool.Disks)
+	assert.Equal(t, "data", global.Disks()["first"].Pool())
+	assert.Equal(t, "data", global.Disks()["second"].Pool())
+
+	// scrub in progress
+	assert.NotEmpty(t, global.Disks()["first"].standbyInhibitor())
+	pool.standbyWhenIdle()
+	assert.Equal(t, 2, pool.CountActive())
+
+	global.ZFS.statusCommand = fileCommand("test_files/zfs/status_idle.txt")
+	require.NoError(t, global.ZFS.refresh())
+	assert.Empty(t, global.Disks()["first"].standbyInhibitor())
+	pool.standbyWhenIdle()
+	assert.Equal(t, 0, pool.CountActive())
+
+	report := global.Status().Pools["data"]
+	assert.ElementsMatch(t, []string{"first", "second"}, report.Disks)
+	require.NotNil(t, report.ZFS)
+	assert.Equal(t, "tank", report.ZFS.Name)
+}
+
+func TestZFSPoolWithoutZFS(t *testing.T) {
+	global := newTestGlobal(t, nil)
+	_, err := NewDiskPool(global, "data", cfg.DiskPool{ZFS: "tank"})
+	assert.Error(t, err)
+
+	// zfs is disabled in simulation mode
+	global.config.Simulation = true
+	pool, err := NewDiskPool(global, "data", cfg.DiskPool{Disks: []string{"first"}, ZFS: "tank"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first"}, pool.Disks)
+	_, ok := pool.Zpool()
+	assert.False(t, ok)
+}
@@ -1,10 +1,10 @@
 package lib
 
 import (
+	"maps"
 	"sync"
 	"time"
 
-	"github.com/creativeprojects/clog"
 	"github.com/creativeprojects/hardware-events/cfg"
 	"github.com/creativeprojects/hardware-events/constants"
 )
@@ -15,6 +15,9 @@ type SensorReader func(sensorName string) func() (int, error)
 // Zone fan control
 type Zone struct {
 	currentSpeed   int
+	restoredSpeed  int            // fan speed saved in the state file, applied when the zone starts
+	overrideSpeed  int            // manual fan speed, ignoring the sensors when set
+	forcedMax      bool           // maximum speed forced by a critical policy, before the manual speed and the sensors
 	requestedSpeed map[string]int // fan speed requested by all the different sensor rules
 	defaultSpeed   int
 	minSpeed       int
@@ -24,6 +27,7 @@ type Zone struct {
 	ID             int
 	Name           string
 	Sensors        map[string]*TemperatureSensor
+	log            Logger
 }
 
 // NewZone creates a new fan control zone
@@ -59,6 +63,7 @@ func NewZone(sensorReader SensorReader, config cfg.FanZone, name string, setSpee
 		mutex:          sync.Mutex{},
 		ID:             config.ID,
 		Name:           name,
+		log:            NewLogger("zone", name),
 	}
 
 	sensors := make(map[string]*TemperatureSensor, len(config.Sensors))
@@ -67,6 +72,7 @@ func NewZone(sensorReader SensorReader, config cfg.FanZone, name string, setSpee
 		if err != nil {
 			return nil, err
 		}
+		sensor.log = zone.log.With("sensor", sensorName)
 		sensors[sensorName] = sensor
 	}
 	zone.Sensors = sensors
@@ -75,6 +81,13 @@ func NewZone(sensorReader SensorReader, config cfg.FanZone, name string, setSpee
 }
 
 func (z *Zone) Start() {
+	z.mutex.Lock()
+	if z.restoredSpeed > 0 {
+		z.log.Debugf("restoring fan speed %d%%", z.restoredSpeed)
+		z.setFanSpeed(z.restoredSpeed)
+	}
+	z.mutex.Unlock()
+
 	for _, zoneSensor := range z.Sensors {
 		go zoneSensor.Run()
 	}
@@ -90,7 +103,7 @@ func (z *Zone) setFanSpeed(speed int) {
 	if z.currentSpeed == speed {
 		return
 	}
-	clog.Tracef("%s: set fan speed %d%%", z.Name, speed)
+	z.log.Tracef("set fan speed %d%%", speed)
 	z.currentSpeed = speed
 	if z.setSpeed != nil {
 		z.setSpeed(z.ID, speed)
@@ -107,15 +120,106 @@ func (z *Zone) RequestFanSpeed(name string, speed int, min, max bool) {
 	defer z.mutex.Unlock()
 
 	z.requestedSpeed[name] = speed
-	clog.Tracef("%s: request fan speed %d%%", name, speed)
+	z.log.With("sensor", name).Tracef("request fan speed %d%%", speed)
+
+	if z.forcedMax || z.overrideSpeed > 0 {
+		return
+	}
+	z.setFanSpeed(z.highestBid())
+}
 
-	// now pick the highest bid
+// highestBid returns the highest fan speed requested by the sensors
+func (z *Zone) highestBid() int {
+	speed := 0
 	for _, bid := range z.requestedSpeed {
 		if bid > speed {
 			speed = bid
 		}
 	}
-	z.setFanSpeed(speed)
+	return speed
+}
+
+// SetOverride sets the fan speed manually, until the override is cleared with a speed of zero
+func (z *Zone) SetOverride(speed int) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	if speed > 0 {
+		z.log.Infof("manual fan speed %d%%", speed)
+		z.overrideSpeed = speed
+		if !z.forcedMax {
+			z.setFanSpeed(speed)
+		}
+		return
+	}
+	if z.overrideSpeed == 0 {
+		return
+	}
+	z.log.Infof("back to automatic fan speed")
+	z.overrideSpeed = 0
+	if !z.forcedMax && len(z.requestedSpeed) > 0 {
+		z.setFanSpeed(z.highestBid())
+	}
+}
+
+// SetForcedMax forces the maximum fan speed, whatever the manual speed or the sensors are requesting.
+// Once released, the manual speed or the sensors are back in control
+func (z *Zone) SetForcedMax(forced bool) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	if z.forcedMax == forced {
+		return
+	}
+	z.forcedMax = forced
+	if forced {
+		z.log.Warningf("forcing maximum fan speed %d%%", z.maxSpeed)
+		z.setFanSpeed(z.maxSpeed)
+		return
+	}
+	z.log.Warningf("maximum fan speed released")
+	if z.overrideSpeed > 0 {
+		z.setFanSpeed(z.overrideSpeed)
+	} else if len(z.requestedSpeed) > 0 {
+		z.setFanSpeed(z.highestBid())
+	}
+}
+
+// ForcedMax returns true while the maximum fan speed is forced
+func (z *Zone) ForcedMax() bool {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	return z.forcedMax
+}
+
+// Override returns the manual fan speed, or zero when the speed is automatic
+func (z *Zone) Override() int {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	return z.overrideSpeed
+}
+
+// SpeedRange returns the minimum and maximum speed of the zone
+func (z *Zone) SpeedRange() (int, int) {
+	return z.minSpeed, z.maxSpeed
+}
+
+// restoreSpeed keeps the fan speed from the state file until the sensors request a new one
+func (z *Zone) restoreSpeed(speed int) {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	z.restoredSpeed = speed
+}
+
+// Bids returns the fan speed requested by each sensor of the zone
+func (z *Zone) Bids() map[string]int {
+	z.mutex.Lock()
+	defer z.mutex.Unlock()
+
+	return maps.Clone(z.requestedSpeed)
 }
 
 func (z *Zone) CurrentFanSpeed() int {
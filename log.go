@@ -1,14 +1,51 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/creativeprojects/hardware-events/lib"
 )
 
-// setupLogger returns a cleaning function, if any
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// setupLogger sends the logs to the console until the configuration is loaded
 func setupLogger(flags Flags) func() {
+	handler, closer, _ := newLogHandler(flags, cfg.Log{})
+	clog.SetDefaultLogger(clog.NewLogger(handler))
+	return closer
+}
+
+// configureLogger replaces the console logger with the one from the configuration, and returns a cleaning function
+func configureLogger(flags Flags, config cfg.Log) (func(), error) {
+	handler, closer, err := newLogHandler(flags, config)
+	if err != nil {
+		return nil, err
+	}
+	clog.SetDefaultLogger(clog.NewLogger(handler))
+	return closer, nil
+}
+
+func newLogHandler(flags Flags, config cfg.Log) (clog.Handler, func(), error) {
 	level := clog.LevelInfo
+	if config.Level != "" {
+		var err error
+		level, err = parseLogLevel(config.Level)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
 	if flags.debug {
 		level = clog.LevelTrace
 	} else if flags.verbose {
@@ -16,10 +53,162 @@ func setupLogger(flags Flags) func() {
 	} else if flags.quiet {
 		level = clog.LevelWarning
 	}
-	clog.SetDefaultLogger(
-		clog.NewLogger(
-			clog.NewLevelFilter(level,
-				clog.NewConsoleHandler("", log.LstdFlags))))
+	levels := make(map[string]clog.LogLevel, len(config.Levels))
+	for component, value := range config.Levels {
+		componentLevel, err := parseLogLevel(value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", component, err)
+		}
+		levels[component] = componentLevel
+	}
+
+	var output io.Writer
+	closer := func() {}
+	if config.File != "" {
+		var maxAge time.Duration
+		if config.MaxAge != "" {
+			var err error
+			maxAge, err = time.ParseDuration(config.MaxAge)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid max_age: %w", err)
+			}
+		}
+		file, err := newRotatingFile(config.File, int64(config.MaxSize)*1024*1024, maxAge, config.MaxBackups)
+		if err != nil {
+			return nil, nil, err
+		}
+		output = file
+		closer = func() { _ = file.Close() }
+	}
+
+	var handler clog.Handler
+	switch config.Format {
+	case "", LogFormatText:
+		if output == nil {
+			handler = clog.NewConsoleHandler("", log.LstdFlags)
+		} else {
+			handler = clog.NewStandardLogHandler(output, "", log.LstdFlags)
+		}
+	case LogFormatJSON:
+		if output == nil {
+			output = os.Stdout
+		}
+		handler = newJSONLogHandler(output)
+	default:
+		closer()
+		return nil, nil, fmt.Errorf("unknown log format %q", config.Format)
+	}
+	return newComponentFilter(level, levels, handler), closer, nil
+}
+
+func parseLogLevel(level string) (clog.LogLevel, error) {
+	switch strings.ToLower(level) {
+	case "trace":
+		return clog.LevelTrace, nil
+	case "debug":
+		return clog.LevelDebug, nil
+	case "info":
+		return clog.LevelInfo, nil
+	case "warning", "warn":
+		return clog.LevelWarning, nil
+	case "error":
+		return clog.LevelError, nil
+	default:
+		return clog.LevelInfo, fmt.Errorf("unknown log level %q", level)
+	}
+}
+
+// componentFilter is a level filter where the level can be changed for a zone, a sensor, a disk, etc.
+type componentFilter struct {
+	level  clog.LogLevel
+	levels map[string]clog.LogLevel // by component name, or by "key=name"
+	next   clog.Handler
+}
+
+func newComponentFilter(level clog.LogLevel, levels map[string]clog.LogLevel, next clog.Handler) *componentFilter {
+	return &componentFilter{
+		level:  level,
+		levels: levels,
+		next:   next,
+	}
+}
+
+func (h *componentFilter) LogEntry(entry clog.LogEntry) error {
+	minLevel := h.level
+	// the last field is the most specific one (the sensor of a zone)
+	for _, field := range lib.LogFields(entry) {
+		if level, ok := h.levels[field.Key+"="+field.Value]; ok {
+			minLevel = level
+		} else if level, ok := h.levels[field.Value]; ok {
+			minLevel = level
+		}
+	}
+	if entry.Level < minLevel {
+		return nil
+	}
+	entry.Calldepth++
+	return h.next.LogEntry(entry)
+}
+
+// jsonLogHandler writes one JSON object per line, with the fields of the log entry
+type jsonLogHandler struct {
+	handler slog.Handler
+}
+
+func newJSONLogHandler(output io.Writer) *jsonLogHandler {
+	return &jsonLogHandler{
+		handler: slog.NewJSONHandler(output, &slog.HandlerOptions{
+			Level: slogLevel(clog.LevelTrace),
+			ReplaceAttr: func(groups []string, attr slog.Attr) slog.Attr {
+				if attr.Key == slog.LevelKey && len(groups) == 0 {
+					return slog.String(slog.LevelKey, logLevelName(attr.Value.Any().(slog.Level)))
+				}
+				return attr
+			},
+		}),
+	}
+}
+
+func (h *jsonLogHandler) LogEntry(entry clog.LogEntry) error {
+	record := slog.NewRecord(time.Now(), slogLevel(entry.Level), lib.LogText(entry), 0)
+	for _, field := range lib.LogFields(entry) {
+		record.AddAttrs(slog.String(field.Key, field.Value))
+	}
+	return h.handler.Handle(context.Background(), record)
+}
+
+func slogLevel(level clog.LogLevel) slog.Level {
+	switch level {
+	case clog.LevelTrace:
+		return slog.LevelDebug - 4
+	case clog.LevelDebug:
+		return slog.LevelDebug
+	case clog.LevelWarning:
+		return slog.LevelWarn
+	case clog.LevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
 
-	return nil
+func logLevelName(level slog.Level) string {
+	switch {
+	case level < slog.LevelDebug:
+		return "trace"
+	case level < slog.LevelInfo:
+		return "debug"
+	case level < slog.LevelWarn:
+		return "info"
+	case level < slog.LevelError:
+		return "warning"
+	default:
+		return "error"
+	}
 }
+
+// Verify interface
+var (
+	_ clog.Handler = &componentFilter{}
+	_ clog.Handler = &jsonLogHandler{}
+)
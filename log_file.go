@@ -0,0 +1,134 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+const rotatedLogSuffix = "20060102-150405.000"
+
+// rotatingFile is a log file renamed with a timestamp suffix when it gets too big or too old
+type rotatingFile struct {
+	mutex      sync.Mutex
+	filename   string
+	maxSize    int64         // no rotation by size when zero
+	maxAge     time.Duration // no rotation by age when zero
+	maxBackups int           // keep all the rotated files when zero
+	file       *os.File
+	size       int64
+	opened     time.Time
+}
+
+func newRotatingFile(filename string, maxSize int64, maxAge time.Duration, maxBackups int) (*rotatingFile, error) {
+	file := &rotatingFile{
+		filename:   filename,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+	err := file.open()
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	f.opened = time.Now()
+	return nil
+}
+
+func (f *rotatingFile) Write(data []byte) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.needsRotation(int64(len(data)), time.Now()) {
+		err := f.rotate()
+		if err != nil && f.file == nil {
+			return 0, err
+		}
+	}
+	written, err := f.file.Write(data)
+	f.size += int64(written)
+	return written, err
+}
+
+func (f *rotatingFile) needsRotation(size int64, now time.Time) bool {
+	if f.maxSize > 0 && f.size+size > f.maxSize {
+		return true
+	}
+	return f.maxAge > 0 && now.Sub(f.opened) >= f.maxAge
+}
+
+// rotate renames the current file and opens a new one.
+// On failure, the logs keep going to the original file
+func (f *rotatingFile) rotate() error {
+	err := f.file.Close()
+	f.file = nil
+	if err != nil {
+		return f.reopen(err)
+	}
+	err = os.Rename(f.filename, f.filename+"."+time.Now().Format(rotatedLogSuffix))
+	if err != nil {
+		return f.reopen(err)
+	}
+	err = f.open()
+	if err != nil {
+		return f.reopen(err)
+	}
+	f.removeBackups()
+	return nil
+}
+
+// reopen opens the file again after a failed rotation, and returns the rotation error
+func (f *rotatingFile) reopen(rotationErr error) error {
+	if f.file == nil {
+		_ = f.open()
+	}
+	return rotationErr
+}
+
+// removeBackups deletes the oldest rotated files above maxBackups
+func (f *rotatingFile) removeBackups() {
+	if f.maxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(f.filename + ".[0-9]*")
+	if err != nil || len(backups) <= f.maxBackups {
+		return
+	}
+	// the timestamp suffix sorts by date
+	slices.Sort(backups)
+	for _, backup := range backups[:len(backups)-f.maxBackups] {
+		_ = os.Remove(backup)
+	}
+}
+
+func (f *rotatingFile) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
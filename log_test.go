@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/creativeprojects/hardware-events/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useLogger sends the logs to the handler until the end of the test.
+// The logs are discarded afterwards: the initial logger would buffer them until the next test replaces it
+func useLogger(t *testing.T, handler clog.Handler) {
+	t.Helper()
+	clog.SetDefaultLogger(clog.NewLogger(handler))
+	t.Cleanup(func() { clog.SetDefaultLogger(clog.NewLogger(clog.NewDiscardHandler())) })
+}
+
+func TestComponentLevels(t *testing.T) {
+	memory := clog.NewMemoryHandler()
+	handler, _, err := newLogHandler(Flags{}, cfg.Log{
+		Level:  "warning",
+		Levels: map[string]string{"zone1": "trace", "sensor=cpu": "error"},
+	})
+	require.NoError(t, err)
+	handler.(*componentFilter).next = memory
+	useLogger(t, handler)
+
+	clog.Info("not logged")
+	lib.NewLogger("zone", "zone1").Tracef("trace")
+	lib.NewLogger("zone", "zone2").Tracef("trace")
+	lib.NewLogger("zone", "zone1").With("sensor", "cpu").Warningf("warning")
+	lib.NewLogger("zone", "zone1").With("sensor", "hdd").Debugf("debug")
+	lib.NewLogger("command", "fan_control_set").Debugf("debug")
+	clog.Warning("logged")
+
+	assert.Equal(t, []string{"zone1: trace", "zone1: hdd: debug", "logged"}, memory.Logs())
+}
+
+func TestJSONLogs(t *testing.T) {
+	output := &bytes.Buffer{}
+	useLogger(t, newJSONLogHandler(output))
+
+	lib.NewLogger("zone", "zone1").With("sensor", "cpu").Tracef("%d°C", 45)
+	clog.Warningf("disk %s is not present", "backup")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	require.Len(t, lines, 2)
+	entry := make(map[string]any)
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "trace", entry["level"])
+	assert.Equal(t, "45°C", entry["msg"])
+	assert.Equal(t, "zone1", entry["zone"])
+	assert.Equal(t, "cpu", entry["sensor"])
+	assert.NotEmpty(t, entry["time"])
+
+	entry = make(map[string]any)
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal(t, "warning", entry["level"])
+	assert.Equal(t, "disk backup is not present", entry["msg"])
+}
+
+func TestRotatingFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "hardware-events.log")
+	file, err := newRotatingFile(filename, 20, 0, 2)
+	require.NoError(t, err)
+	defer file.Close()
+
+	for _, line := range []string{"first line\n", "second line\n", "third line\n", "fourth line\n"} {
+		_, err = file.Write([]byte(line))
+		require.NoError(t, err)
+		// the rotated files are named after the time
+		time.Sleep(2 * time.Millisecond)
+	}
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "fourth line\n", string(content))
+
+	backups, err := filepath.Glob(filename + ".*")
+	require.NoError(t, err)
+	require.Len(t, backups, 2)
+	content, err = os.ReadFile(backups[1])
+	require.NoError(t, err)
+	assert.Equal(t, "third line\n", string(content))
+}
+
+func TestRotatingFileByAge(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "hardware-events.log")
+	file, err := newRotatingFile(filename, 0, time.Hour, 0)
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = file.Write([]byte("yesterday\n"))
+	require.NoError(t, err)
+	assert.False(t, file.needsRotation(1, time.Now()))
+	file.opened = time.Now().Add(-2 * time.Hour)
+	_, err = file.Write([]byte("today\n"))
+	require.NoError(t, err)
+
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "today\n", string(content))
+}
+
+func TestRotatingFileFailure(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "hardware-events.log")
+	file, err := newRotatingFile(filename, 20, 0, 0)
+	require.NoError(t, err)
+	defer file.Close()
+
+	_, err = file.Write([]byte("first line\n"))
+	require.NoError(t, err)
+	// the file cannot be renamed anymore
+	require.NoError(t, os.Remove(filename))
+
+	_, err = file.Write([]byte("second line\n"))
+	require.NoError(t, err)
+	content, err := os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "second line\n", string(content))
+
+	// the next rotation works again
+	_, err = file.Write([]byte("third line\n"))
+	require.NoError(t, err)
+	content, err = os.ReadFile(filename)
+	require.NoError(t, err)
+	assert.Equal(t, "third line\n", string(content))
+}
+
+func TestInvalidLogConfiguration(t *testing.T) {
+	for _, config := range []cfg.Log{
+		{Format: "xml"},
+		{Level: "verbose"},
+		{Levels: map[string]string{"zone1": "loud"}},
+		{File: filepath.Join(t.TempDir(), "log"), MaxAge: "a week"},
+		{File: filepath.Join(t.TempDir(), "missing", "log")},
+	} {
+		_, _, err := newLogHandler(Flags{}, config)
+		assert.Error(t, err)
+	}
+}
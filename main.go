@@ -25,10 +25,17 @@ var (
 
 func main() {
 	var exitCode = 0
+	var reload = false
 	var err error
 
-	// run all defer functions before returning with an exit code
+	// run all defer functions before returning with an exit code, or before reloading
 	defer func() {
+		if reload {
+			// only returns on error
+			err := restart()
+			fmt.Fprintf(os.Stderr, "cannot reload: %v\n", err)
+			exitCode = 1
+		}
 		if exitCode != 0 {
 			os.Exit(exitCode)
 		}
@@ -46,36 +53,51 @@ func main() {
 
 	clog.Debugf("hardware-events %s compiled with %s", version, runtime.Version())
 
-	config, err := cfg.LoadFileConfig(flags.configFile)
+	if flag.Arg(0) == "discover" {
+		err = discoverSensors(os.Stdout, os.DirFS("/"))
+		if err != nil {
+			clog.Error(err)
+			exitCode = 1
+		}
+		return
+	}
+
+	config, err := loadConfiguration(flags)
 	if err != nil {
 		clog.Errorf("cannot load configuration: %v", err)
 		exitCode = 1
 		return
 	}
-
-	if flags.simulation {
-		config.Simulation = true
-	}
 	if config.Simulation {
 		clog.Warningf("running in simulation mode with seeds = %d and %d", flags.seed1, flags.seed2)
-		config.Seed1 = flags.seed1
-		config.Seed2 = flags.seed2
 	}
 
+	closeLogger, err := configureLogger(flags, config.Log)
+	if err != nil {
+		clog.Errorf("invalid log configuration: %v", err)
+		exitCode = 1
+		return
+	}
+	defer closeLogger()
+
 	global, err := lib.NewGlobal(config)
 	if err != nil {
 		clog.Errorf("cannot load configuration: %v", err)
 		exitCode = 1
 		return
 	}
+	global.Start()
+	defer global.Close()
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	reloadSignal := make(chan os.Signal, 1)
+	signal.Notify(reloadSignal, syscall.SIGHUP)
 
 	notifyReady()
 
 	// systemd watchdog
-	go setupWatchdog()
+	go setupWatchdog(global.Health)
 
 	// run all startup tasks
 	for _, task := range global.GetStartupTasks() {
@@ -105,20 +127,103 @@ func main() {
 		return
 	}
 
-	closeMetricsServer, err := setupMetricsServer(config)
+	closeMetricsServer, err := setupMetricsServer(config, global)
 	if err != nil {
 		clog.Errorf("cannot start http server: %v", err)
 		exitCode = 1
 		return
 	}
 
-	// wait until we're politely asked to leave
-	<-stop
-	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	closeMQTT, err := setupMQTT(config, global)
+	if err != nil {
+		clog.Errorf("cannot start mqtt: %v", err)
+		exitCode = 1
+		return
+	}
+
+	stopStatusNotifier := setupStatusNotifier(global)
+
+	// wait until we're politely asked to leave, or to reload the configuration
+	for waiting := true; waiting; {
+		select {
+		case <-stop:
+			waiting = false
+		case <-reloadSignal:
+			err = checkConfiguration(flags)
+			if err != nil {
+				clog.Errorf("not reloading invalid configuration: %v", err)
+				continue
+			}
+			clog.Info("reloading configuration")
+			reload = true
+			waiting = false
+		}
+	}
+	stopStatusNotifier()
+	if reload {
+		notifyReloading()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	closeMQTT()
 	closeMetricsServer(ctx)
 	closeTelemetry(ctx)
 	signal.Stop(stop)
+	signal.Stop(reloadSignal)
+	err = global.SaveState()
+	if err != nil {
+		clog.Errorf("cannot save state: %s", err)
+	}
 	_ = global.FanControl.Exit()
+	if reload {
+		// the new process notifies systemd when it's ready
+		return
+	}
 	notifyLeaving()
 	fmt.Println("Bye bye!")
 }
+
+// loadConfiguration loads the configuration file, with the simulation settings from the flags
+func loadConfiguration(flags Flags) (cfg.Config, error) {
+	config, err := cfg.LoadFileConfig(flags.configFile)
+	if err != nil {
+		return config, err
+	}
+	if flags.simulation {
+		config.Simulation = true
+	}
+	if config.Simulation {
+		config.Seed1 = flags.seed1
+		config.Seed2 = flags.seed2
+	}
+	return config, nil
+}
+
+// checkConfiguration creates all the objects from the configuration file without starting them,
+// so the daemon is not restarted with a configuration it cannot run
+func checkConfiguration(flags Flags) error {
+	config, err := loadConfiguration(flags)
+	if err != nil {
+		return err
+	}
+	_, closeLogFile, err := newLogHandler(flags, config.Log)
+	if err != nil {
+		return fmt.Errorf("invalid log configuration: %w", err)
+	}
+	closeLogFile()
+	global, err := lib.NewGlobal(config)
+	if global != nil {
+		global.Close()
+	}
+	return err
+}
+
+// restart replaces the current process with a new one loading the configuration again.
+// The PID doesn't change so systemd keeps tracking the service
+func restart() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(executable, os.Args, os.Environ())
+}
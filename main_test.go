@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckConfiguration(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(configFile, []byte("fan_control:\n  set_command: \"true\"\n"), 0o600))
+	assert.NoError(t, checkConfiguration(Flags{configFile: configFile}))
+
+	// the file is valid yaml, but the duration is not
+	require.NoError(t, os.WriteFile(configFile, []byte("diskstats:\n  sample_every: often\n"), 0o600))
+	assert.ErrorContains(t, checkConfiguration(Flags{configFile: configFile}), "invalid diskstats sampling interval")
+
+	require.NoError(t, os.WriteFile(configFile, []byte("log:\n  format: xml\n"), 0o600))
+	assert.Error(t, checkConfiguration(Flags{configFile: configFile}))
+}
@@ -2,28 +2,59 @@ package main
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/creativeprojects/clog"
 	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/creativeprojects/hardware-events/lib"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-func setupMetricsServer(config cfg.Config) (func(context.Context) error, error) {
+const defaultMetricsPath = "/metrics"
+
+func setupMetricsServer(config cfg.Config, global *lib.Global) (func(context.Context) error, error) {
 	if !config.Telemetry.Prometheus.Enabled {
 		return func(_ context.Context) error { return nil }, nil
 	}
-	clog.Debugf("serving metrics at %s/metrics", config.Telemetry.Prometheus.Listen)
-	http.Handle("/metrics", promhttp.Handler())
+	handler, err := newMetricsHandler(config.Telemetry.Prometheus, global)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := newServerTLSConfig(config.Telemetry.Prometheus.TLS)
+	if err != nil {
+		return nil, err
+	}
 	server := http.Server{
-		Addr: config.Telemetry.Prometheus.Listen,
+		Addr:              config.Telemetry.Prometheus.Listen,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
 	}
+	clog.Debugf("serving metrics at %s%s", config.Telemetry.Prometheus.Listen, metricsPath(config.Telemetry.Prometheus))
+	heartbeat := global.Health.Register("metrics server", true)
 	wg := new(sync.WaitGroup)
 	wg.Go(func() {
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		// alive until the server stops
+		heartbeat.Beat(0)
+		if tlsConfig != nil {
+			// the certificate is loaded from the TLS configuration
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			clog.Errorf("metrics server: %s", err)
+			heartbeat.Stop()
+		} else {
+			heartbeat.Done()
 		}
 		clog.Debug("metrics server closed")
 	})
@@ -34,3 +65,128 @@ func setupMetricsServer(config cfg.Config) (func(context.Context) error, error)
 		return err
 	}, nil
 }
+
+// newMetricsHandler returns the routes of the metrics server. The health endpoints are not authenticated
+func newMetricsHandler(config cfg.Prometheus, global *lib.Global) (http.Handler, error) {
+	path := metricsPath(config)
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("invalid metrics path %q: it should start with a /", path)
+	}
+	if config.Password != "" && config.Username == "" {
+		return nil, errors.New("missing username for the metrics server basic authentication")
+	}
+	protected := http.NewServeMux()
+	protected.Handle(path, promhttp.Handler())
+	protected.HandleFunc("/status", statusHandler(global))
+	protected.HandleFunc("/inhibit", inhibitHandler(global))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthHandler(global.Health.Check))
+	mux.HandleFunc("/readyz", healthHandler(global.FanControl.Ready))
+	mux.Handle("/", authenticate(config, protected))
+	return mux, nil
+}
+
+func metricsPath(config cfg.Prometheus) string {
+	if config.Path == "" {
+		return defaultMetricsPath
+	}
+	return config.Path
+}
+
+// authenticate accepts the requests with either the basic authentication or the bearer token, when configured
+func authenticate(config cfg.Prometheus, next http.Handler) http.Handler {
+	if config.Username == "" && config.BearerToken == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Username != "" {
+			username, password, ok := r.BasicAuth()
+			if ok && secureEqual(username, config.Username) && secureEqual(password, config.Password) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if config.BearerToken != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if ok && secureEqual(token, config.BearerToken) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		if config.Username != "" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="hardware-events"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+func secureEqual(given, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(given), []byte(expected)) == 1
+}
+
+// healthHandler answers 200 when the check succeeds, or 503 with the error message
+func healthHandler(check func(time.Time) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		err := check(time.Now())
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprintln(w, err)
+			return
+		}
+		_, _ = fmt.Fprintln(w, "ok")
+	}
+}
+
+// statusHandler returns the hardware status in JSON
+func statusHandler(global *lib.Global) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(global.Status())
+		if err != nil {
+			clog.Errorf("cannot encode status: %s", err)
+		}
+	}
+}
+
+// inhibitHandler prevents a disk or a pool from being put in standby mode:
+// POST /inhibit?disk=name&duration=2h&reason=backup, or DELETE /inhibit?pool=name to release
+func inhibitHandler(global *lib.Global) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, name := lib.InhibitTargetDisk, r.FormValue(lib.InhibitTargetDisk)
+		if name == "" {
+			target, name = lib.InhibitTargetPool, r.FormValue(lib.InhibitTargetPool)
+		}
+		if name == "" {
+			http.Error(w, "missing disk or pool name", http.StatusBadRequest)
+			return
+		}
+		var duration time.Duration
+		switch r.Method {
+		case http.MethodPost:
+			var err error
+			duration, err = time.ParseDuration(r.FormValue("duration"))
+			if err != nil || duration <= 0 {
+				http.Error(w, "invalid duration", http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			// a duration of zero releases the inhibit
+		default:
+			w.Header().Set("Allow", "POST, DELETE")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		err := global.InhibitStandby(target, name, duration, r.FormValue("reason"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
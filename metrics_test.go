@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/cfg"
+	"github.com/creativeprojects/hardware-events/lib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMetricsTestGlobal(t *testing.T) *lib.Global {
+	t.Helper()
+	global, err := lib.NewGlobalFS(cfg.Config{FanControl: cfg.FanControl{SetCommand: "true"}}, os.DirFS(t.TempDir()))
+	require.NoError(t, err)
+	return global
+}
+
+func serve(handler http.Handler, request *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestMetricsHandlerAuthentication(t *testing.T) {
+	handler, err := newMetricsHandler(cfg.Prometheus{
+		Path:        "/prometheus",
+		Username:    "prometheus",
+		Password:    "secret",
+		BearerToken: "token",
+	}, newMetricsTestGlobal(t))
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/prometheus", nil)
+	response := serve(handler, request)
+	assert.Equal(t, http.StatusUnauthorized, response.Code)
+	assert.Contains(t, response.Header().Get("WWW-Authenticate"), "Basic")
+
+	request.SetBasicAuth("prometheus", "wrong")
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, request).Code)
+
+	request.SetBasicAuth("prometheus", "secret")
+	assert.Equal(t, http.StatusOK, serve(handler, request).Code)
+
+	request = httptest.NewRequest(http.MethodGet, "/prometheus", nil)
+	request.Header.Set("Authorization", "Bearer token")
+	assert.Equal(t, http.StatusOK, serve(handler, request).Code)
+
+	// the default path is not served anymore
+	request = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	request.Header.Set("Authorization", "Bearer token")
+	assert.Equal(t, http.StatusNotFound, serve(handler, request).Code)
+
+	// the inhibit endpoint is protected too
+	request = httptest.NewRequest(http.MethodDelete, "/inhibit?disk=sda", nil)
+	assert.Equal(t, http.StatusUnauthorized, serve(handler, request).Code)
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	global := newMetricsTestGlobal(t)
+	handler, err := newMetricsHandler(cfg.Prometheus{BearerToken: "token"}, global)
+	require.NoError(t, err)
+
+	// no authentication needed
+	response := serve(handler, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+	response = serve(handler, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Equal(t, "fan control not initialised\n", response.Body.String())
+
+	require.NoError(t, global.FanControl.Init())
+	global.FanControl.Start()
+	response = serve(handler, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, response.Code)
+	assert.Equal(t, "ok\n", response.Body.String())
+
+	// a critical loop has stopped
+	global.Health.Register("sensor zone1/cpu", true).Stop()
+	response = serve(handler, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, response.Code)
+	assert.Equal(t, "sensor zone1/cpu stopped\n", response.Body.String())
+}
+
+func TestInvalidMetricsConfiguration(t *testing.T) {
+	global := newMetricsTestGlobal(t)
+	_, err := newMetricsHandler(cfg.Prometheus{Path: "metrics"}, global)
+	assert.Error(t, err)
+	_, err = newMetricsHandler(cfg.Prometheus{Password: "secret"}, global)
+	assert.Error(t, err)
+	_, err = newServerTLSConfig(cfg.TLS{CAFile: "ca.pem"})
+	assert.Error(t, err)
+	_, err = newServerTLSConfig(cfg.TLS{CertFile: "does/not/exist.pem", KeyFile: "does/not/exist.key"})
+	assert.Error(t, err)
+}
+
+// writeCertificate generates a self-signed certificate for the common name
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.pem")
+	keyFile := filepath.Join(dir, "server.key")
+	writeCertificate(t, certFile, keyFile, "first")
+
+	tlsConfig, err := newServerTLSConfig(cfg.TLS{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	commonName := func() string {
+		certificate, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{})
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+		require.NoError(t, err)
+		return leaf.Subject.CommonName
+	}
+	assert.Equal(t, "first", commonName())
+
+	writeCertificate(t, certFile, keyFile, "renewed")
+	// make sure the modification time changed
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, later, later))
+	assert.Equal(t, "renewed", commonName())
+
+	// a broken certificate keeps the previous one
+	require.NoError(t, os.WriteFile(certFile, []byte("garbage"), 0o600))
+	assert.Equal(t, "renewed", commonName())
+}
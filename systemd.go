@@ -1,12 +1,18 @@
 package main
 
 import (
+	"strconv"
 	"time"
 
 	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/creativeprojects/clog"
+	"github.com/creativeprojects/hardware-events/lib"
+	"golang.org/x/sys/unix"
 )
 
+// statusEvery is how often the status line shown by systemctl is refreshed
+const statusEvery = 30 * time.Second
+
 func notifyReady() {
 	_, err := daemon.SdNotify(false, daemon.SdNotifyReady)
 	if err != nil {
@@ -18,7 +24,74 @@ func notifyLeaving() {
 	_, _ = daemon.SdNotify(false, daemon.SdNotifyStopping)
 }
 
-func setupWatchdog() {
+// notifyReloading tells systemd the configuration is reloading. The new process sends READY=1 when it's running
+func notifyReloading() {
+	_, _ = daemon.SdNotify(false, reloadingMessage(monotonicNow()))
+}
+
+// reloadingMessage needs the time of the reload on the monotonic clock, like systemd expects with Type=notify-reload
+func reloadingMessage(monotonic time.Duration) string {
+	return daemon.SdNotifyReloading + "\nMONOTONIC_USEC=" + strconv.FormatInt(monotonic.Microseconds(), 10) + "\nSTATUS=reloading configuration"
+}
+
+// monotonicNow returns the time of CLOCK_MONOTONIC, which is the clock used by systemd
+func monotonicNow() time.Duration {
+	var now unix.Timespec
+	err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &now)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(now.Nano())
+}
+
+// setupStatusNotifier sends a summary of the hardware to systemd at regular intervals, until the returned function is called
+func setupStatusNotifier(global *lib.Global) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(statusEvery)
+		defer ticker.Stop()
+		for {
+			sent, err := daemon.SdNotify(false, "STATUS="+statusLine(global))
+			if err != nil {
+				clog.Errorf("cannot notify systemd: %s", err)
+			}
+			if !sent {
+				// not running under systemd
+				return
+			}
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// statusLine returns the summary of the hardware, starting with the stale loops if any
+func statusLine(global *lib.Global) string {
+	summary := global.Summary()
+	if err := global.Health.Check(time.Now()); err != nil {
+		if summary == "" {
+			return "unhealthy: " + err.Error()
+		}
+		return "unhealthy: " + err.Error() + "; " + summary
+	}
+	if summary == "" {
+		return "running"
+	}
+	return summary
+}
+
+// setupWatchdog notifies the systemd watchdog as long as all the critical loops are alive:
+// systemd restarts the service when a loop is stuck for longer than WatchdogSec
+func setupWatchdog(health *lib.Health) {
 	interval, err := daemon.SdWatchdogEnabled(false)
 	if err != nil {
 		clog.Errorf("cannot verify if systemd watchdog is enabled: %s", err)
@@ -28,11 +101,24 @@ func setupWatchdog() {
 		// watchdog not enabled
 		return
 	}
+	unhealthy := ""
 	for {
-		// TODO: Check that the service is healthy?
-		_, err := daemon.SdNotify(false, daemon.SdNotifyWatchdog)
+		err := health.Check(time.Now())
 		if err != nil {
-			clog.Errorf("cannot notify systemd watchdog: %s", err)
+			if err.Error() != unhealthy {
+				// the stale loops are also reported in the status line
+				unhealthy = err.Error()
+				clog.Errorf("not notifying systemd watchdog: %s", unhealthy)
+			}
+		} else {
+			if unhealthy != "" {
+				unhealthy = ""
+				clog.Info("all loops are alive again")
+			}
+			_, err = daemon.SdNotify(false, daemon.SdNotifyWatchdog)
+			if err != nil {
+				clog.Errorf("cannot notify systemd watchdog: %s", err)
+			}
 		}
 		time.Sleep(interval / 3)
 	}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/creativeprojects/hardware-events/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusLine(t *testing.T) {
+	global := newMetricsTestGlobal(t)
+	assert.Equal(t, "running", statusLine(global))
+
+	global.Health = lib.NewHealth()
+	global.Health.Register("metrics server", true).Stop()
+	assert.Equal(t, "unhealthy: metrics server stopped", statusLine(global))
+
+	global.Health.Register("metrics server", true).Beat(time.Minute)
+	assert.Equal(t, "running", statusLine(global))
+}
+
+func TestReloadingMessage(t *testing.T) {
+	assert.Equal(t, "RELOADING=1\nMONOTONIC_USEC=1500000\nSTATUS=reloading configuration", reloadingMessage(1500*time.Millisecond))
+	assert.NotZero(t, monotonicNow())
+}
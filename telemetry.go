@@ -3,22 +3,64 @@ package main
 import (
 	"context"
 
+	"github.com/creativeprojects/clog"
 	"github.com/creativeprojects/hardware-events/cfg"
 	"github.com/creativeprojects/hardware-events/lib"
 	"go.opentelemetry.io/otel/exporters/prometheus"
+	"go.opentelemetry.io/otel/sdk/metric"
 )
 
 func setupTelemetry(config cfg.Config, global *lib.Global) (func(context.Context) error, error) {
-	if !config.Telemetry.Prometheus.Enabled {
-		return func(_ context.Context) error { return nil }, nil
+	readers := make([]metric.Reader, 0, 4)
+	if config.Telemetry.Prometheus.Enabled {
+		exporter, err := prometheus.New()
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, exporter)
 	}
-	exporter, err := prometheus.New()
-	if err != nil {
-		return nil, err
+	if config.Telemetry.OTLP.Enabled {
+		clog.Debugf("pushing metrics to OTLP endpoint %q", config.Telemetry.OTLP.Endpoint)
+		reader, err := lib.NewOTLPReader(context.Background(), config.Telemetry.OTLP)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, reader)
+	}
+	if config.Telemetry.InfluxDB.Enabled {
+		clog.Debugf("pushing metrics to InfluxDB at %q", config.Telemetry.InfluxDB.URL)
+		reader, err := lib.NewInfluxDBReader(config.Telemetry.InfluxDB)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, reader)
+	}
+	if config.Telemetry.Graphite.Enabled {
+		clog.Debugf("pushing metrics to graphite at %q", config.Telemetry.Graphite.Address)
+		reader, err := lib.NewGraphiteReader(config.Telemetry.Graphite)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, reader)
+	}
+	if len(readers) == 0 {
+		return func(_ context.Context) error { return nil }, nil
 	}
-	telemetry, err := lib.NewTelemetry(global, exporter)
+	telemetry, err := lib.NewTelemetry(global, readers...)
 	if err != nil {
 		return func(_ context.Context) error { return nil }, err
 	}
 	return telemetry.Shutdown, nil
 }
+
+func setupMQTT(config cfg.Config, global *lib.Global) (func(), error) {
+	if !config.Telemetry.MQTT.Enabled {
+		return func() {}, nil
+	}
+	client, err := lib.NewMQTT(global, config.Telemetry.MQTT)
+	if err != nil {
+		return nil, err
+	}
+	client.Start()
+	return client.Close, nil
+}